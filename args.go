@@ -113,6 +113,10 @@ func RangeArgs(min int, max int) PositionalArgs {
 // MatchAll allows combining several PositionalArgs to work in concert.
 func MatchAll(pargs ...PositionalArgs) PositionalArgs {
 	return func(cmd *Command, args []string) error {
+		// The validators are listed rather than run to describe the arguments of the command
+		if cmd == argsProbe {
+			return matchedArgs(pargs)
+		}
 		for _, parg := range pargs {
 			if err := parg(cmd, args); err != nil {
 				return err
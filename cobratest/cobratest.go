@@ -0,0 +1,78 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cobratest provides helpers to test programs built with Cobra: executing commands
+// in-process, requesting completions as the shells do, comparing help and usage with golden
+// files, and fuzzing the parsing of command lines.
+package cobratest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+
+	"github.com/Pritam499/clifusion"
+)
+
+// Result is the outcome of executing a command with Execute.
+type Result struct {
+	// Cmd is the command that was executed, or the one that failed to be found or parsed.
+	Cmd *cobra.Command
+	// Stdout and Stderr are the standard and error outputs, and Output the two interleaved
+	// as in a terminal.
+	Stdout string
+	Stderr string
+	Output string
+	// Err is the error returned by the execution.
+	Err error
+}
+
+// Execute executes root with args in-process, as the program would run with these arguments,
+// and captures its outputs. The standard input is empty, and the analytics database is a new
+// empty one, see cobra.IsolateAnalytics, so executions must not be concurrent. The outputs
+// and input of root are left redirected, and flags keep the values they were set to, so tests
+// usually build a new command tree for each execution.
+func Execute(root *cobra.Command, args ...string) Result {
+	return execute(context.Background(), root, strings.NewReader(""), args)
+}
+
+// ExecuteContext is Execute with the context ctx.
+func ExecuteContext(ctx context.Context, root *cobra.Command, args ...string) Result {
+	return execute(ctx, root, strings.NewReader(""), args)
+}
+
+// ExecuteWithInput is Execute with stdin as the standard input.
+func ExecuteWithInput(root *cobra.Command, stdin string, args ...string) Result {
+	return execute(context.Background(), root, strings.NewReader(stdin), args)
+}
+
+func execute(ctx context.Context, root *cobra.Command, stdin io.Reader, args []string) Result {
+	var stdout, stderr, output bytes.Buffer
+	root.SetOut(io.MultiWriter(&stdout, &output))
+	root.SetErr(io.MultiWriter(&stderr, &output))
+	root.SetIn(stdin)
+	// Nil arguments would make Cobra use the arguments of the test binary
+	root.SetArgs(append([]string{}, args...))
+
+	defer cobra.IsolateAnalytics()()
+	cmd, err := root.ExecuteContextC(ctx)
+	return Result{
+		Cmd:    cmd,
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+		Output: output.String(),
+		Err:    err,
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobratest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Pritam499/clifusion"
+)
+
+func newTestCLI() *cobra.Command {
+	rootCmd := &cobra.Command{Use: "app", Short: "An application"}
+	deployCmd := &cobra.Command{
+		Use:   "deploy <service>",
+		Short: "Deploy a service",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return cobra.AppendActiveHelp([]string{"api\tThe API", "web"}, "Pick a service"), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			input, _ := cmd.InOrStdin().(interface{ Len() int })
+			cmd.Printf("deploying %s", args[0])
+			if input != nil && input.Len() > 0 {
+				cmd.Print(" with input")
+			}
+			cmd.Println()
+			cmd.PrintErrln("done")
+			return nil
+		},
+	}
+	deployCmd.Flags().BoolP("force", "f", false, "deploy even if the checks fail")
+	deployCmd.Flags().String("region", "eu", "region to deploy to")
+	rootCmd.AddCommand(deployCmd)
+	return rootCmd
+}
+
+func TestExecute(t *testing.T) {
+	result := Execute(newTestCLI(), "deploy", "api")
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result.Cmd.Name() != "deploy" {
+		t.Errorf("Expected the deploy command, got %s", result.Cmd.Name())
+	}
+	if result.Stdout != "deploying api\n" || result.Stderr != "done\n" || result.Output != "deploying api\ndone\n" {
+		t.Errorf("Unexpected outputs %q, %q and %q", result.Stdout, result.Stderr, result.Output)
+	}
+
+	result = ExecuteWithInput(newTestCLI(), "data", "deploy", "api")
+	if result.Stdout != "deploying api with input\n" {
+		t.Errorf("Expected the input to be read, got %q", result.Stdout)
+	}
+
+	result = Execute(newTestCLI(), "deploy")
+	if result.Err == nil || !strings.Contains(result.Stderr, "accepts 1 arg(s)") {
+		t.Errorf("Expected an argument error, got %v and %q", result.Err, result.Stderr)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := map[string]struct {
+		line       string
+		values     []string
+		directive  cobra.ShellCompDirective
+		activeHelp []string
+	}{
+		"commands":  {line: "dep", values: []string{"deploy\tDeploy a service"}, directive: cobra.ShellCompDirectiveNoFileComp},
+		"flags":     {line: "deploy --fo", values: []string{"--force\tdeploy even if the checks fail"}, directive: cobra.ShellCompDirectiveNoFileComp},
+		"arguments": {line: "deploy ", values: []string{"api\tThe API", "web"}, directive: cobra.ShellCompDirectiveNoFileComp, activeHelp: []string{"Pick a service"}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			completions, err := Complete(newTestCLI(), tc.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var values []string
+			for _, choice := range completions.Choices {
+				value := choice.Value
+				if choice.Description != "" {
+					value += "\t" + choice.Description
+				}
+				values = append(values, value)
+			}
+			if !reflect.DeepEqual(values, tc.values) {
+				t.Errorf("Expected %q, got %q", tc.values, values)
+			}
+			if completions.Directive != tc.directive {
+				t.Errorf("Expected the directive %d, got %d", tc.directive, completions.Directive)
+			}
+			if !reflect.DeepEqual(completions.ActiveHelp, tc.activeHelp) {
+				t.Errorf("Expected the Active Help %q, got %q", tc.activeHelp, completions.ActiveHelp)
+			}
+		})
+	}
+}
+
+func TestParseCompletions(t *testing.T) {
+	completions, err := ParseCompletions("_activeHelp_ hint\na\tfirst\nb\n:4\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := completions.Values(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Unexpected values %q", got)
+	}
+	if got := completions.String(); got != "_activeHelp_ hint\na\tfirst\nb\n:4\n" {
+		t.Errorf("Unexpected formatting %q", got)
+	}
+	if _, err := ParseCompletions("a\nb\n"); err == nil {
+		t.Error("Expected an error without directive")
+	}
+}
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, format)
+}
+
+func TestAssertHelp(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "testdata", "deploy-help.golden")
+
+	rt := &recordingT{}
+	AssertHelp(rt, newTestCLI(), golden, "deploy")
+	if len(rt.errors) != 1 {
+		t.Fatalf("Expected a missing golden file to fail, got %q", rt.errors)
+	}
+
+	t.Setenv("APP_UPDATE_GOLDEN", "true")
+	AssertHelp(t, newTestCLI(), golden, "deploy")
+	content, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "Deploy a service") || !strings.Contains(string(content), "--region") {
+		t.Errorf("Unexpected golden help %q", content)
+	}
+
+	t.Setenv("APP_UPDATE_GOLDEN", "")
+	AssertHelp(t, newTestCLI(), golden, "deploy")
+
+	rootCmd := newTestCLI()
+	rootCmd.Commands()[0].Short = "Deploy"
+	rt = &recordingT{}
+	AssertHelp(rt, rootCmd, golden, "deploy")
+	if len(rt.errors) != 1 {
+		t.Errorf("Expected a changed help to fail, got %q", rt.errors)
+	}
+}
+
+func TestAssertUsage(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "usage.golden")
+	t.Setenv("COBRA_UPDATE_GOLDEN", "1")
+	deployCmd, _, _ := newTestCLI().Find([]string{"deploy"})
+	AssertUsage(t, deployCmd, golden)
+
+	t.Setenv("COBRA_UPDATE_GOLDEN", "")
+	AssertUsage(t, deployCmd, golden)
+	rt := &recordingT{}
+	deployCmd.Flags().Int("count", 1, "number of replicas")
+	AssertUsage(rt, deployCmd, golden)
+	if len(rt.errors) != 1 {
+		t.Errorf("Expected a changed usage to fail, got %q", rt.errors)
+	}
+}
+
+func TestUpdateGoldenEnvVar(t *testing.T) {
+	if got := updateGoldenEnvVar("my-app.v2"); got != "MY_APP_V2_UPDATE_GOLDEN" {
+		t.Errorf("Unexpected environment variable %s", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	cmd, err := Parse(newTestCLI(), "deploy", "-f", "--region", "us", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if region, _ := cmd.Flags().GetString("region"); cmd.Name() != "deploy" || region != "us" {
+		t.Errorf("Unexpected command %s with --region=%s", cmd.Name(), region)
+	}
+	if _, err := Parse(newTestCLI(), "deploy", "--unknown", "api"); err == nil {
+		t.Error("Expected an unknown flag to be rejected")
+	}
+	if _, err := Parse(newTestCLI(), "deploy"); err == nil {
+		t.Error("Expected missing arguments to be rejected")
+	}
+}
+
+func TestSeedArgs(t *testing.T) {
+	seeds := SeedArgs(newTestCLI())
+	for _, expected := range [][]string{{}, {"deploy"}, {"deploy", "--region=eu"}, {"deploy", "-f"}} {
+		found := false
+		for _, seed := range seeds {
+			found = found || reflect.DeepEqual(seed, expected)
+		}
+		if !found {
+			t.Errorf("Expected the seed %q in %q", expected, seeds)
+		}
+	}
+}
+
+func FuzzParseTestCLI(f *testing.F) {
+	FuzzParse(f, newTestCLI)
+}
@@ -0,0 +1,109 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobratest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Pritam499/clifusion"
+)
+
+// activeHelpMarker prefixes the Active Help messages in the output of the completion command,
+// see cobra.AppendActiveHelp.
+const activeHelpMarker = "_activeHelp_ "
+
+// Choice is a completion choice.
+type Choice struct {
+	Value       string
+	Description string
+}
+
+// Completions are the completions of a command line, as the completion scripts of the shells
+// receive them.
+type Completions struct {
+	Choices   []Choice
+	Directive cobra.ShellCompDirective
+	// ActiveHelp are the Active Help messages.
+	ActiveHelp []string
+}
+
+// Values returns the values of the choices.
+func (c Completions) Values() []string {
+	values := make([]string, len(c.Choices))
+	for i, choice := range c.Choices {
+		values[i] = choice.Value
+	}
+	return values
+}
+
+// String formats the completions as the completion command prints them, which is convenient
+// to compare with a golden file or an expected value.
+func (c Completions) String() string {
+	var b strings.Builder
+	for _, help := range c.ActiveHelp {
+		fmt.Fprintf(&b, "%s%s\n", activeHelpMarker, help)
+	}
+	for _, choice := range c.Choices {
+		if choice.Description != "" {
+			fmt.Fprintf(&b, "%s\t%s\n", choice.Value, choice.Description)
+		} else {
+			fmt.Fprintln(&b, choice.Value)
+		}
+	}
+	fmt.Fprintf(&b, ":%d\n", c.Directive)
+	return b.String()
+}
+
+// Complete returns the completions of the command line of root being typed, such as
+// "sub --fla". The last word is the one completed, an empty one if the line ends with a space.
+// The words of the line are separated by spaces, quotes are not interpreted.
+func Complete(root *cobra.Command, line string) (Completions, error) {
+	args := append([]string{cobra.ShellCompRequestCmd}, strings.Fields(line)...)
+	if strings.TrimSpace(line) == "" || strings.HasSuffix(line, " ") {
+		args = append(args, "")
+	}
+	result := Execute(root, args...)
+	if result.Err != nil {
+		return Completions{}, result.Err
+	}
+	return ParseCompletions(result.Stdout)
+}
+
+// ParseCompletions parses the output of the hidden completion command of Cobra programs: one
+// completion per line, with its description after a tab, and the directive on the last line.
+func ParseCompletions(output string) (Completions, error) {
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	last := lines[len(lines)-1]
+	if !strings.HasPrefix(last, ":") {
+		return Completions{}, fmt.Errorf("missing completion directive in %q", output)
+	}
+	directive, err := strconv.Atoi(last[1:])
+	if err != nil {
+		return Completions{}, fmt.Errorf("invalid completion directive %q", last)
+	}
+
+	c := Completions{Directive: cobra.ShellCompDirective(directive)}
+	for _, line := range lines[:len(lines)-1] {
+		if help, ok := strings.CutPrefix(line, activeHelpMarker); ok {
+			c.ActiveHelp = append(c.ActiveHelp, help)
+			continue
+		}
+		value, description, _ := strings.Cut(line, "\t")
+		c.Choices = append(c.Choices, Choice{Value: value, Description: description})
+	}
+	return c, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobratest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Pritam499/clifusion"
+	"github.com/spf13/pflag"
+)
+
+// Parse finds the command of root the arguments args select, and parses and validates its
+// flags and arguments as Execute would, without running any hook or command. It returns the
+// command found and the first error.
+func Parse(root *cobra.Command, args ...string) (*cobra.Command, error) {
+	root.InitDefaultHelpCmd()
+	find := root.Find
+	if root.TraverseChildren {
+		find = root.Traverse
+	}
+	cmd, flags, err := find(args)
+	if err != nil {
+		return cmd, err
+	}
+	cmd.InitDefaultHelpFlag()
+	cmd.InitDefaultVersionFlag()
+	if err := cmd.ParseFlags(flags); err != nil {
+		return cmd, err
+	}
+	positional := cmd.Flags().Args()
+	if cmd.DisableFlagParsing {
+		positional = flags
+	}
+	if err := cmd.ValidateArgs(positional); err != nil {
+		return cmd, err
+	}
+	if err := cmd.ValidateRequiredFlags(); err != nil {
+		return cmd, err
+	}
+	return cmd, cmd.ValidateFlagGroups()
+}
+
+// SeedArgs returns command lines of root to seed the corpus of a fuzz target: the path of
+// each command, alone and followed by each of its flags.
+func SeedArgs(root *cobra.Command) [][]string {
+	root.InitDefaultHelpCmd()
+	var seeds [][]string
+	var visit func(cmd *cobra.Command, path []string)
+	visit = func(cmd *cobra.Command, path []string) {
+		seeds = append(seeds, path)
+		cmd.InitDefaultHelpFlag()
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			seeds = append(seeds, append(append([]string{}, path...), "--"+f.Name+"="+f.DefValue))
+			if f.Shorthand != "" {
+				seeds = append(seeds, append(append([]string{}, path...), "-"+f.Shorthand))
+			}
+		})
+		for _, sub := range cmd.Commands() {
+			visit(sub, append(append([]string{}, path...), sub.Name()))
+		}
+	}
+	visit(root, []string{})
+	return seeds
+}
+
+// FuzzParse fuzzes the parsing of the command lines of the command tree newRoot returns, seeded
+// with SeedArgs. The arguments of a command line are separated by NUL bytes in the corpus. It
+// checks that parsing neither panics nor depends on anything but the arguments, and that it
+// selects a command of the tree:
+//
+//	func FuzzParse(f *testing.F) {
+//		cobratest.FuzzParse(f, newRootCmd)
+//	}
+//
+// Parsing changes the flags of the commands, so newRoot must return a new tree on each call.
+func FuzzParse(f *testing.F, newRoot func() *cobra.Command) {
+	for _, args := range SeedArgs(newRoot()) {
+		f.Add(joinFuzzArgs(args))
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		args := splitFuzzArgs(line)
+		root := newRoot()
+		cmd, err := Parse(root, args...)
+		if cmd != nil && cmd.Root() != root {
+			t.Fatalf("Parse(%q) returned the command %s out of the tree", args, cmd.CommandPath())
+		}
+		again, againErr := Parse(newRoot(), args...)
+		if describeParse(cmd, err) != describeParse(again, againErr) {
+			t.Fatalf("Parse(%q) is not deterministic: %s, then %s",
+				args, describeParse(cmd, err), describeParse(again, againErr))
+		}
+	})
+}
+
+func joinFuzzArgs(args []string) string {
+	return strings.Join(args, "\x00")
+}
+
+func splitFuzzArgs(line string) []string {
+	if line == "" {
+		return []string{}
+	}
+	return strings.Split(line, "\x00")
+}
+
+func describeParse(cmd *cobra.Command, err error) string {
+	path := "<nil>"
+	if cmd != nil {
+		path = cmd.CommandPath()
+		// The values of the flags are part of the result, compare them too
+		var values []string
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			values = append(values, f.Name+"="+f.Value.String())
+		})
+		path += " " + strings.Join(values, " ")
+	}
+	if err != nil {
+		return fmt.Sprintf("%s: %v", path, err)
+	}
+	return path
+}
@@ -0,0 +1,102 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobratest
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Pritam499/clifusion"
+	"github.com/Pritam499/clifusion/internal/diff"
+)
+
+// T is the part of testing.TB used by the assertions.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// updateGoldenEnvVarSuffix names the environment variables <PROGRAM>_UPDATE_GOLDEN and
+// COBRA_UPDATE_GOLDEN, which make the assertions write the golden files when set to true.
+const updateGoldenEnvVarSuffix = "UPDATE_GOLDEN"
+
+var envVarSubstRegexp = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// AssertHelp fails the test t if the help of the command of root at path, such as "deploy",
+// differs from the golden file. Setting the <PROGRAM>_UPDATE_GOLDEN or COBRA_UPDATE_GOLDEN
+// environment variable to true writes the golden file instead:
+//
+//	func TestHelp(t *testing.T) {
+//		cobratest.AssertHelp(t, newRootCmd(), "testdata/deploy-help.golden", "deploy")
+//	}
+func AssertHelp(t T, root *cobra.Command, golden string, path ...string) {
+	t.Helper()
+	result := Execute(root, append(append([]string{}, path...), "--help")...)
+	if result.Err != nil {
+		t.Errorf("cannot print the help of %s: %v", strings.Join(append([]string{root.Name()}, path...), " "), result.Err)
+		return
+	}
+	assertGolden(t, root, "help of "+result.Cmd.CommandPath(), golden, result.Stdout)
+}
+
+// AssertUsage fails the test t if the usage of cmd differs from the golden file, which is
+// updated like with AssertHelp.
+func AssertUsage(t T, cmd *cobra.Command, golden string) {
+	t.Helper()
+	assertGolden(t, cmd.Root(), "usage of "+cmd.CommandPath(), golden, cmd.UsageString())
+}
+
+func assertGolden(t T, root *cobra.Command, what, golden, got string) {
+	t.Helper()
+	envVar := updateGoldenEnvVar(root.Name())
+	if updateGolden(envVar) {
+		err := os.MkdirAll(filepath.Dir(golden), 0755)
+		if err == nil {
+			err = os.WriteFile(golden, []byte(got), 0644)
+		}
+		if err != nil {
+			t.Errorf("cannot update the golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Errorf("cannot read the golden file: %v\nset %s=true to create it", err, envVar)
+		return
+	}
+	if string(want) != got {
+		t.Errorf("%s differs from the golden file %s:\n%s\nset %s=true to update it",
+			what, golden, diff.Unified(golden, "got", string(want), got), envVar)
+	}
+}
+
+// updateGoldenEnvVar returns the name of the program-specific environment variable updating
+// golden files, formatted like the other configuration environment variables of Cobra.
+func updateGoldenEnvVar(program string) string {
+	return envVarSubstRegexp.ReplaceAllString(strings.ToUpper(program+"_"+updateGoldenEnvVarSuffix), "_")
+}
+
+func updateGolden(envVar string) bool {
+	v := os.Getenv(envVar)
+	if v == "" {
+		v = os.Getenv("COBRA_" + updateGoldenEnvVarSuffix)
+	}
+	update, _ := strconv.ParseBool(v)
+	return update
+}
@@ -257,6 +257,10 @@ type Command struct {
 	// completionCommandGroupID is the group id for the completion command
 	completionCommandGroupID string
 
+	// builtin is set for the commands added by Cobra rather than the program, such as the
+	// default help command. They are left out of the command schemas, see SnapshotSchemas.
+	builtin bool
+
 	// versionTemplate is the version template defined by user.
 	versionTemplate *tmplFunc
 
@@ -276,6 +280,12 @@ type Command struct {
 	// CompletionOptions is a set of options to control the handling of shell completion
 	CompletionOptions CompletionOptions
 
+	// TemplateOptions controls whether saved templates are exposed as subcommands of the root command
+	TemplateOptions TemplateOptions
+
+	// PluginOptions controls whether and from where the root command loads plugins
+	PluginOptions PluginOptions
+
 	// commandsAreSorted defines, if command slice are sorted or not.
 	commandsAreSorted bool
 	// commandCalledAs is the name or alias value used to call this command.
@@ -286,6 +296,16 @@ type Command struct {
 
 	ctx context.Context
 
+	// pluginReport is the outcome of loading plugins, set on the root command only.
+	pluginReport *PluginLoadReport
+	// loadedPlugins records the changes of every loaded plugin so they can be unloaded.
+	loadedPlugins []*loadedPlugin
+	// pluginsStopped is set once loaded plugins are shut down at the end of an execution, so
+	// the next execution loads them again.
+	pluginsStopped bool
+	// pluginFinalizer is set once the OnFinalize hook shutting down the plugins is registered.
+	pluginFinalizer bool
+
 	// commands is the list of commands supported by this program.
 	commands []*Command
 	// parent is a parent command for this command.
@@ -327,8 +347,9 @@ type Command struct {
 	// Must be > 0.
 	SuggestionsMinimumDistance int
 
-	// Middlewares are functions executed before the command's Run function.
-	// They can be used for logging, authentication, etc.
+	// Middlewares are functions executed before the Run function of the command and of its
+	// subcommands, after the middlewares of the parents. They can be used for logging,
+	// authentication, etc.
 	Middlewares []func(cmd *Command, args []string) error
 
 	// InputType and OutputType for pipeline type safety
@@ -1158,17 +1179,16 @@ func (c *Command) execute(a []string) (err error) {
 		return err
 	}
 
-	// Register command version
-	if GlobalVersionManager != nil && c.Version != "" {
-		if err := GlobalVersionManager.RegisterCommand(c, c.Version); err != nil {
-			return fmt.Errorf("version registration failed: %v", err)
-		}
+	// Run the middlewares of the commands from the root to this one
+	var path []*Command
+	for p := c; p != nil; p = p.Parent() {
+		path = append([]*Command{p}, path...)
 	}
-
-	// Run global middlewares (from root)
-	for _, mw := range c.Root().Middlewares {
-		if err := mw(c, argWoFlags); err != nil {
-			return err
+	for _, p := range path {
+		for _, mw := range p.Middlewares {
+			if err := mw(c, argWoFlags); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -1179,6 +1199,9 @@ func (c *Command) execute(a []string) (err error) {
 	} else {
 		c.Run(c, argWoFlags)
 	}
+	if err != nil {
+		return err
+	}
 	if c.PostRunE != nil {
 		if err := c.PostRunE(c, argWoFlags); err != nil {
 			return err
@@ -1278,15 +1301,24 @@ func (c *Command) ExecuteC() (cmd *Command, err error) {
 	c.checkCommandGroups()
 
 	// Load plugins
-	LoadPlugins(c)
+	c.initPlugins(args)
 
 	// Add built-in commands
-	c.AddCommand(CreateStatsCommand())
-	c.AddCommand(CreateTemplateCommand())
-	c.AddCommand(CreateTestCommand())
-	c.AddCommand(CreateWebBuilderCommand())
-	c.AddCommand(CreateDistributedCommand())
-	c.AddCommand(CreateVersioningCommand())
+	c.addBuiltinCommands(CreateStatsCommand())
+	c.addBuiltinCommands(CreateTemplateCommand())
+	c.addBuiltinCommands(CreateTestCommand())
+	c.addBuiltinCommands(CreateWebBuilderCommand())
+	c.addBuiltinCommands(CreateDistributedCommand())
+	c.addBuiltinCommands(CreateVersioningCommand())
+	if c.PluginOptions.Enabled {
+		c.addBuiltinCommands(CreatePluginCommand())
+	}
+
+	if c.TemplateOptions.RegisterCommands {
+		if err := c.registerTemplateCommands(NewTemplateManager()); err != nil {
+			c.PrintErrln("Warning: failed to register template commands:", err)
+		}
+	}
 
 	// Check for --wizard flag
 	wizard := false
@@ -1307,6 +1339,15 @@ func (c *Command) ExecuteC() (cmd *Command, err error) {
 		}
 	}
 
+	// Check the invocation against the version it is pinned to
+	if err := c.checkPinnedVersion(args); err != nil {
+		if !c.SilenceErrors {
+			c.PrintErrln(c.ErrPrefix(), err.Error())
+		}
+		return c, err
+	}
+	args = c.rewriteArgs(args)
+
 	// Check for pipeline
 	if strings.Contains(strings.Join(args, " "), "|") {
 		return executePipeline(c, args)
@@ -1503,6 +1544,7 @@ Simply type ` + c.DisplayName() + ` help [path to command] for full details.`,
 				}
 			},
 			GroupID: c.helpCommandGroupID,
+			builtin: true,
 		}
 	}
 	c.RemoveCommand(c.helpCommand)
@@ -1534,6 +1576,19 @@ func (c *Command) Commands() []*Command {
 	return c.commands
 }
 
+// addBuiltinCommands adds commands provided by Cobra rather than the program, except those
+// named like a command c already has, such as the program's own or one added by a previous
+// execution.
+func (c *Command) addBuiltinCommands(cmds ...*Command) {
+	for _, cmd := range cmds {
+		if c.hasSubCommandNamed(cmd.Name()) {
+			continue
+		}
+		cmd.builtin = true
+		c.AddCommand(cmd)
+	}
+}
+
 // AddCommand adds one or more commands to this parent command.
 func (c *Command) AddCommand(cmds ...*Command) {
 	for i, x := range cmds {
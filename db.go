@@ -52,6 +52,43 @@ func InitAnalyticsDB() error {
 	return GlobalAnalyticsDB.initSchema()
 }
 
+// OpenAnalyticsDB opens the analytics database in the file at dbPath, creating it if needed.
+func OpenAnalyticsDB(dbPath string) (*AnalyticsDB, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	a := &AnalyticsDB{db: db}
+	if err := a.initSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// IsolateAnalytics replaces GlobalAnalyticsDB with a new empty database, disabling analytics
+// if it cannot be created, and returns a function closing and removing it and restoring
+// GlobalAnalyticsDB. Commands run in between, typically by tests, neither depend on nor
+// record the commands previously run on the machine.
+func IsolateAnalytics() (restore func()) {
+	global := GlobalAnalyticsDB
+	GlobalAnalyticsDB = nil
+	dir, err := os.MkdirTemp("", "cobra_analytics")
+	if err != nil {
+		return func() { GlobalAnalyticsDB = global }
+	}
+	if db, err := OpenAnalyticsDB(filepath.Join(dir, "analytics.db")); err == nil {
+		GlobalAnalyticsDB = db
+	}
+	return func() {
+		if GlobalAnalyticsDB != nil {
+			GlobalAnalyticsDB.Close()
+		}
+		GlobalAnalyticsDB = global
+		os.RemoveAll(dir)
+	}
+}
+
 func (a *AnalyticsDB) initSchema() error {
 	_, err := a.db.Exec(`
 		CREATE TABLE IF NOT EXISTS command_usage (
@@ -0,0 +1,65 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	lines := func(from, to int) string {
+		var b strings.Builder
+		for i := from; i <= to; i++ {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+		return b.String()
+	}
+	tests := map[string]struct {
+		from, to string
+		expected string
+	}{
+		"equal": {from: "a\nb\n", to: "a\nb\n", expected: ""},
+		"changed line": {
+			from:     lines(1, 10),
+			to:       strings.Replace(lines(1, 10), "5\n", "five\n", 1),
+			expected: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		"separate hunks": {
+			from:     lines(1, 20),
+			to:       "0\n" + strings.Replace(lines(1, 20), "18\n", "", 1),
+			expected: "--- a\n+++ b\n@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -15,6 +16,5 @@\n 15\n 16\n 17\n-18\n 19\n 20\n",
+		},
+		"joined hunks": {
+			from:     lines(1, 8),
+			to:       strings.NewReplacer("2\n", "two\n", "7\n", "seven\n").Replace(lines(1, 8)),
+			expected: "--- a\n+++ b\n@@ -1,8 +1,8 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n-7\n+seven\n 8\n",
+		},
+		"no newline": {
+			from:     "a\nb\n",
+			to:       "a\nb",
+			expected: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		"empty": {from: "", to: "a\n", expected: "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := Unified("a", "b", tc.from, tc.to); got != tc.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// CreatePluginCommand returns the "plugin" command used to inspect, enable, disable and approve
+// plugins, and to manage the keys plugins may be signed with.
+func CreatePluginCommand() *Command {
+	cmd := &Command{
+		Use:   "plugin",
+		Short: "Manage plugins",
+	}
+
+	listCmd := &Command{
+		Use:   "list",
+		Short: "List installed plugins",
+		Args:  NoArgs,
+		RunE: func(cmd *Command, args []string) error {
+			plugins, failed, err := installedPlugins(cmd.Root())
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVERSION\tAPI\tSTATUS\tDESCRIPTION")
+			for _, p := range plugins {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Manifest.Name, valueOr(p.Manifest.Version, "-"),
+					valueOr(p.Manifest.APIVersion, PluginAPIVersion), pluginStatus(p, failed), p.Manifest.Description)
+			}
+			return w.Flush()
+		},
+	}
+
+	infoCmd := &Command{
+		Use:               "info <name>",
+		Short:             "Show the manifest and status of a plugin",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completePluginNames,
+		RunE: func(cmd *Command, args []string) error {
+			plugins, failed, err := installedPlugins(cmd.Root())
+			if err != nil {
+				return err
+			}
+			p, err := findPlugin(plugins, args[0])
+			if err != nil {
+				return err
+			}
+			m := p.Manifest
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintf(w, "Name:\t%s\n", m.Name)
+			fmt.Fprintf(w, "Version:\t%s\n", valueOr(m.Version, "-"))
+			fmt.Fprintf(w, "Description:\t%s\n", valueOr(m.Description, "-"))
+			fmt.Fprintf(w, "API version:\t%s (host provides %s)\n", valueOr(m.APIVersion, PluginAPIVersion), PluginAPIVersion)
+			fmt.Fprintf(w, "Entrypoint:\t%s\n", valueOr(p.Path, "built into the program"))
+			fmt.Fprintf(w, "Manifest:\t%s\n", valueOr(p.ManifestFile, "-"))
+			fmt.Fprintf(w, "Permissions:\t%s\n", valueOr(strings.Join(m.Permissions, ", "), "-"))
+			fmt.Fprintf(w, "Dependencies:\t%s\n", valueOr(strings.Join(m.Dependencies, ", "), "-"))
+			fmt.Fprintf(w, "Signature:\t%s\n", pluginSignature(cmd.Root(), p))
+			fmt.Fprintf(w, "Status:\t%s\n", pluginStatus(p, failed))
+			return w.Flush()
+		},
+	}
+
+	enableCmd := &Command{
+		Use:               "enable <name>",
+		Short:             "Enable a plugin",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completePluginNames,
+		RunE: func(cmd *Command, args []string) error {
+			return setInstalledPluginDisabled(cmd.Root(), args[0], false)
+		},
+	}
+
+	disableCmd := &Command{
+		Use:               "disable <name>",
+		Short:             "Disable a plugin",
+		Long:              "Disable a plugin. Plugins depending on it are not loaded either.",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completePluginNames,
+		RunE: func(cmd *Command, args []string) error {
+			return setInstalledPluginDisabled(cmd.Root(), args[0], true)
+		},
+	}
+
+	approveCmd := &Command{
+		Use:   "approve <name>",
+		Short: "Approve the current content of a plugin",
+		Long: `Approve the current content of a plugin.
+
+When plugin signatures are checked, a plugin that was modified since it was approved
+is not loaded until it is approved again.`,
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completePluginNames,
+		RunE: func(cmd *Command, args []string) error {
+			// Approval does not depend on whether the plugin is disabled
+			plugins, err := findPlugins(cmd.Root(), &pluginState{})
+			if err != nil {
+				return err
+			}
+			p, err := findPlugin(plugins, args[0])
+			if err != nil {
+				return err
+			}
+			return approvePlugin(cmd.Root(), p)
+		},
+	}
+
+	cmd.AddCommand(listCmd, infoCmd, enableCmd, disableCmd, approveCmd, createPluginTrustCommand())
+	return cmd
+}
+
+func createPluginTrustCommand() *Command {
+	cmd := &Command{
+		Use:   "trust",
+		Short: "Manage the keys plugins may be signed with",
+	}
+
+	addCmd := &Command{
+		Use:   "add <name> <key>",
+		Short: "Trust a public key",
+		Long: `Trust a public key. The key is a base64 encoded ed25519 public key,
+or a file containing one.`,
+		Args: ExactArgs(2),
+		RunE: func(cmd *Command, args []string) error {
+			key := args[1]
+			if data, err := os.ReadFile(key); err == nil {
+				key = strings.TrimSpace(string(data))
+			}
+			store, err := readPluginTrustStore(cmd.Root())
+			if err != nil {
+				return err
+			}
+			if err := store.add(args[0], key); err != nil {
+				return err
+			}
+			return store.write()
+		},
+	}
+
+	removeCmd := &Command{
+		Use:               "remove <name>",
+		Short:             "Stop trusting a public key",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeTrustedKeyNames,
+		RunE: func(cmd *Command, args []string) error {
+			store, err := readPluginTrustStore(cmd.Root())
+			if err != nil {
+				return err
+			}
+			if err := store.remove(args[0]); err != nil {
+				return err
+			}
+			return store.write()
+		},
+	}
+
+	listCmd := &Command{
+		Use:   "list",
+		Short: "List the trusted public keys",
+		Args:  NoArgs,
+		RunE: func(cmd *Command, args []string) error {
+			store, err := readPluginTrustStore(cmd.Root())
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tKEY")
+			for _, k := range store.Keys {
+				fmt.Fprintf(w, "%s\t%s\n", k.Name, k.Key)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.AddCommand(addCmd, removeCmd, listCmd)
+	return cmd
+}
+
+func installedPlugins(rootCmd *Command) ([]*discoveredPlugin, map[string]error, error) {
+	state, err := readPluginState(pluginDirs(rootCmd)[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	plugins, err := findPlugins(rootCmd, state)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkPluginTrust(rootCmd, plugins, false); err != nil {
+		return nil, nil, err
+	}
+	_, failed := orderPlugins(plugins)
+	return plugins, failed, nil
+}
+
+func findPlugin(plugins []*discoveredPlugin, name string) (*discoveredPlugin, error) {
+	for _, p := range plugins {
+		if p.Manifest.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("plugin %s is not installed", name)
+}
+
+func setInstalledPluginDisabled(rootCmd *Command, name string, disabled bool) error {
+	plugins, _, err := installedPlugins(rootCmd)
+	if err != nil {
+		return err
+	}
+	if _, err := findPlugin(plugins, name); err != nil {
+		return err
+	}
+	return setPluginDisabled(pluginDirs(rootCmd)[0], name, disabled)
+}
+
+func pluginStatus(p *discoveredPlugin, failed map[string]error) string {
+	if p.Err != nil {
+		return "invalid: " + p.Err.Error()
+	}
+	if p.Disabled {
+		return "disabled"
+	}
+	if err := failed[p.Manifest.Name]; err != nil {
+		return "not loadable: " + err.Error()
+	}
+	return "enabled"
+}
+
+func completePluginNames(cmd *Command, args []string, toComplete string) ([]Completion, ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, ShellCompDirectiveNoFileComp
+	}
+	plugins, _, err := installedPlugins(cmd.Root())
+	if err != nil {
+		return nil, ShellCompDirectiveError
+	}
+	var comps []Completion
+	for _, p := range plugins {
+		if strings.HasPrefix(p.Manifest.Name, toComplete) {
+			comps = append(comps, CompletionWithDesc(p.Manifest.Name, valueOr(p.Manifest.Description, "plugin")))
+		}
+	}
+	return comps, ShellCompDirectiveNoFileComp
+}
+
+func completeTrustedKeyNames(cmd *Command, args []string, toComplete string) ([]Completion, ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, ShellCompDirectiveNoFileComp
+	}
+	store, err := readPluginTrustStore(cmd.Root())
+	if err != nil {
+		return nil, ShellCompDirectiveError
+	}
+	var comps []Completion
+	for _, k := range store.Keys {
+		if strings.HasPrefix(k.Name, toComplete) {
+			comps = append(comps, k.Name)
+		}
+	}
+	return comps, ShellCompDirectiveNoFileComp
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
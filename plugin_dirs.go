@@ -0,0 +1,90 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// PluginOptions controls whether and from where a root command loads plugins.
+type PluginOptions struct {
+	// Enabled loads the plugins when the root command is executed, and adds the "plugin"
+	// command. Programs that do not set it load no plugins unless they call LoadPlugins.
+	Enabled bool
+	// AppName scopes the data directory of the program, which holds the default plugin
+	// directory, e.g. $XDG_DATA_HOME/<AppName>/plugins on Linux, as well as the keys and
+	// checksums plugins are checked against. Defaults to the name of the root command.
+	AppName string
+	// Dirs are searched for plugins after the default plugin directory. A plugin found in
+	// an earlier directory shadows the plugins of the same name found in later ones.
+	Dirs []string
+}
+
+// pluginPathEnvVar names the environment variable, <PROGRAM>_PLUGIN_PATH or
+// COBRA_PLUGIN_PATH, holding a list of directories that replaces the plugin directories.
+const pluginPathEnvVar = "PLUGIN_PATH"
+
+// pluginDataDir returns the data directory of the program of rootCmd.
+func pluginDataDir(rootCmd *Command) string {
+	name := rootCmd.PluginOptions.AppName
+	if name == "" {
+		name = rootCmd.Name()
+	}
+	return filepath.Join(userDataDir(), name)
+}
+
+// defaultPluginDir returns the directory plugins are installed in by default.
+func defaultPluginDir(rootCmd *Command) string {
+	return filepath.Join(pluginDataDir(rootCmd), "plugins")
+}
+
+// pluginDirs returns the directories searched for plugins, in order. The first one also holds
+// the list of disabled plugins.
+func pluginDirs(rootCmd *Command) []string {
+	if v := getEnvConfig(rootCmd, pluginPathEnvVar); v != "" {
+		var dirs []string
+		for _, dir := range filepath.SplitList(v) {
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) > 0 {
+			return dirs
+		}
+	}
+	return append([]string{defaultPluginDir(rootCmd)}, rootCmd.PluginOptions.Dirs...)
+}
+
+// userDataDir returns the directory holding the data of the programs of the user:
+// $XDG_DATA_HOME, or ~/.local/share, on Linux and other Unix systems.
+func userDataDir() string {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return dir
+		}
+	case "darwin", "ios":
+		return filepath.Join(os.Getenv("HOME"), "Library", "Application Support")
+	default:
+		// Relative paths are invalid according to the XDG base directory specification.
+		if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+			return dir
+		}
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share")
+}
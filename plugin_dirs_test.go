@@ -0,0 +1,108 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPluginDirs(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG base directories only apply to Linux and other Unix systems")
+	}
+	home := setupPluginHome(t)
+	data := filepath.Join(home, "data")
+	t.Setenv("XDG_DATA_HOME", data)
+
+	rootCmd := &Command{Use: "app"}
+	if dirs := pluginDirs(rootCmd); !reflect.DeepEqual(dirs, []string{filepath.Join(data, "app", "plugins")}) {
+		t.Errorf("Expected the plugin directory of the app in XDG_DATA_HOME, got %v", dirs)
+	}
+
+	rootCmd.PluginOptions = PluginOptions{AppName: "suite", Dirs: []string{"/opt/app/plugins"}}
+	expected := []string{filepath.Join(data, "suite", "plugins"), "/opt/app/plugins"}
+	if dirs := pluginDirs(rootCmd); !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("Expected %v, got %v", expected, dirs)
+	}
+
+	t.Setenv("APP_PLUGIN_PATH", strings.Join([]string{"/a", "/b"}, string(filepath.ListSeparator)))
+	if dirs := pluginDirs(rootCmd); !reflect.DeepEqual(dirs, []string{"/a", "/b"}) {
+		t.Errorf("Expected the environment to replace the plugin directories, got %v", dirs)
+	}
+
+	t.Setenv("APP_PLUGIN_PATH", "")
+	t.Setenv("XDG_DATA_HOME", "relative")
+	if dir := defaultPluginDir(rootCmd); dir != filepath.Join(home, ".local", "share", "suite", "plugins") {
+		t.Errorf("Expected a relative XDG_DATA_HOME to be ignored, got %s", dir)
+	}
+}
+
+func TestPluginsNotLoadedUnlessEnabled(t *testing.T) {
+	setupPluginHome(t)
+	writePluginFile(t, testPluginDir(), "greet.lua", `add_command{use = "greet", run = function(cmd, args) end}`)
+
+	rootCmd := &Command{Use: "root", Run: emptyRun}
+	rootCmd.SetArgs([]string{})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if rootCmd.PluginLoadReport() != nil || hasCommand(rootCmd, "greet") {
+		t.Error("Expected no plugins to be loaded")
+	}
+	if hasCommand(rootCmd, "plugin") {
+		t.Error("Expected no plugin command to be added")
+	}
+
+	rootCmd = &Command{Use: "root", Run: emptyRun, PluginOptions: PluginOptions{Enabled: true}}
+	rootCmd.SetArgs([]string{})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !hasCommand(rootCmd, "greet") || !hasCommand(rootCmd, "plugin") {
+		t.Error("Expected the plugins and the plugin command once enabled")
+	}
+}
+
+func TestPluginDirsAreScopedAndOrdered(t *testing.T) {
+	setupPluginHome(t)
+	extra := t.TempDir()
+	writePluginFile(t, testPluginDir(), "greet.lua", `add_command{use = "greet", short = "first"}`)
+	writePluginFile(t, extra, "greet.lua", `add_command{use = "greet", short = "second"}`)
+	writePluginFile(t, extra, "extra.lua", `add_command{use = "extra"}`)
+
+	rootCmd := &Command{Use: "root", PluginOptions: PluginOptions{Dirs: []string{extra}}}
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	greet, _, _ := rootCmd.Find([]string{"greet"})
+	if greet.Short != "first" {
+		t.Errorf("Expected the plugin of the first directory to shadow the others, got %q", greet.Short)
+	}
+	if !hasCommand(rootCmd, "extra") {
+		t.Error("Expected the plugins of the extra directory to be loaded")
+	}
+
+	otherCmd := &Command{Use: "other"}
+	if err := LoadPlugins(otherCmd); err != nil {
+		t.Fatal(err)
+	}
+	if hasCommand(otherCmd, "greet") {
+		t.Error("Expected the plugins of another program not to be loaded")
+	}
+}
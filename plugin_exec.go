@@ -0,0 +1,496 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// EnablePathPlugins makes root commands load the executables named "<root>-<name>" found
+// on the PATH as plugins, in addition to the plugins of the plugin directories. As any such
+// executable is run to describe its commands, it is disabled by default, and the plugins
+// found on the PATH are refused under PluginSignaturesEnforce.
+var EnablePathPlugins = false
+
+// ExecPluginDescribeTimeout is the time an executable plugin has to describe its commands.
+var ExecPluginDescribeTimeout = 10 * time.Second
+
+// ExecPluginCancelTimeout is the time an executable plugin has to exit once a running
+// command has been canceled, after which it is killed.
+var ExecPluginCancelTimeout = 5 * time.Second
+
+// execPluginAnnotation marks commands provided by executable plugins, its value is the plugin name.
+const execPluginAnnotation = "cobra_annotation_exec_plugin"
+
+// ExecPluginExitError is returned by the commands of executable plugins that exit with a
+// non-zero exit code without reporting an error.
+type ExecPluginExitError struct {
+	Plugin string
+	Code   int
+}
+
+func (e *ExecPluginExitError) Error() string {
+	return fmt.Sprintf("plugin %s exited with code %d", e.Plugin, e.Code)
+}
+
+// execPlugin is a plugin executable talking the protocol described in plugin_rpc.go.
+type execPlugin struct {
+	name string
+	path string
+}
+
+func loadExecPlugin(p *discoveredPlugin, rootCmd *Command) error {
+	plugin := &execPlugin{name: p.Manifest.Name, path: p.Path}
+	desc, err := plugin.cachedDescribe(rootCmd.Name())
+	if err != nil {
+		return err
+	}
+	if desc.APIVersion != "" {
+		m := PluginManifest{Name: p.Manifest.Name, APIVersion: desc.APIVersion}
+		if err := m.checkCompatible(); err != nil {
+			return err
+		}
+	}
+	if len(desc.Commands) == 0 {
+		return fmt.Errorf("plugin %s does not provide any command", p.Manifest.Name)
+	}
+
+	// Build every command before adding any, so that an invalid description does not leave
+	// part of the plugin registered.
+	var commands []*Command
+	for _, spec := range desc.Commands {
+		cmd, err := plugin.newCommand(spec, nil, nil)
+		if err != nil {
+			return err
+		}
+		commands = append(commands, cmd)
+	}
+	rootCmd.AddCommand(commands...)
+	return nil
+}
+
+// newCommand builds the command described by spec. path is the command path of its parent
+// below the root command and inherited the names of the persistent flags of its ancestors.
+func (p *execPlugin) newCommand(spec execCommandSpec, path, inherited []string) (*Command, error) {
+	cmd := &Command{
+		Use:         spec.Use,
+		Short:       spec.Short,
+		Long:        spec.Long,
+		Example:     spec.Example,
+		Aliases:     spec.Aliases,
+		Hidden:      spec.Hidden,
+		Deprecated:  spec.Deprecated,
+		Annotations: map[string]string{execPluginAnnotation: p.name},
+	}
+	if cmd.Name() == "" {
+		return nil, fmt.Errorf("plugin %s describes a command without use", p.name)
+	}
+	path = append(path[:len(path):len(path)], cmd.Name())
+
+	flags := append([]string(nil), inherited...)
+	var persistent []string
+	for _, f := range spec.Flags {
+		if err := p.defineFlag(cmd, path, f); err != nil {
+			return nil, err
+		}
+		flags = append(flags, f.Name)
+		if f.Persistent {
+			persistent = append(persistent, f.Name)
+		}
+	}
+
+	if spec.Args != nil {
+		switch min, max := spec.Args.Min, spec.Args.Max; {
+		case min != nil && max != nil:
+			cmd.Args = RangeArgs(*min, *max)
+		case min != nil:
+			cmd.Args = MinimumNArgs(*min)
+		case max != nil:
+			cmd.Args = MaximumNArgs(*max)
+		}
+	}
+	if spec.Complete {
+		cmd.ValidArgsFunction = func(cmd *Command, args []string, toComplete string) ([]Completion, ShellCompDirective) {
+			return p.complete(cmd, path, flags, args, "", toComplete)
+		}
+	}
+	if spec.Runnable {
+		cmd.RunE = func(cmd *Command, args []string) error {
+			return p.run(cmd, path, flags, args)
+		}
+	}
+
+	inherited = append(inherited[:len(inherited):len(inherited)], persistent...)
+	for _, subSpec := range spec.Commands {
+		sub, err := p.newCommand(subSpec, path, inherited)
+		if err != nil {
+			return nil, err
+		}
+		cmd.AddCommand(sub)
+	}
+	return cmd, nil
+}
+
+func (p *execPlugin) defineFlag(cmd *Command, path []string, spec execFlagSpec) error {
+	if spec.Name == "" {
+		return fmt.Errorf("plugin %s describes a flag without name on %s", p.name, strings.Join(path, " "))
+	}
+	fs := cmd.Flags()
+	if spec.Persistent {
+		fs = cmd.PersistentFlags()
+	}
+	switch spec.Type {
+	case "", "string":
+		fs.StringP(spec.Name, spec.Shorthand, "", spec.Usage)
+	case "int":
+		fs.IntP(spec.Name, spec.Shorthand, 0, spec.Usage)
+	case "bool":
+		fs.BoolP(spec.Name, spec.Shorthand, false, spec.Usage)
+	case "float":
+		fs.Float64P(spec.Name, spec.Shorthand, 0, spec.Usage)
+	case "strings":
+		fs.StringSliceP(spec.Name, spec.Shorthand, nil, spec.Usage)
+	case "duration":
+		fs.DurationP(spec.Name, spec.Shorthand, 0, spec.Usage)
+	default:
+		return fmt.Errorf("plugin %s: flag %s: unsupported type %q", p.name, spec.Name, spec.Type)
+	}
+
+	f := fs.Lookup(spec.Name)
+	if spec.Default != "" {
+		if err := f.Value.Set(spec.Default); err != nil {
+			return fmt.Errorf("plugin %s: flag %s: invalid default: %v", p.name, spec.Name, err)
+		}
+		f.DefValue = f.Value.String()
+	}
+	if spec.Hidden {
+		f.Hidden = true
+	}
+	if spec.Required {
+		_ = fs.SetAnnotation(spec.Name, BashCompOneRequiredFlag, []string{"true"})
+	}
+	if spec.Complete {
+		name := spec.Name
+		return cmd.RegisterFlagCompletionFunc(name, func(cmd *Command, args []string, toComplete string) ([]Completion, ShellCompDirective) {
+			return p.complete(cmd, path, nil, args, name, toComplete)
+		})
+	}
+	return nil
+}
+
+// flagValues returns the values of the flags of the plugin that were set on the command line.
+func flagValues(cmd *Command, names []string) map[string][]string {
+	values := make(map[string][]string)
+	for _, name := range names {
+		f := cmd.Flags().Lookup(name)
+		if f == nil || !f.Changed {
+			continue
+		}
+		if slice, ok := f.Value.(flag.SliceValue); ok {
+			values[name] = slice.GetSlice()
+		} else {
+			values[name] = []string{f.Value.String()}
+		}
+	}
+	return values
+}
+
+func (p *execPlugin) run(cmd *Command, path, flags, args []string) error {
+	params := execRunParams{Command: path, Args: args, Flags: flagValues(cmd, flags)}
+	if params.Args == nil {
+		params.Args = []string{}
+	}
+	// The plugin writes to stderr both directly and through notifications.
+	stderr := &syncWriter{w: cmd.ErrOrStderr()}
+	var result execRunResult
+	err := p.call(cmd.Context(), stderr, "run", params, &result, func(msg *rpcMessage) {
+		if msg.Method != "output" {
+			return
+		}
+		var out execOutputParams
+		if err := json.Unmarshal(msg.Params, &out); err != nil {
+			return
+		}
+		var w io.Writer = stderr
+		if out.Stream != "stderr" {
+			w = cmd.OutOrStdout()
+		}
+		_, _ = io.WriteString(w, out.Data)
+	})
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return &ExecPluginExitError{Plugin: p.name, Code: result.ExitCode}
+	}
+	return nil
+}
+
+// syncWriter serializes writes to w.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (p *execPlugin) complete(cmd *Command, path, flags, args []string, flagName, toComplete string) ([]Completion, ShellCompDirective) {
+	params := execCompleteParams{
+		Command:    path,
+		Args:       args,
+		Flags:      flagValues(cmd, flags),
+		Flag:       flagName,
+		ToComplete: toComplete,
+	}
+	if params.Args == nil {
+		params.Args = []string{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ExecPluginDescribeTimeout)
+	defer cancel()
+	var result execCompleteResult
+	if err := p.call(ctx, io.Discard, "complete", params, &result, nil); err != nil {
+		CompErrorln(err.Error())
+		return nil, ShellCompDirectiveError
+	}
+	return result.Completions, result.Directive
+}
+
+func (p *execPlugin) describe(host string) (*execPluginDescription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ExecPluginDescribeTimeout)
+	defer cancel()
+	stderr := new(bytes.Buffer)
+	desc := &execPluginDescription{}
+	err := p.call(ctx, stderr, "describe", execPluginDescribeParams{APIVersion: PluginAPIVersion, Host: host}, desc, nil)
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return desc, nil
+}
+
+// call starts the plugin and sends it a single request. stderr receives what the plugin
+// writes to its standard error. If ctx is canceled the plugin is asked to cancel and killed
+// if it does not exit within ExecPluginCancelTimeout.
+func (p *execPlugin) call(ctx context.Context, stderr io.Writer, method string, params, result interface{}, onNotify func(*rpcMessage)) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c := exec.CommandContext(ctx, p.path)
+	c.Env = append(os.Environ(), execPluginProtocolEnvVar+"="+execPluginProtocol)
+	c.Stderr = stderr
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := c.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	conn := newRPCConn(stdout, stdin)
+	c.Cancel = func() error {
+		return conn.notify("cancel", nil)
+	}
+	c.WaitDelay = ExecPluginCancelTimeout
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("plugin %s: %v", p.name, err)
+	}
+
+	callErr := conn.call(method, params, result, onNotify)
+	stdin.Close()
+	waitErr := c.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var rpcErr *rpcError
+	switch {
+	case errors.As(callErr, &rpcErr):
+		return callErr
+	case callErr != nil && waitErr != nil:
+		return fmt.Errorf("plugin %s: %v (%v)", p.name, callErr, waitErr)
+	case callErr != nil:
+		return fmt.Errorf("plugin %s: %v", p.name, callErr)
+	}
+	return nil
+}
+
+// execPluginCacheEntry is a cached plugin description, valid as long as the executable
+// keeps the same size and modification time.
+type execPluginCacheEntry struct {
+	Size        int64                  `json:"size"`
+	ModTime     time.Time              `json:"mod_time"`
+	Description *execPluginDescription `json:"description"`
+}
+
+// execPluginCacheFile returns the file caching plugin descriptions, so that plugins are
+// not started on every execution. It returns an empty string if there is no cache directory.
+func execPluginCacheFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cobra", "exec-plugins.json")
+}
+
+// cachedDescribe returns the description of the plugin, from the cache if the executable
+// did not change since it was described. Failures are not cached, so that a plugin that
+// failed to describe itself is tried again on the next execution.
+func (p *execPlugin) cachedDescribe(host string) (*execPluginDescription, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+	file := execPluginCacheFile()
+	key := host + ":" + p.path
+	if file != "" {
+		entry, ok := readExecPluginCache(file)[key]
+		if ok && entry.Description != nil && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+			return entry.Description, nil
+		}
+	}
+
+	desc, err := p.describe(host)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, fmt.Errorf("plugin %s did not describe itself within %v", p.name, ExecPluginDescribeTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		// The cache is only an optimization, failing to update it is not an error.
+		_ = updateExecPluginCache(file, key, execPluginCacheEntry{Size: info.Size(), ModTime: info.ModTime(), Description: desc})
+	}
+	return desc, nil
+}
+
+// readExecPluginCache returns the entries of the cache file, or none if it cannot be read.
+func readExecPluginCache(file string) map[string]execPluginCacheEntry {
+	cache := make(map[string]execPluginCacheEntry)
+	if data, err := os.ReadFile(file); err == nil {
+		_ = json.Unmarshal(data, &cache)
+	}
+	return cache
+}
+
+// updateExecPluginCache sets the entry of key in the cache file, holding its lock so that
+// concurrent executions do not lose each other's entries.
+func updateExecPluginCache(file, key string, entry execPluginCacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(file+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+
+	cache := readExecPluginCache(file)
+	cache[key] = entry
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, data, 0644)
+}
+
+// discoverPathPlugins finds the executables named "<rootName>-<name>" on the PATH.
+// When several directories hold the same plugin, the first one wins.
+func discoverPathPlugins(rootName string) []*discoveredPlugin {
+	prefix := rootName + "-"
+	seen := make(map[string]bool)
+	var plugins []*discoveredPlugin
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), prefix) {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			info, err := os.Stat(path)
+			if err != nil || !isExecutable(info) {
+				continue
+			}
+			name := strings.TrimPrefix(executableName(entry.Name()), prefix)
+			if name == "" || seen[name] {
+				continue
+			}
+			seen[name] = true
+			plugins = append(plugins, &discoveredPlugin{
+				Manifest: PluginManifest{Name: name, Entrypoint: entry.Name()},
+				Path:     path,
+				OnPath:   true,
+			})
+		}
+	}
+	return plugins
+}
+
+// isExecutable reports whether info describes a file that can be run as a plugin.
+func isExecutable(info os.FileInfo) bool {
+	if info.IsDir() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return executableName(info.Name()) != info.Name()
+	}
+	return info.Mode().Perm()&0111 != 0
+}
+
+// executableName strips the executable extension from name on Windows.
+func executableName(name string) string {
+	if runtime.GOOS != "windows" {
+		return name
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	pathExt := os.Getenv("PATHEXT")
+	if pathExt == "" {
+		pathExt = ".com;.exe;.bat;.cmd"
+	}
+	for _, e := range filepath.SplitList(strings.ToLower(pathExt)) {
+		if ext != "" && ext == e {
+			return strings.TrimSuffix(name, filepath.Ext(name))
+		}
+	}
+	return name
+}
@@ -0,0 +1,296 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const execPluginHelperEnvVar = "COBRA_TEST_EXEC_PLUGIN"
+
+// TestExecPluginHelperProcess is not a real test, it is the plugin started by the other tests.
+func TestExecPluginHelperProcess(t *testing.T) {
+	if os.Getenv(execPluginHelperEnvVar) != "1" {
+		return
+	}
+	if err := ServePlugin(newTestExecPluginRoot()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func newTestExecPluginRoot() *Command {
+	root := &Command{
+		Use:   "greet",
+		Short: "Greet people",
+		Args:  NoArgs,
+		RunE: func(cmd *Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			msg := "hello " + name
+			if loud, _ := cmd.Flags().GetBool("loud"); loud {
+				msg = strings.ToUpper(msg)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), msg)
+			fmt.Fprintln(cmd.ErrOrStderr(), "greeted")
+			return nil
+		},
+	}
+	root.Flags().String("name", "world", "who to greet")
+	root.Flags().Bool("loud", false, "shout")
+	_ = root.RegisterFlagCompletionFunc("name", FixedCompletions([]string{"alice", "bob"}, ShellCompDirectiveNoFileComp))
+
+	each := &Command{
+		Use:       "each",
+		ValidArgs: []string{"alice", "bob", "carol"},
+		RunE: func(cmd *Command, args []string) error {
+			sep, _ := cmd.Flags().GetString("sep")
+			fmt.Fprintln(cmd.OutOrStdout(), strings.Join(args, sep))
+			return nil
+		},
+	}
+	root.PersistentFlags().String("sep", ",", "separator")
+
+	fail := &Command{
+		Use: "fail",
+		RunE: func(cmd *Command, args []string) error {
+			return errors.New("it failed")
+		},
+	}
+	wait := &Command{
+		Use: "wait",
+		RunE: func(cmd *Command, args []string) error {
+			<-cmd.Context().Done()
+			return cmd.Context().Err()
+		},
+	}
+	root.AddCommand(each, fail, wait)
+	return root
+}
+
+// writeExecPlugin writes a script running the test binary as the plugin to dir.
+func writeExecPlugin(t *testing.T, dir, name string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("executable plugin tests use shell scripts")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf("#!/bin/sh\n%s=1 exec %q -test.run='^TestExecPluginHelperProcess$'\n", execPluginHelperEnvVar, exe)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupExecPluginTest(t *testing.T) {
+	t.Helper()
+	home := setupPluginHome(t)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+}
+
+func executeWithPlugins(ctx context.Context, args ...string) (string, string, error) {
+	rootCmd := &Command{Use: "root", Run: emptyRun, PluginOptions: PluginOptions{Enabled: true}}
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	rootCmd.SetOut(stdout)
+	rootCmd.SetErr(stderr)
+	rootCmd.SetArgs(args)
+	_, err := rootCmd.ExecuteContextC(ctx)
+	return stdout.String(), stderr.String(), err
+}
+
+func TestExecPluginRun(t *testing.T) {
+	setupExecPluginTest(t)
+	writeExecPlugin(t, testPluginDir(), "greeter")
+
+	stdout, stderr, err := executeWithPlugins(context.Background(), "greet", "--name", "bob", "--loud")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, stderr)
+	}
+	if stdout != "HELLO BOB\n" {
+		t.Errorf("Unexpected output %q", stdout)
+	}
+	if !strings.Contains(stderr, "greeted") {
+		t.Errorf("Expected the plugin stderr to be streamed, got %q", stderr)
+	}
+
+	stdout, _, err = executeWithPlugins(context.Background(), "greet", "each", "--sep", "+", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "a+b\n" {
+		t.Errorf("Expected the persistent flag to be passed to the subcommand, got %q", stdout)
+	}
+}
+
+func TestExecPluginErrors(t *testing.T) {
+	setupExecPluginTest(t)
+	writeExecPlugin(t, testPluginDir(), "greeter")
+
+	_, _, err := executeWithPlugins(context.Background(), "greet", "fail")
+	if err == nil || err.Error() != "it failed" {
+		t.Errorf("Expected the plugin error, got %v", err)
+	}
+
+	_, _, err = executeWithPlugins(context.Background(), "greet", "extra")
+	if err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected the plugin to validate its arguments, got %v", err)
+	}
+}
+
+func TestExecPluginCompletion(t *testing.T) {
+	setupExecPluginTest(t)
+	writeExecPlugin(t, testPluginDir(), "greeter")
+
+	stdout, _, err := executeWithPlugins(context.Background(), ShellCompRequestCmd, "greet", "--name", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "alice\nbob\n:4\n"; !strings.HasPrefix(stdout, expected) {
+		t.Errorf("Expected flag completions %q, got %q", expected, stdout)
+	}
+
+	stdout, _, err = executeWithPlugins(context.Background(), ShellCompRequestCmd, "greet", "each", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout, "carol\n") || !strings.HasSuffix(stdout, ":4\n") {
+		t.Errorf("Expected argument completions, got %q", stdout)
+	}
+}
+
+func TestExecPluginCancel(t *testing.T) {
+	setupExecPluginTest(t)
+	writeExecPlugin(t, testPluginDir(), "greeter")
+	// Describe the plugin before the deadline starts.
+	if _, _, err := executeWithPlugins(context.Background(), "greet"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := executeWithPlugins(ctx, "greet", "wait")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the command to be canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= ExecPluginCancelTimeout {
+		t.Errorf("Expected the plugin to exit when canceled, took %v", elapsed)
+	}
+}
+
+func TestExecPluginOnPath(t *testing.T) {
+	setupExecPluginTest(t)
+	bin := t.TempDir()
+	writeExecPlugin(t, bin, "root-greet")
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if _, _, err := executeWithPlugins(context.Background(), "greet"); err == nil {
+		t.Error("Expected no plugin to be loaded from the PATH by default")
+	}
+
+	EnablePathPlugins = true
+	defer func() { EnablePathPlugins = false }()
+	plugins := discoverPathPlugins("root")
+	if len(plugins) != 1 || plugins[0].Manifest.Name != "greet" {
+		t.Fatalf("Expected the greet plugin on the PATH, got %v", plugins)
+	}
+
+	stdout, stderr, err := executeWithPlugins(context.Background(), "greet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, stderr)
+	}
+	if stdout != "hello world\n" {
+		t.Errorf("Unexpected output %q", stdout)
+	}
+
+	t.Setenv("COBRA_PLUGIN_SIGNATURES", "enforce")
+	if _, stderr, err := executeWithPlugins(context.Background(), "greet"); err == nil || !strings.Contains(stderr, "found on the PATH") {
+		t.Errorf("Expected the plugin on the PATH to be refused when signatures are enforced, got %v\n%s", err, stderr)
+	}
+}
+
+func TestExecPluginDescriptionIsCached(t *testing.T) {
+	setupExecPluginTest(t)
+	writeExecPlugin(t, testPluginDir(), "greeter")
+	if _, _, err := executeWithPlugins(context.Background(), "greet"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(execPluginCacheFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"use": "greet"`) {
+		t.Errorf("Expected the description to be cached, got %s", data)
+	}
+}
+
+func TestExecPluginFailureIsNotCached(t *testing.T) {
+	setupExecPluginTest(t)
+	writeExecPlugin(t, testPluginDir(), "greeter")
+	path := filepath.Join(testPluginDir(), "greeter")
+	script, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The plugin fails to describe itself while COBRA_TEST_EXEC_PLUGIN_FAIL is set.
+	script = bytes.Replace(script, []byte("\n"), []byte("\n[ -n \"$COBRA_TEST_EXEC_PLUGIN_FAIL\" ] && exit 1\n"), 1)
+	if err := os.WriteFile(path, script, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("COBRA_TEST_EXEC_PLUGIN_FAIL", "1")
+	if _, _, err := executeWithPlugins(context.Background(), "greet"); err == nil {
+		t.Fatal("Expected the plugin to fail to load")
+	}
+	t.Setenv("COBRA_TEST_EXEC_PLUGIN_FAIL", "")
+	if stdout, stderr, err := executeWithPlugins(context.Background(), "greet"); err != nil || stdout != "hello world\n" {
+		t.Errorf("Expected the plugin to be described again once it works, got %q and %v\n%s", stdout, err, stderr)
+	}
+}
+
+func TestExecPluginCacheConcurrentUpdates(t *testing.T) {
+	setupExecPluginTest(t)
+	file := execPluginCacheFile()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			entry := execPluginCacheEntry{Size: int64(i), Description: &execPluginDescription{}}
+			if err := updateExecPluginCache(file, fmt.Sprint("root:plugin", i), entry); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if cache := readExecPluginCache(file); len(cache) != 20 {
+		t.Errorf("Expected the 20 entries to be cached, got %d", len(cache))
+	}
+}
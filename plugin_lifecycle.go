@@ -0,0 +1,234 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// PluginShutdowner is implemented by plugins that release resources when they are no
+// longer used. Shutdown is called once, when the execution of the root command that loaded
+// the plugin finishes (see OnFinalize), or when the plugin is unloaded, either by a reload or
+// by Command.ShutdownPlugins, whichever comes first. The plugin is loaded again by the next
+// execution.
+type PluginShutdowner interface {
+	Shutdown() error
+}
+
+type registeredPlugin struct {
+	name   string
+	plugin Plugin
+}
+
+var (
+	registeredPluginsMu sync.Mutex
+	staticPlugins       []registeredPlugin
+)
+
+// RegisterPlugin registers a plugin compiled into the program, usually from the init function
+// of its package. Registered plugins are loaded by LoadPlugins like the plugins of the plugin
+// directory, without plugin.Open, and can be disabled by name the same way.
+// RegisterPlugin panics if name is empty or already registered.
+func RegisterPlugin(name string, p Plugin) {
+	if name == "" {
+		panic("cobra: RegisterPlugin with an empty name")
+	}
+	if p == nil {
+		panic(fmt.Sprintf("cobra: RegisterPlugin %s with a nil plugin", name))
+	}
+	registeredPluginsMu.Lock()
+	defer registeredPluginsMu.Unlock()
+	for _, r := range staticPlugins {
+		if r.name == name {
+			panic(fmt.Sprintf("cobra: plugin %s is already registered", name))
+		}
+	}
+	staticPlugins = append(staticPlugins, registeredPlugin{name: name, plugin: p})
+}
+
+// registeredPlugins returns the plugins registered with RegisterPlugin, in registration order.
+func registeredPlugins() []*discoveredPlugin {
+	registeredPluginsMu.Lock()
+	defer registeredPluginsMu.Unlock()
+	plugins := make([]*discoveredPlugin, 0, len(staticPlugins))
+	for _, r := range staticPlugins {
+		plugins = append(plugins, &discoveredPlugin{Manifest: PluginManifest{Name: r.name}, Static: r.plugin})
+	}
+	return plugins
+}
+
+// pluginLoad is a plugin going through the load phases.
+type pluginLoad struct {
+	p *discoveredPlugin
+	// plugin is nil for plugins loaded in a single step by load, during the AddCommands phase.
+	plugin Plugin
+	load   func() error
+	loaded *loadedPlugin
+	err    error
+}
+
+func (l *pluginLoad) name() string {
+	return l.p.Manifest.Name
+}
+
+type pluginPhase struct {
+	name string
+	run  func(p Plugin, rootCmd *Command) error
+}
+
+// pluginPhases run in order, each one for every plugin before the next one starts.
+var pluginPhases = []pluginPhase{
+	{"Init", Plugin.Init},
+	{"AddCommands", Plugin.AddCommands},
+	{"ModifyCommands", Plugin.ModifyCommands},
+	{"AddMiddlewares", Plugin.AddMiddlewares},
+}
+
+// loadPluginsInPhases loads the plugins, which must be ordered after their dependencies.
+// A plugin failing in any phase has its changes undone and takes no part in the later
+// phases, nor do the plugins depending on it.
+func loadPluginsInPhases(rootCmd *Command, ordered []*discoveredPlugin) []*pluginLoad {
+	loads := make([]*pluginLoad, 0, len(ordered))
+	for _, p := range ordered {
+		l := &pluginLoad{p: p, loaded: newLoadedPlugin(p.Manifest.Name)}
+		err := protect(func() (err error) {
+			l.plugin, l.load, err = preparePlugin(p, rootCmd)
+			return err
+		})
+		if err != nil {
+			l.err = fmt.Errorf("failed to load plugin %s: %v", l.name(), err)
+		}
+		loads = append(loads, l)
+	}
+
+	failed := make(map[string]bool)
+	for _, phase := range pluginPhases {
+		for _, l := range loads {
+			if l.err == nil {
+				for _, dep := range l.p.Manifest.Dependencies {
+					if failed[dep] {
+						l.err = fmt.Errorf("plugin %s depends on %s which cannot be loaded", l.name(), dep)
+						break
+					}
+				}
+			}
+			if l.err == nil {
+				l.err = l.runPhase(phase, rootCmd)
+			}
+			if l.err != nil && !failed[l.name()] {
+				failed[l.name()] = true
+				var others []*loadedPlugin
+				for _, other := range loads {
+					if other != l {
+						others = append(others, other.loaded)
+					}
+				}
+				l.loaded.unload(others)
+			}
+		}
+	}
+	return loads
+}
+
+// runPhase runs phase for l, recording the changes it makes to the command tree.
+func (l *pluginLoad) runPhase(phase pluginPhase, rootCmd *Command) error {
+	var run func() error
+	switch {
+	case l.plugin != nil:
+		run = func() error { return phase.run(l.plugin, rootCmd) }
+	case phase.name == "AddCommands":
+		run = l.load
+	default:
+		return nil
+	}
+
+	before := snapshotCommands(rootCmd)
+	err := protect(run)
+	l.loaded.merge(before.changes(rootCmd, l.name()))
+	if err == nil && phase.name == "Init" {
+		if s, ok := l.plugin.(PluginShutdowner); ok {
+			l.loaded.shutdown = s.Shutdown
+		}
+	}
+	if err != nil {
+		if l.plugin == nil {
+			return fmt.Errorf("failed to load plugin %s: %v", l.name(), err)
+		}
+		return fmt.Errorf("failed to load plugin %s: %s: %v", l.name(), phase.name, err)
+	}
+	return nil
+}
+
+// protect calls fn, turning a panic into an error.
+func protect(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// stop shuts p down if it has not been already.
+func (p *loadedPlugin) stop() {
+	if p.shutdown == nil {
+		return
+	}
+	shutdown := p.shutdown
+	p.shutdown = nil
+	if err := protect(shutdown); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: plugin %s: shutdown: %v\n", p.name, err)
+	}
+}
+
+// stopPluginsOnFinalize registers, once per root command c, an OnFinalize hook shutting
+// down the plugins of c when an execution finishes.
+func (c *Command) stopPluginsOnFinalize() {
+	if c.pluginFinalizer {
+		return
+	}
+	c.pluginFinalizer = true
+	OnFinalize(c.stopPlugins)
+}
+
+// stopPlugins shuts down the plugins of the root command c that are still running, in the
+// reverse order they were loaded in. Their commands are kept, so that the tree can still be
+// inspected after the execution, until the next execution loads them again.
+func (c *Command) stopPlugins() {
+	pluginMutex.Lock()
+	defer pluginMutex.Unlock()
+	for i := len(c.loadedPlugins) - 1; i >= 0; i-- {
+		if p := c.loadedPlugins[i]; p.shutdown != nil {
+			p.stop()
+			c.pluginsStopped = true
+		}
+	}
+}
+
+// ShutdownPlugins unloads the plugins of the root command of c, shutting down those that are
+// still running in the reverse order they were loaded in. Executions already shut the plugins
+// they loaded down when they finish, so ShutdownPlugins is only needed by programs that
+// loaded plugins with LoadPlugins, or that want them unloaded before the next execution.
+// If c.PluginOptions.Enabled is set, the plugins are loaded again by the next execution.
+func (c *Command) ShutdownPlugins() {
+	pluginMutex.Lock()
+	defer pluginMutex.Unlock()
+	root := c.Root()
+	unloadPlugins(root)
+	root.pluginReport = nil
+	root.pluginsStopped = false
+}
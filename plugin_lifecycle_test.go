@@ -0,0 +1,232 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recordingPlugin logs the phases it goes through.
+type recordingPlugin struct {
+	name     string
+	log      *[]string
+	failIn   string
+	shutdown int
+}
+
+func (p *recordingPlugin) phase(name string, fn func() error) error {
+	*p.log = append(*p.log, p.name+"."+name)
+	if p.failIn == name {
+		return errors.New("boom")
+	}
+	if fn != nil {
+		return fn()
+	}
+	return nil
+}
+
+func (p *recordingPlugin) Init(rootCmd *Command) error {
+	return p.phase("Init", nil)
+}
+
+func (p *recordingPlugin) AddCommands(rootCmd *Command) error {
+	return p.phase("AddCommands", func() error {
+		rootCmd.AddCommand(&Command{Use: p.name, Run: emptyRun})
+		return nil
+	})
+}
+
+func (p *recordingPlugin) ModifyCommands(rootCmd *Command) error {
+	return p.phase("ModifyCommands", func() error {
+		// Modify the commands of every plugin, including those loaded later.
+		for _, cmd := range rootCmd.Commands() {
+			cmd.Short += "[" + p.name + "]"
+		}
+		return nil
+	})
+}
+
+func (p *recordingPlugin) AddMiddlewares(rootCmd *Command) error {
+	return p.phase("AddMiddlewares", func() error {
+		rootCmd.Middlewares = append(rootCmd.Middlewares, func(cmd *Command, args []string) error { return nil })
+		return nil
+	})
+}
+
+func (p *recordingPlugin) Shutdown() error {
+	p.shutdown++
+	return nil
+}
+
+func registerTestPlugin(t *testing.T, p Plugin, name string) {
+	t.Helper()
+	RegisterPlugin(name, p)
+	t.Cleanup(func() {
+		registeredPluginsMu.Lock()
+		defer registeredPluginsMu.Unlock()
+		for i, r := range staticPlugins {
+			if r.name == name {
+				staticPlugins = append(staticPlugins[:i], staticPlugins[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+func TestPluginPhasesRunAcrossPlugins(t *testing.T) {
+	setupPluginHome(t)
+	var log []string
+	first := &recordingPlugin{name: "first", log: &log}
+	second := &recordingPlugin{name: "second", log: &log}
+	registerTestPlugin(t, second, "second")
+	registerTestPlugin(t, first, "first")
+
+	rootCmd := &Command{Use: "root"}
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"first.Init", "second.Init",
+		"first.AddCommands", "second.AddCommands",
+		"first.ModifyCommands", "second.ModifyCommands",
+		"first.AddMiddlewares", "second.AddMiddlewares",
+	}
+	if strings.Join(log, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected phases %v, got %v", expected, log)
+	}
+	for _, name := range []string{"first", "second"} {
+		cmd, _, err := rootCmd.Find([]string{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd.Short != "[first][second]" {
+			t.Errorf("Expected %s to be modified by both plugins, got %q", name, cmd.Short)
+		}
+	}
+	if len(rootCmd.Middlewares) != 2 {
+		t.Errorf("Expected 2 middlewares, got %d", len(rootCmd.Middlewares))
+	}
+}
+
+func TestPluginPhaseFailureIsUndone(t *testing.T) {
+	setupPluginHome(t)
+	var log []string
+	bad := &recordingPlugin{name: "bad", log: &log, failIn: "ModifyCommands"}
+	good := &recordingPlugin{name: "good", log: &log}
+	registerTestPlugin(t, bad, "bad")
+	registerTestPlugin(t, good, "good")
+
+	rootCmd := &Command{Use: "root"}
+	err := LoadPlugins(rootCmd)
+	if err == nil || !strings.Contains(err.Error(), "failed to load plugin bad: ModifyCommands: boom") {
+		t.Fatalf("Expected the phase error, got %v", err)
+	}
+	if hasCommand(rootCmd, "bad") {
+		t.Error("Expected the commands of the failed plugin to be removed")
+	}
+	if !hasCommand(rootCmd, "good") {
+		t.Error("Expected the other plugin to be loaded")
+	}
+	if strings.Contains(strings.Join(log, " "), "bad.AddMiddlewares") {
+		t.Error("Expected the failed plugin to be skipped in later phases")
+	}
+	if bad.shutdown != 1 {
+		t.Errorf("Expected the failed plugin to be shut down once, got %d", bad.shutdown)
+	}
+	if report := rootCmd.PluginLoadReport(); strings.Join(report.Loaded(), ",") != "good" {
+		t.Errorf("Expected only good to be loaded, got %v", report.Loaded())
+	}
+}
+
+func TestPluginShutdown(t *testing.T) {
+	setupPluginHome(t)
+	var log []string
+	p := &recordingPlugin{name: "closer", log: &log}
+	registerTestPlugin(t, p, "closer")
+
+	rootCmd := &Command{Use: "root", Run: emptyRun, PluginOptions: PluginOptions{Enabled: true}}
+	for i := 1; i <= 2; i++ {
+		if _, err := executeCommand(rootCmd, "closer"); err != nil {
+			t.Fatalf("Expected the plugin to run in execution %d, got %v", i, err)
+		}
+		if p.shutdown != i {
+			t.Errorf("Expected the plugin to be shut down after execution %d, got %d shutdowns", i, p.shutdown)
+		}
+	}
+	if inits := strings.Count(strings.Join(log, " "), "closer.Init"); inits != 2 {
+		t.Errorf("Expected the plugin to be initialized again for the second execution, got %d initializations", inits)
+	}
+	if !hasCommand(rootCmd, "closer") || rootCmd.PluginLoadReport() == nil {
+		t.Error("Expected the plugin to stay loaded after the execution")
+	}
+
+	rootCmd.ShutdownPlugins()
+	if p.shutdown != 2 {
+		t.Errorf("Expected the plugin not to be shut down twice, got %d shutdowns", p.shutdown)
+	}
+	if hasCommand(rootCmd, "closer") || rootCmd.PluginLoadReport() != nil {
+		t.Error("Expected the plugin to be unloaded")
+	}
+
+	rootCmd = &Command{Use: "root"}
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	rootCmd.ShutdownPlugins()
+	if p.shutdown != 3 {
+		t.Errorf("Expected the plugin loaded with LoadPlugins to be shut down, got %d shutdowns", p.shutdown)
+	}
+}
+
+func TestPluginShutdownOnUnload(t *testing.T) {
+	setupPluginHome(t)
+	var log []string
+	p := &recordingPlugin{name: "closer", log: &log}
+	registerTestPlugin(t, p, "closer")
+
+	rootCmd := &Command{Use: "root"}
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	if err := setPluginDisabled(testPluginDir(), "closer", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	if p.shutdown != 1 {
+		t.Errorf("Expected the unloaded plugin to be shut down, got %d", p.shutdown)
+	}
+	if hasCommand(rootCmd, "closer") {
+		t.Error("Expected the disabled plugin command to be removed")
+	}
+	if report := rootCmd.PluginLoadReport(); len(report.Plugins) != 1 || report.Plugins[0].Status != PluginSkipped {
+		t.Errorf("Expected the disabled plugin to be skipped, got %+v", report.Plugins)
+	}
+}
+
+func TestRegisterPluginTwicePanics(t *testing.T) {
+	var log []string
+	registerTestPlugin(t, &recordingPlugin{name: "twice", log: &log}, "twice")
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a plugin twice to panic")
+		}
+	}()
+	RegisterPlugin("twice", &recordingPlugin{name: "twice", log: &log})
+}
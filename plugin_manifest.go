@@ -0,0 +1,380 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PluginAPIVersion is the version of the API the host offers to plugins, as "major.minor".
+// A plugin is compatible when it requires the same major version and a minor version
+// that is not greater than the host's.
+const PluginAPIVersion = "1.1"
+
+// Plugin permissions that can be requested in a manifest.
+const (
+	// PluginPermissionExec allows a Lua plugin to use os.execute and os.exit.
+	PluginPermissionExec = "exec"
+	// PluginPermissionIO allows a Lua plugin to use the io library and to remove or rename files.
+	PluginPermissionIO = "io"
+	// PluginPermissionLoadFile allows a Lua plugin to use loadfile, dofile, load and loadstring,
+	// and to require modules from files.
+	PluginPermissionLoadFile = "loadfile"
+	// PluginPermissionEnv allows a Lua plugin to use os.getenv and os.setenv.
+	PluginPermissionEnv = "env"
+)
+
+var knownPluginPermissions = []string{PluginPermissionExec, PluginPermissionIO, PluginPermissionLoadFile, PluginPermissionEnv}
+
+const (
+	// pluginManifestFile is the manifest of a plugin installed as a directory.
+	pluginManifestFile = "plugin.yaml"
+	// pluginStateFile records which plugins are disabled.
+	pluginStateFile = ".plugins.json"
+)
+
+// PluginManifest describes a plugin.
+//
+// A plugin is either a directory in a plugin directory holding a "plugin.yaml" manifest
+// and the entrypoint it names, or a single ".so", ".lua" or executable file with an optional
+// "<name>.plugin.yaml" manifest next to it, e.g. "greet.plugin.yaml" for "greet.lua".
+type PluginManifest struct {
+	// Name identifies the plugin. Defaults to the name of its directory or file.
+	Name        string `yaml:"name,omitempty"`
+	Version     string `yaml:"version,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	// APIVersion is the host plugin API version the plugin was written for. Defaults to PluginAPIVersion.
+	APIVersion string `yaml:"api_version,omitempty"`
+	// Entrypoint is the ".so" or ".lua" file to load, or the executable to run, relative to the manifest.
+	Entrypoint string `yaml:"entrypoint,omitempty"`
+	// Permissions lists the capabilities the plugin needs when sandboxed.
+	Permissions []string `yaml:"permissions,omitempty"`
+	// Dependencies are the names of plugins that must be loaded before this one.
+	Dependencies []string `yaml:"dependencies,omitempty"`
+}
+
+// HasPermission reports whether the manifest requests the given permission.
+func (m *PluginManifest) HasPermission(permission string) bool {
+	if m == nil {
+		return false
+	}
+	return stringInSlice(permission, m.Permissions)
+}
+
+func (m *PluginManifest) validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, p := range m.Permissions {
+		if !stringInSlice(p, knownPluginPermissions) {
+			return fmt.Errorf("unknown permission %q, must be one of: %s", p, strings.Join(knownPluginPermissions, ", "))
+		}
+	}
+	if m.Entrypoint == "" {
+		return fmt.Errorf("entrypoint is required")
+	}
+	if _, _, err := parsePluginAPIVersion(m.APIVersion); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkCompatible returns an error if the plugin requires a plugin API the host does not provide.
+func (m *PluginManifest) checkCompatible() error {
+	major, minor, err := parsePluginAPIVersion(m.APIVersion)
+	if err != nil {
+		return err
+	}
+	hostMajor, hostMinor, _ := parsePluginAPIVersion(PluginAPIVersion)
+	if major != hostMajor || minor > hostMinor {
+		return fmt.Errorf("plugin %s requires plugin API %s, but this application provides %s",
+			m.Name, m.APIVersion, PluginAPIVersion)
+	}
+	return nil
+}
+
+func parsePluginAPIVersion(version string) (major, minor int, err error) {
+	if version == "" {
+		version = PluginAPIVersion
+	}
+	majorStr, minorStr, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	if major, err = strconv.Atoi(majorStr); err != nil {
+		return 0, 0, fmt.Errorf("invalid api_version %q", version)
+	}
+	if minorStr != "" {
+		if minor, err = strconv.Atoi(minorStr); err != nil {
+			return 0, 0, fmt.Errorf("invalid api_version %q", version)
+		}
+	}
+	return major, minor, nil
+}
+
+// pluginManifestPath returns the manifest file of the single file plugin at path.
+func pluginManifestPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".plugin.yaml"
+}
+
+func readPluginManifestFile(file string, manifest *PluginManifest) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		return fmt.Errorf("invalid plugin manifest %s: %v", file, err)
+	}
+	return nil
+}
+
+// discoveredPlugin is a plugin found in a plugin directory.
+type discoveredPlugin struct {
+	Manifest PluginManifest
+	// Path is the absolute path of the entrypoint.
+	Path string
+	// ManifestFile is empty for single file plugins without a manifest.
+	ManifestFile string
+	Disabled     bool
+	// Err is set when the plugin cannot be used at all, e.g. because its manifest is invalid.
+	Err error
+	// Static is the plugin registered with RegisterPlugin, Path is empty for such plugins.
+	Static Plugin
+	// OnPath is set for the executables found on the PATH rather than in a plugin directory.
+	OnPath bool
+}
+
+// source returns the file that defines p, for error messages.
+func (p *discoveredPlugin) source() string {
+	if p.ManifestFile != "" {
+		return p.ManifestFile
+	}
+	return p.Path
+}
+
+// discoverPlugins finds the plugins in dir. Directories without a manifest are searched
+// recursively for single file plugins.
+//
+// A plugin that cannot be read or validated is returned with Err set so that it does not
+// prevent the other plugins from loading. Only errors reading dir itself are returned.
+func discoverPlugins(dir string) ([]*discoveredPlugin, error) {
+	var plugins []*discoveredPlugin
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			plugins = append(plugins, &discoveredPlugin{
+				Manifest: PluginManifest{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))},
+				Path:     path,
+				Err:      err,
+			})
+			return nil
+		}
+		if info.IsDir() {
+			manifestFile := filepath.Join(path, pluginManifestFile)
+			if path == dir || !fileExists(manifestFile) {
+				return nil
+			}
+			p := &discoveredPlugin{Manifest: PluginManifest{Name: filepath.Base(path)}, ManifestFile: manifestFile}
+			p.Err = readPluginManifestFile(manifestFile, &p.Manifest)
+			p.Path = filepath.Join(path, p.Manifest.Entrypoint)
+			plugins = append(plugins, p)
+			return filepath.SkipDir
+		}
+
+		var name string
+		switch filepath.Ext(path) {
+		case ".so", ".lua":
+			name = strings.TrimSuffix(info.Name(), filepath.Ext(path))
+		default:
+			if strings.HasPrefix(info.Name(), ".") || !isExecutable(info) {
+				return nil
+			}
+			name = executableName(info.Name())
+		}
+		p := &discoveredPlugin{Manifest: PluginManifest{Name: name}, Path: path}
+		if manifestFile := pluginManifestPath(path); fileExists(manifestFile) {
+			p.ManifestFile = manifestFile
+			p.Err = readPluginManifestFile(manifestFile, &p.Manifest)
+		}
+		// The file itself is the entrypoint of a single file plugin.
+		p.Manifest.Entrypoint = info.Name()
+		plugins = append(plugins, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]string)
+	for _, p := range plugins {
+		if p.Err != nil {
+			continue
+		}
+		if err := p.Manifest.validate(); err != nil {
+			p.Err = fmt.Errorf("invalid plugin %s: %v", p.source(), err)
+			continue
+		}
+		if other, ok := seen[p.Manifest.Name]; ok {
+			p.Err = fmt.Errorf("plugin %s is defined by both %s and %s", p.Manifest.Name, other, p.source())
+			continue
+		}
+		seen[p.Manifest.Name] = p.source()
+	}
+	return plugins, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// orderPlugins sorts plugins so that every plugin comes after its dependencies, breaking ties
+// by name. Plugins that cannot be loaded because they are disabled, incompatible, or have a
+// missing, unloadable or circular dependency are returned separately with the reason.
+// Plugins with Err set are left out of both results.
+func orderPlugins(plugins []*discoveredPlugin) ([]*discoveredPlugin, map[string]error) {
+	failed := make(map[string]error)
+	byName := make(map[string]*discoveredPlugin, len(plugins))
+	invalid := make(map[string]bool)
+	var valid []*discoveredPlugin
+	for _, p := range plugins {
+		if p.Err == nil {
+			valid = append(valid, p)
+		} else {
+			invalid[p.Manifest.Name] = true
+		}
+	}
+	plugins = valid
+	for _, p := range plugins {
+		byName[p.Manifest.Name] = p
+		if p.Disabled {
+			failed[p.Manifest.Name] = fmt.Errorf("plugin %s is disabled", p.Manifest.Name)
+		} else if err := p.Manifest.checkCompatible(); err != nil {
+			failed[p.Manifest.Name] = err
+		}
+	}
+
+	// Propagate failures to dependents until nothing changes.
+	for changed := true; changed; {
+		changed = false
+		for _, p := range plugins {
+			name := p.Manifest.Name
+			if failed[name] != nil {
+				continue
+			}
+			for _, dep := range p.Manifest.Dependencies {
+				_, installed := byName[dep]
+				switch {
+				case !installed && !invalid[dep]:
+					failed[name] = fmt.Errorf("plugin %s depends on %s which is not installed", name, dep)
+				case !installed || failed[dep] != nil:
+					failed[name] = fmt.Errorf("plugin %s depends on %s which cannot be loaded", name, dep)
+				}
+				if failed[name] != nil {
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	// Kahn's algorithm over the remaining plugins.
+	pending := make(map[string]int)
+	dependents := make(map[string][]string)
+	for _, p := range plugins {
+		name := p.Manifest.Name
+		if failed[name] != nil {
+			continue
+		}
+		pending[name] = len(p.Manifest.Dependencies)
+		for _, dep := range p.Manifest.Dependencies {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+	var ready []string
+	for name, n := range pending {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+	var ordered []*discoveredPlugin
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		delete(pending, name)
+		ordered = append(ordered, byName[name])
+		for _, dependent := range dependents[name] {
+			pending[dependent]--
+			if pending[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	for name := range pending {
+		failed[name] = fmt.Errorf("plugin %s has a circular dependency", name)
+	}
+	return ordered, failed
+}
+
+// pluginState is persisted in the first plugin directory by "plugin enable" and "plugin disable".
+type pluginState struct {
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+func readPluginState(dir string) (*pluginState, error) {
+	state := &pluginState{}
+	data, err := os.ReadFile(filepath.Join(dir, pluginStateFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid plugin state %s: %v", filepath.Join(dir, pluginStateFile), err)
+	}
+	return state, nil
+}
+
+func setPluginDisabled(dir, name string, disabled bool) error {
+	state, err := readPluginState(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, n := range state.Disabled {
+		if n != name {
+			names = append(names, n)
+		}
+	}
+	if disabled {
+		names = append(names, name)
+		sort.Strings(names)
+	}
+	state.Disabled = names
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, pluginStateFile), data, 0644)
+}
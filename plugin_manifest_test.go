@@ -0,0 +1,222 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePluginFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupPluginHome points the home and data directories to a temporary directory, which it
+// returns, and clears the plugin directory overrides.
+func setupPluginHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+	t.Setenv("COBRA_PLUGIN_PATH", "")
+	t.Setenv("ROOT_PLUGIN_PATH", "")
+	return home
+}
+
+// testPluginDir returns the default plugin directory of the root commands named "root".
+func testPluginDir() string {
+	return defaultPluginDir(&Command{Use: "root"})
+}
+
+func pluginNames(plugins []*discoveredPlugin) []string {
+	var names []string
+	for _, p := range plugins {
+		names = append(names, p.Manifest.Name)
+	}
+	return names
+}
+
+func TestDiscoverPlugins(t *testing.T) {
+	dir := t.TempDir()
+	writePluginFile(t, dir, "greet.lua", "")
+	writePluginFile(t, dir, "greet.plugin.yaml", "version: 1.0.0\ndescription: Says hello\n")
+	writePluginFile(t, dir, "deploy/plugin.yaml", "name: deployer\nentrypoint: main.lua\ndependencies: [greet]\n")
+	writePluginFile(t, dir, "deploy/main.lua", "")
+	writePluginFile(t, dir, "deploy/helper.lua", "")
+	writePluginFile(t, dir, "README.md", "")
+
+	plugins, err := discoverPlugins(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(pluginNames(plugins), ","); got != "deployer,greet" {
+		t.Fatalf("Expected plugins deployer,greet, got %s", got)
+	}
+	if plugins[0].Path != filepath.Join(dir, "deploy", "main.lua") {
+		t.Errorf("Unexpected entrypoint %s", plugins[0].Path)
+	}
+	if plugins[1].Manifest.Version != "1.0.0" || plugins[1].Manifest.Description != "Says hello" {
+		t.Errorf("Sidecar manifest was not read: %+v", plugins[1].Manifest)
+	}
+}
+
+func TestDiscoverPluginsInvalidManifest(t *testing.T) {
+	tests := map[string]string{
+		"unknown permission": "permissions: [network]\n",
+		"bad api version":    "api_version: one\n",
+		"malformed yaml":     "permissions: [\n",
+	}
+	for name, manifest := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePluginFile(t, dir, "test.lua", "")
+			writePluginFile(t, dir, "test.plugin.yaml", manifest)
+			writePluginFile(t, dir, "valid.lua", "")
+			plugins, err := discoverPlugins(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plugins) != 2 || plugins[0].Err == nil {
+				t.Fatalf("Expected the invalid plugin to be reported, got %v", plugins)
+			}
+			if plugins[1].Err != nil {
+				t.Errorf("Expected the valid plugin to be unaffected, got %v", plugins[1].Err)
+			}
+		})
+	}
+}
+
+func TestDiscoverPluginsDuplicateName(t *testing.T) {
+	dir := t.TempDir()
+	writePluginFile(t, dir, "greet.lua", "")
+	writePluginFile(t, dir, "other/plugin.yaml", "name: greet\nentrypoint: main.lua\n")
+
+	plugins, err := discoverPlugins(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 2 || plugins[0].Err != nil {
+		t.Fatalf("Expected the first greet plugin to be valid, got %v", plugins)
+	}
+	if err := plugins[1].Err; err == nil || !strings.Contains(err.Error(), "defined by both") {
+		t.Errorf("Expected a duplicate plugin error, got %v", err)
+	}
+}
+
+func TestPluginManifestCompatibility(t *testing.T) {
+	tests := []struct {
+		version    string
+		compatible bool
+	}{
+		{"", true},
+		{"1.0", true},
+		{PluginAPIVersion, true},
+		{"1.9", false},
+		{"2.0", false},
+		{"0.1", false},
+	}
+	for _, tc := range tests {
+		m := &PluginManifest{Name: "test", APIVersion: tc.version}
+		if err := m.checkCompatible(); (err == nil) != tc.compatible {
+			t.Errorf("API version %q: expected compatible=%v, got %v", tc.version, tc.compatible, err)
+		}
+	}
+}
+
+func TestOrderPlugins(t *testing.T) {
+	plugin := func(name string, deps ...string) *discoveredPlugin {
+		return &discoveredPlugin{Manifest: PluginManifest{Name: name, Dependencies: deps}}
+	}
+	plugins := []*discoveredPlugin{
+		plugin("app", "db", "log"),
+		plugin("db", "log"),
+		plugin("log"),
+		plugin("metrics"),
+		plugin("a", "b"),
+		plugin("b", "a"),
+		plugin("orphan", "missing"),
+		plugin("child", "orphan"),
+		{Manifest: PluginManifest{Name: "future", APIVersion: "2.0"}},
+	}
+
+	ordered, failed := orderPlugins(plugins)
+	if got := strings.Join(pluginNames(ordered), ","); got != "log,db,app,metrics" {
+		t.Errorf("Expected order log,db,app,metrics, got %s", got)
+	}
+
+	expected := map[string]string{
+		"a":      "circular dependency",
+		"b":      "circular dependency",
+		"orphan": "not installed",
+		"child":  "cannot be loaded",
+		"future": "requires plugin API",
+	}
+	if len(failed) != len(expected) {
+		t.Errorf("Expected %d failed plugins, got %v", len(expected), failed)
+	}
+	for name, msg := range expected {
+		if err := failed[name]; err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected %s to fail with %q, got %v", name, msg, err)
+		}
+	}
+}
+
+func TestSetPluginDisabled(t *testing.T) {
+	setupPluginHome(t)
+	dir := testPluginDir()
+	rootCmd := &Command{Use: "root"}
+	installed := func() []*discoveredPlugin {
+		t.Helper()
+		state, err := readPluginState(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plugins, err := findPlugins(rootCmd, state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plugins
+	}
+	writePluginFile(t, dir, "log.lua", "")
+	writePluginFile(t, dir, "db.lua", "")
+	writePluginFile(t, dir, "db.plugin.yaml", "dependencies: [log]\n")
+
+	if err := setPluginDisabled(dir, "log", true); err != nil {
+		t.Fatal(err)
+	}
+	ordered, failed := orderPlugins(installed())
+	if len(ordered) != 0 {
+		t.Errorf("Expected no loadable plugins, got %v", pluginNames(ordered))
+	}
+	if failed["db"] == nil {
+		t.Error("Expected db to fail when its dependency is disabled")
+	}
+
+	if err := setPluginDisabled(dir, "log", false); err != nil {
+		t.Fatal(err)
+	}
+	if ordered, _ := orderPlugins(installed()); len(ordered) != 2 {
+		t.Errorf("Expected both plugins to load after enabling log, got %v", pluginNames(ordered))
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultPluginReloadDelay is the time WatchPlugins waits for the plugin directories to settle
+// before reloading, so that a burst of file events, e.g. while a plugin is being installed,
+// triggers a single reload.
+const DefaultPluginReloadDelay = 250 * time.Millisecond
+
+// PluginWatchOptions configures WatchPlugins.
+type PluginWatchOptions struct {
+	// Delay is the time to wait after the last change before reloading.
+	// Defaults to DefaultPluginReloadDelay.
+	Delay time.Duration
+	// Locker, if set, is held while plugins are reloaded. Reloading modifies the command
+	// tree, so programs executing commands while watching must hold it while executing.
+	Locker sync.Locker
+	// OnReload, if set, is called after every reload with the error returned by LoadPlugins.
+	// The outcome of the reload is available from PluginLoadReport.
+	OnReload func(err error)
+}
+
+// WatchPlugins reloads the plugins of the root command of c whenever one of its plugin
+// directories changes, until ctx is done. It is meant for long running processes, such as
+// servers or interactive shells, and is usually started in its own goroutine:
+//
+//	go rootCmd.WatchPlugins(ctx, cobra.PluginWatchOptions{Locker: &mu})
+//
+// Every reload removes the commands and middlewares added by the previously loaded plugins
+// and loads the plugins again, see LoadPlugins. Other changes plugins made to existing
+// commands are not reverted. Go plugins cannot be reloaded once opened, a changed ".so"
+// file only takes effect when the process restarts.
+//
+// WatchPlugins returns ctx.Err() once ctx is done, or an error if the first plugin directory
+// cannot be watched. The other plugin directories are watched if they exist.
+func (c *Command) WatchPlugins(ctx context.Context, opts PluginWatchOptions) error {
+	root := c.Root()
+	delay := opts.Delay
+	if delay <= 0 {
+		delay = DefaultPluginReloadDelay
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs := pluginDirs(root)
+	if err := os.MkdirAll(dirs[0], 0755); err != nil {
+		return err
+	}
+	if err := watchPluginDirs(watcher, dirs[0]); err != nil {
+		return err
+	}
+	for _, dir := range dirs[1:] {
+		_ = watchPluginDirs(watcher, dir)
+	}
+
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+				reload = time.After(delay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			root.PrintErrln("Warning: plugin watcher:", err)
+		case <-reload:
+			reload = nil
+			// Plugin directories may have been added since the last reload.
+			for _, dir := range dirs {
+				_ = watchPluginDirs(watcher, dir)
+			}
+			if opts.Locker != nil {
+				opts.Locker.Lock()
+			}
+			err := LoadPlugins(root)
+			if opts.Locker != nil {
+				opts.Locker.Unlock()
+			}
+			if opts.OnReload != nil {
+				opts.OnReload(err)
+			}
+		}
+	}
+}
+
+// watchPluginDirs watches dir and its subdirectories, which hold directory plugins.
+func watchPluginDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil && path == dir {
+			return fmt.Errorf("failed to watch %s: %v", dir, err)
+		}
+		return nil
+	})
+}
+
+// loadedPlugin records what a plugin added to the command tree, so that it can be unloaded.
+type loadedPlugin struct {
+	name string
+	// commands are the commands the plugin added, without their subcommands.
+	commands []*Command
+	// middlewares holds the indices of the middlewares the plugin appended to existing commands.
+	middlewares map[*Command][]int
+	// shutdown is the Shutdown method of plugins implementing PluginShutdowner, until it is called.
+	shutdown func() error
+}
+
+func newLoadedPlugin(name string) *loadedPlugin {
+	return &loadedPlugin{name: name, middlewares: make(map[*Command][]int)}
+}
+
+// merge adds the changes recorded in other to p.
+func (p *loadedPlugin) merge(other *loadedPlugin) {
+	p.commands = append(p.commands, other.commands...)
+	for cmd, indices := range other.middlewares {
+		p.middlewares[cmd] = append(p.middlewares[cmd], indices...)
+	}
+}
+
+// unload shuts p down and removes its commands and middlewares. others are the plugins
+// that stay loaded, the indices of their middlewares are updated for the removed ones.
+func (p *loadedPlugin) unload(others []*loadedPlugin) {
+	p.stop()
+	for _, cmd := range p.commands {
+		if parent := cmd.Parent(); parent != nil {
+			parent.RemoveCommand(cmd)
+		}
+	}
+	for cmd, indices := range p.middlewares {
+		sort.Ints(indices)
+		for i := len(indices) - 1; i >= 0; i-- {
+			idx := indices[i]
+			if idx >= len(cmd.Middlewares) {
+				continue
+			}
+			cmd.Middlewares = append(cmd.Middlewares[:idx:idx], cmd.Middlewares[idx+1:]...)
+			for _, other := range others {
+				for j, k := range other.middlewares[cmd] {
+					if k > idx {
+						other.middlewares[cmd][j] = k - 1
+					}
+				}
+			}
+		}
+	}
+	p.commands = nil
+	p.middlewares = make(map[*Command][]int)
+}
+
+// unloadPlugins unloads the plugins of rootCmd in the reverse order they were loaded in.
+func unloadPlugins(rootCmd *Command) {
+	for i := len(rootCmd.loadedPlugins) - 1; i >= 0; i-- {
+		rootCmd.loadedPlugins[i].unload(rootCmd.loadedPlugins[:i])
+	}
+	rootCmd.loadedPlugins = nil
+}
+
+// commandSnapshot is the state of a command tree before a plugin is loaded.
+type commandSnapshot struct {
+	commands    map[*Command]bool
+	middlewares map[*Command]int
+}
+
+func snapshotCommands(root *Command) commandSnapshot {
+	s := commandSnapshot{commands: make(map[*Command]bool), middlewares: make(map[*Command]int)}
+	var walk func(cmd *Command)
+	walk = func(cmd *Command) {
+		s.commands[cmd] = true
+		s.middlewares[cmd] = len(cmd.Middlewares)
+		for _, sub := range cmd.commands {
+			walk(sub)
+		}
+	}
+	walk(root)
+	return s
+}
+
+// changes returns what was added to the tree of root since s was taken.
+func (s commandSnapshot) changes(root *Command, name string) *loadedPlugin {
+	p := newLoadedPlugin(name)
+	var walk func(cmd *Command)
+	walk = func(cmd *Command) {
+		for i := s.middlewares[cmd]; i < len(cmd.Middlewares); i++ {
+			p.middlewares[cmd] = append(p.middlewares[cmd], i)
+		}
+		for _, sub := range cmd.commands {
+			if s.commands[sub] {
+				walk(sub)
+			} else {
+				p.commands = append(p.commands, sub)
+			}
+		}
+	}
+	walk(root)
+	return p
+}
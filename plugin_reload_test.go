@@ -0,0 +1,142 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func hasCommand(root *Command, name string) bool {
+	cmd, _, err := root.Find([]string{name})
+	return err == nil && cmd != root
+}
+
+func TestLoadPluginsReplacesPreviousPlugins(t *testing.T) {
+	setupPluginHome(t)
+	dir := testPluginDir()
+	writePluginFile(t, dir, "first.lua", `add_command{use = "first", run = function(cmd, args) end}
+add_middleware("root", function(args) end)`)
+	writePluginFile(t, dir, "second.lua", `add_command{use = "second", run = function(cmd, args) end}
+add_middleware("root", function(args) end)`)
+
+	rootCmd := &Command{Use: "root"}
+	appMiddleware := func(cmd *Command, args []string) error { return nil }
+	rootCmd.Middlewares = append(rootCmd.Middlewares, appMiddleware)
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	if !hasCommand(rootCmd, "first") || !hasCommand(rootCmd, "second") {
+		t.Fatal("Expected both plugin commands to be registered")
+	}
+	if len(rootCmd.Middlewares) != 3 {
+		t.Fatalf("Expected 3 middlewares, got %d", len(rootCmd.Middlewares))
+	}
+
+	writePluginFile(t, dir, "first.lua", `add_command{use = "renamed", run = function(cmd, args) end}`)
+	if err := os.Remove(filepath.Join(dir, "second.lua")); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	if hasCommand(rootCmd, "first") || hasCommand(rootCmd, "second") {
+		t.Error("Expected the commands of the previous plugins to be removed")
+	}
+	if !hasCommand(rootCmd, "renamed") {
+		t.Error("Expected the reloaded plugin command to be registered")
+	}
+	if n := len(rootCmd.Commands()); n != 1 {
+		t.Errorf("Expected a single command after reloading, got %d", n)
+	}
+	if len(rootCmd.Middlewares) != 1 {
+		t.Errorf("Expected only the application middleware to remain, got %d", len(rootCmd.Middlewares))
+	}
+}
+
+func TestLoadPluginsKeepsSubcommandsOfPluginCommands(t *testing.T) {
+	setupPluginHome(t)
+	writePluginFile(t, testPluginDir(), "nested.lua", `add_command{use = "parent"}
+add_command{use = "child", parent = "parent", run = function(cmd, args) end}`)
+
+	rootCmd := &Command{Use: "root"}
+	for i := 0; i < 2; i++ {
+		if err := LoadPlugins(rootCmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(rootCmd.Commands()); n != 1 {
+		t.Fatalf("Expected a single plugin command, got %d", n)
+	}
+	if cmd, _, err := rootCmd.Find([]string{"parent", "child"}); err != nil || cmd.Name() != "child" {
+		t.Errorf("Expected the nested command to be registered, got %v", err)
+	}
+}
+
+func TestWatchPluginsReloadsOnChange(t *testing.T) {
+	setupPluginHome(t)
+	dir := testPluginDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rootCmd := &Command{Use: "root"}
+	var mu sync.Mutex
+	reloads := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- rootCmd.WatchPlugins(ctx, PluginWatchOptions{
+			Delay:    200 * time.Millisecond,
+			Locker:   &mu,
+			OnReload: func(err error) { reloads <- err },
+		})
+	}()
+	// Give the watcher time to start.
+	time.Sleep(100 * time.Millisecond)
+
+	writePluginFile(t, dir, "a.lua", `add_command{use = "a", run = function(cmd, args) end}`)
+	writePluginFile(t, dir, "b.lua", `add_command{use = "b", run = function(cmd, args) end}`)
+	writePluginFile(t, dir, "b.lua", `add_command{use = "b", run = function(cmd, args) end}`)
+
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the plugins to be reloaded")
+	}
+	select {
+	case <-reloads:
+		t.Error("Expected a burst of changes to trigger a single reload")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	mu.Lock()
+	if !hasCommand(rootCmd, "a") || !hasCommand(rootCmd, "b") {
+		t.Error("Expected the new plugins to be loaded")
+	}
+	mu.Unlock()
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected WatchPlugins to stop when canceled, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// PluginLoadStatus is the outcome of loading a single plugin.
+type PluginLoadStatus string
+
+const (
+	// PluginLoaded means the plugin was loaded successfully.
+	PluginLoaded PluginLoadStatus = "loaded"
+	// PluginSkipped means the plugin is disabled and was not loaded.
+	PluginSkipped PluginLoadStatus = "skipped"
+	// PluginFailed means the plugin could not be loaded, see PluginLoadResult.Err.
+	PluginFailed PluginLoadStatus = "failed"
+)
+
+// PluginLoadResult describes how a single plugin was loaded.
+type PluginLoadResult struct {
+	Name   string
+	Path   string
+	Status PluginLoadStatus
+	// Err is the reason a plugin failed to load. It is nil for loaded and skipped plugins.
+	Err error
+}
+
+// PluginLoadReport collects the outcome of loading the plugins of a root command.
+// Every plugin is loaded in isolation: a plugin that fails to load, or panics while
+// loading, is reported here and does not prevent the other plugins from loading.
+type PluginLoadReport struct {
+	// Bypassed is true when plugins were not loaded because of the --no-plugins flag
+	// or the <PROGRAM>_NO_PLUGINS or COBRA_NO_PLUGINS environment variables.
+	Bypassed bool
+	// Plugins lists the loaded plugins in load order, followed by the plugins that were
+	// skipped or failed to load.
+	Plugins []PluginLoadResult
+	// StateErr is set when the list of disabled plugins cannot be read, in which case
+	// every plugin is enabled.
+	StateErr error
+}
+
+// Loaded returns the names of the plugins that were loaded, in load order.
+func (r *PluginLoadReport) Loaded() []string {
+	var names []string
+	for _, p := range r.Plugins {
+		if p.Status == PluginLoaded {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// Failed returns the plugins that could not be loaded.
+func (r *PluginLoadReport) Failed() []PluginLoadResult {
+	var failed []PluginLoadResult
+	for _, p := range r.Plugins {
+		if p.Status == PluginFailed {
+			failed = append(failed, p)
+		}
+	}
+	return failed
+}
+
+// Err returns the errors of all the plugins that failed to load joined together, preceded
+// by StateErr, or nil if the state was read and every enabled plugin was loaded.
+func (r *PluginLoadReport) Err() error {
+	var errs []error
+	if r.StateErr != nil {
+		errs = append(errs, r.StateErr)
+	}
+	for _, p := range r.Failed() {
+		errs = append(errs, p.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// PluginLoadReport returns the outcome of loading the plugins of the root command of c,
+// or nil if plugins have not been loaded yet.
+func (c *Command) PluginLoadReport() *PluginLoadReport {
+	return c.Root().pluginReport
+}
+
+const (
+	noPluginsFlagName = "no-plugins"
+	noPluginsEnvVar   = "NO_PLUGINS"
+)
+
+// initNoPluginsFlag adds the hidden --no-plugins flag to c, so that it is accepted by every command.
+func (c *Command) initNoPluginsFlag() {
+	if c.PersistentFlags().Lookup(noPluginsFlagName) != nil || c.Flags().Lookup(noPluginsFlagName) != nil {
+		return
+	}
+	c.PersistentFlags().Bool(noPluginsFlagName, false, "run without loading plugins")
+	_ = c.PersistentFlags().MarkHidden(noPluginsFlagName)
+	_ = c.PersistentFlags().SetAnnotation(noPluginsFlagName, FlagSetByCobraAnnotation, []string{"true"})
+}
+
+// pluginsBypassed reports whether plugins must not be loaded for args.
+// Plugins are loaded before the flags are parsed, so args are scanned for --no-plugins directly.
+func pluginsBypassed(c *Command, args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+noPluginsFlagName {
+			return true
+		}
+		if value, ok := strings.CutPrefix(arg, "--"+noPluginsFlagName+"="); ok {
+			bypass, _ := strconv.ParseBool(value)
+			return bypass
+		}
+	}
+	bypass, _ := strconv.ParseBool(getEnvConfig(c, noPluginsEnvVar))
+	return bypass
+}
+
+// initPlugins loads the plugins of the root command c the first time it is executed, or again
+// if the previous execution shut them down, if c.PluginOptions.Enabled is set, and prints a
+// warning for every plugin that failed to load.
+func (c *Command) initPlugins(args []string) {
+	if !c.PluginOptions.Enabled {
+		return
+	}
+	c.initNoPluginsFlag()
+	if c.pluginsStopped {
+		c.ShutdownPlugins()
+	}
+	if c.pluginReport != nil {
+		return
+	}
+	if pluginsBypassed(c, args) {
+		c.pluginReport = &PluginLoadReport{Bypassed: true}
+		return
+	}
+	c.stopPluginsOnFinalize()
+	if err := LoadPlugins(c); err != nil && c.pluginReport == nil {
+		c.PrintErrln("Warning: failed to load plugins:", err)
+		return
+	}
+	if err := c.pluginReport.StateErr; err != nil {
+		c.PrintErrln("Warning:", err, "(all plugins are enabled)")
+	}
+	for _, p := range c.pluginReport.Failed() {
+		c.PrintErrln("Warning:", p.Err)
+	}
+}
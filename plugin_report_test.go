@@ -0,0 +1,145 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadPluginsIsolatesFailures(t *testing.T) {
+	setupPluginHome(t)
+	dir := testPluginDir()
+	writePluginFile(t, dir, "good.lua", `add_command{use = "good", run = function(cmd, args) end}`)
+	writePluginFile(t, dir, "broken.lua", `add_command{use = "broken", run = function(cmd, args) end}
+error("boom")`)
+	writePluginFile(t, dir, "dependent.lua", "")
+	writePluginFile(t, dir, "dependent.plugin.yaml", "dependencies: [broken]\n")
+	writePluginFile(t, dir, "invalid.lua", "")
+	writePluginFile(t, dir, "invalid.plugin.yaml", "permissions: [network]\n")
+	writePluginFile(t, dir, "off.lua", "")
+	if err := setPluginDisabled(dir, "off", true); err != nil {
+		t.Fatal(err)
+	}
+
+	rootCmd := &Command{Use: "root"}
+	err := LoadPlugins(rootCmd)
+	if err == nil {
+		t.Fatal("Expected an error for the plugins that failed to load")
+	}
+
+	report := rootCmd.PluginLoadReport()
+	if got := strings.Join(report.Loaded(), ","); got != "good" {
+		t.Errorf("Expected only good to be loaded, got %q", got)
+	}
+	status := make(map[string]PluginLoadStatus)
+	for _, p := range report.Plugins {
+		status[p.Name] = p.Status
+	}
+	expected := map[string]PluginLoadStatus{
+		"good":      PluginLoaded,
+		"broken":    PluginFailed,
+		"dependent": PluginFailed,
+		"invalid":   PluginFailed,
+		"off":       PluginSkipped,
+	}
+	for name, want := range expected {
+		if status[name] != want {
+			t.Errorf("Expected plugin %s to be %s, got %q", name, want, status[name])
+		}
+	}
+
+	if cmd, _, _ := rootCmd.Find([]string{"good"}); cmd == rootCmd {
+		t.Error("Expected the good plugin command to be registered")
+	}
+	if cmd, _, _ := rootCmd.Find([]string{"broken"}); cmd != rootCmd {
+		t.Error("Expected the commands of the broken plugin to be removed")
+	}
+}
+
+func TestLoadPluginsWithCorruptState(t *testing.T) {
+	setupPluginHome(t)
+	dir := testPluginDir()
+	writePluginFile(t, dir, "good.lua", `add_command{use = "good", run = function(cmd, args) end}`)
+	writePluginFile(t, dir, pluginStateFile, "{not json")
+
+	rootCmd := &Command{Use: "root", PluginOptions: PluginOptions{Enabled: true}, Run: emptyRun}
+	output, err := executeCommand(rootCmd, "good")
+	if err != nil {
+		t.Fatalf("Expected the plugins to load despite the corrupt state, got %v", err)
+	}
+	if !strings.Contains(output, "invalid plugin state") || !strings.Contains(output, "all plugins are enabled") {
+		t.Errorf("Expected a warning about the corrupt state, got %q", output)
+	}
+	report := rootCmd.PluginLoadReport()
+	if report.StateErr == nil || strings.Join(report.Loaded(), ",") != "good" {
+		t.Errorf("Expected the state error to be reported and good to be loaded, got %v and %q", report.StateErr, report.Loaded())
+	}
+}
+
+func TestExecuteWarnsOncePerFailedPlugin(t *testing.T) {
+	setupPluginHome(t)
+	writePluginFile(t, testPluginDir(), "broken.lua", `error("boom")`)
+
+	rootCmd := &Command{Use: "root", Run: emptyRun, PluginOptions: PluginOptions{Enabled: true}}
+	stderr := new(bytes.Buffer)
+	rootCmd.SetErr(stderr)
+	for i := 0; i < 2; i++ {
+		rootCmd.SetArgs([]string{})
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(stderr.String(), "failed to load plugin broken"); n != 1 {
+		t.Errorf("Expected a single warning, got %d in %q", n, stderr.String())
+	}
+}
+
+func TestNoPlugins(t *testing.T) {
+	tests := map[string]struct {
+		args []string
+		env  string
+	}{
+		"flag":     {args: []string{"--no-plugins"}},
+		"flag=1":   {args: []string{"--no-plugins=true"}},
+		"env":      {env: "1"},
+		"root env": {env: ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			setupPluginHome(t)
+			writePluginFile(t, testPluginDir(), "greet.lua", `add_command{use = "greet", run = function(cmd, args) end}`)
+			if tc.env != "" {
+				t.Setenv("COBRA_NO_PLUGINS", tc.env)
+			} else if tc.args == nil {
+				t.Setenv("ROOT_NO_PLUGINS", "true")
+			}
+
+			rootCmd := &Command{Use: "root", Run: emptyRun, PluginOptions: PluginOptions{Enabled: true}}
+			rootCmd.SetArgs(append([]string{}, tc.args...))
+			if err := rootCmd.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			report := rootCmd.PluginLoadReport()
+			if report == nil || !report.Bypassed {
+				t.Fatalf("Expected plugins to be bypassed, got %+v", report)
+			}
+			if cmd, _, _ := rootCmd.Find([]string{"greet"}); cmd != rootCmd {
+				t.Error("Expected no plugin command to be registered")
+			}
+		})
+	}
+}
@@ -0,0 +1,470 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+// Executable plugins talk to the host over JSON-RPC 2.0 on their stdin and stdout, one
+// message per line. The host starts the plugin with COBRA_PLUGIN_PROTOCOL set to
+// "jsonrpc/1" and sends a single request:
+//
+//   - "describe" returns the plugin name, version, API version and command tree.
+//   - "run" runs a command with its parsed flags and arguments. The plugin streams what
+//     the command prints with "output" notifications and answers with the exit code once
+//     the command finished. The host sends a "cancel" notification when the command must
+//     stop, and kills the plugin if it does not exit within ExecPluginCancelTimeout.
+//   - "complete" returns shell completions for the arguments or a flag of a command.
+//
+// The plugin exits once it has answered. What it writes to stderr is shown to the user.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	flag "github.com/spf13/pflag"
+)
+
+const (
+	execPluginProtocolEnvVar = "COBRA_PLUGIN_PROTOCOL"
+	execPluginProtocol       = "jsonrpc/1"
+
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	// rpcCommandFailed is the error code of a command that returned an error.
+	rpcCommandFailed = 1
+)
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+type execPluginDescribeParams struct {
+	APIVersion string `json:"api_version"`
+	Host       string `json:"host"`
+}
+
+type execPluginDescription struct {
+	Name        string            `json:"name,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Description string            `json:"description,omitempty"`
+	APIVersion  string            `json:"api_version,omitempty"`
+	Commands    []execCommandSpec `json:"commands"`
+}
+
+type execCommandSpec struct {
+	Use        string   `json:"use"`
+	Short      string   `json:"short,omitempty"`
+	Long       string   `json:"long,omitempty"`
+	Example    string   `json:"example,omitempty"`
+	Aliases    []string `json:"aliases,omitempty"`
+	Hidden     bool     `json:"hidden,omitempty"`
+	Deprecated string   `json:"deprecated,omitempty"`
+	// Runnable is false for commands that only group subcommands.
+	Runnable bool           `json:"runnable"`
+	Args     *execArgsSpec  `json:"args,omitempty"`
+	Flags    []execFlagSpec `json:"flags,omitempty"`
+	// Complete is set when the plugin completes the positional arguments of the command.
+	Complete bool              `json:"complete,omitempty"`
+	Commands []execCommandSpec `json:"commands,omitempty"`
+}
+
+type execArgsSpec struct {
+	Min *int `json:"min,omitempty"`
+	Max *int `json:"max,omitempty"`
+}
+
+type execFlagSpec struct {
+	Name      string `json:"name"`
+	Shorthand string `json:"shorthand,omitempty"`
+	// Type is one of string, int, bool, float, strings or duration. Defaults to string.
+	Type string `json:"type,omitempty"`
+	// Default is the default value as it would be given on the command line,
+	// comma separated for strings.
+	Default    string `json:"default,omitempty"`
+	Usage      string `json:"usage,omitempty"`
+	Required   bool   `json:"required,omitempty"`
+	Persistent bool   `json:"persistent,omitempty"`
+	Hidden     bool   `json:"hidden,omitempty"`
+	// Complete is set when the plugin completes the values of the flag.
+	Complete bool `json:"complete,omitempty"`
+}
+
+type execRunParams struct {
+	// Command is the path of the command, starting with the top level command of the plugin.
+	Command []string `json:"command"`
+	Args    []string `json:"args"`
+	// Flags holds the flags set on the command line. Flags given several times, or holding
+	// lists, have several values.
+	Flags map[string][]string `json:"flags,omitempty"`
+}
+
+type execRunResult struct {
+	ExitCode int `json:"exit_code"`
+}
+
+type execOutputParams struct {
+	// Stream is "stdout" or "stderr".
+	Stream string `json:"stream"`
+	Data   string `json:"data"`
+}
+
+type execCompleteParams struct {
+	Command []string            `json:"command"`
+	Args    []string            `json:"args"`
+	Flags   map[string][]string `json:"flags,omitempty"`
+	// Flag is the name of the flag whose value is completed, empty for positional arguments.
+	Flag       string `json:"flag,omitempty"`
+	ToComplete string `json:"to_complete"`
+}
+
+type execCompleteResult struct {
+	// Completions are "value" or "value\tdescription" strings.
+	Completions []string           `json:"completions"`
+	Directive   ShellCompDirective `json:"directive"`
+}
+
+// rpcConn exchanges line delimited JSON-RPC messages.
+type rpcConn struct {
+	dec *json.Decoder
+
+	mu     sync.Mutex // guards enc and nextID
+	enc    *json.Encoder
+	nextID int64
+}
+
+func newRPCConn(r io.Reader, w io.Writer) *rpcConn {
+	return &rpcConn{dec: json.NewDecoder(r), enc: json.NewEncoder(w)}
+}
+
+func (c *rpcConn) send(msg *rpcMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg.JSONRPC = "2.0"
+	return c.enc.Encode(msg)
+}
+
+func (c *rpcConn) read() (*rpcMessage, error) {
+	msg := &rpcMessage{}
+	if err := c.dec.Decode(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *rpcConn) notify(method string, params interface{}) error {
+	msg := &rpcMessage{Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		msg.Params = data
+	}
+	return c.send(msg)
+}
+
+func (c *rpcConn) reply(id *int64, result interface{}, err error) error {
+	msg := &rpcMessage{ID: id}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcCommandFailed, Message: err.Error()}
+		}
+		msg.Error = rpcErr
+		return c.send(msg)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	msg.Result = data
+	return c.send(msg)
+}
+
+// call sends a request and waits for its response, passing the notifications received
+// in the meantime to onNotify.
+func (c *rpcConn) call(method string, params, result interface{}, onNotify func(*rpcMessage)) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.mu.Unlock()
+	if err := c.send(&rpcMessage{ID: &id, Method: method, Params: data}); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := c.read()
+		if err == io.EOF {
+			return fmt.Errorf("plugin exited without answering %s", method)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s response: %v", method, err)
+		}
+		if msg.Method != "" {
+			if msg.ID == nil && onNotify != nil {
+				onNotify(msg)
+			}
+			continue
+		}
+		if msg.ID == nil || *msg.ID != id {
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+// ServePlugin runs root as an executable plugin: it answers the request the host sent on
+// stdin and returns. Use it in the main function of a program installed in the plugin
+// directory, or on the PATH as "<host>-<name>":
+//
+//	func main() {
+//		if err := cobra.ServePlugin(rootCmd); err != nil {
+//			os.Exit(1)
+//		}
+//	}
+//
+// The host registers root, with its flags and subcommands, as a command of its own
+// root command. Commands are executed by the plugin with the flags and arguments
+// parsed by the host, so root is executed as usual, including its hooks.
+func ServePlugin(root *Command) error {
+	return servePlugin(root, os.Stdin, os.Stdout)
+}
+
+// IsPluginProcess reports whether the program was started by a host as an executable
+// plugin, in which case it should call ServePlugin instead of executing its root command.
+func IsPluginProcess() bool {
+	return os.Getenv(execPluginProtocolEnvVar) == execPluginProtocol
+}
+
+func servePlugin(root *Command, r io.Reader, w io.Writer) error {
+	conn := newRPCConn(r, w)
+	msg, err := conn.read()
+	if err != nil {
+		return err
+	}
+	if msg.ID == nil {
+		return fmt.Errorf("expected a request, got %s notification", msg.Method)
+	}
+
+	// The plugin is not allowed to load plugins of its own.
+	root.pluginReport = &PluginLoadReport{Bypassed: true}
+
+	switch msg.Method {
+	case "describe":
+		return conn.reply(msg.ID, describePlugin(root), nil)
+	case "run":
+		var params execRunParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return conn.reply(msg.ID, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()})
+		}
+		return serveRun(root, conn, msg.ID, params)
+	case "complete":
+		var params execCompleteParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return conn.reply(msg.ID, nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()})
+		}
+		return serveComplete(root, conn, msg.ID, params)
+	}
+	return conn.reply(msg.ID, nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + msg.Method})
+}
+
+func serveRun(root *Command, conn *rpcConn, id *int64, params execRunParams) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			msg, err := conn.read()
+			if err != nil {
+				// The host went away, there is nobody to report to anymore.
+				cancel()
+				return
+			}
+			if msg.Method == "cancel" {
+				cancel()
+			}
+		}
+	}()
+
+	root.SetOut(&rpcOutputWriter{conn: conn, stream: "stdout"})
+	root.SetErr(&rpcOutputWriter{conn: conn, stream: "stderr"})
+	// The host reports errors and prints usage itself.
+	root.SilenceErrors = true
+	root.SilenceUsage = true
+	root.SetArgs(servedArgs(params.Command, params.Flags, params.Args))
+	if err := root.ExecuteContext(ctx); err != nil {
+		return conn.reply(id, nil, err)
+	}
+	return conn.reply(id, execRunResult{}, nil)
+}
+
+func serveComplete(root *Command, conn *rpcConn, id *int64, params execCompleteParams) error {
+	args := servedArgs(params.Command, params.Flags, nil)
+	args = append(args, params.Args...)
+	if params.Flag != "" {
+		args = append(args, "--"+params.Flag)
+	}
+	args = append([]string{ShellCompRequestCmd}, append(args, params.ToComplete)...)
+
+	out := new(strings.Builder)
+	root.SetOut(out)
+	root.SetErr(io.Discard)
+	root.SetArgs(args)
+	if err := root.Execute(); err != nil {
+		return conn.reply(id, nil, err)
+	}
+
+	result := execCompleteResult{Completions: []string{}}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	for _, line := range lines {
+		if directive, ok := strings.CutPrefix(line, ":"); ok {
+			var d int
+			if _, err := fmt.Sscanf(directive, "%d", &d); err == nil {
+				result.Directive = ShellCompDirective(d)
+			}
+			continue
+		}
+		if line != "" {
+			result.Completions = append(result.Completions, line)
+		}
+	}
+	return conn.reply(id, result, nil)
+}
+
+// servedArgs rebuilds the command line of a command run by the host. The first element of
+// path is the root command of the plugin and is not part of its arguments.
+func servedArgs(path []string, flags map[string][]string, args []string) []string {
+	argv := []string{}
+	if len(path) > 1 {
+		argv = append(argv, path[1:]...)
+	}
+	for name, values := range flags {
+		for _, v := range values {
+			argv = append(argv, "--"+name+"="+v)
+		}
+	}
+	if len(args) > 0 {
+		argv = append(argv, "--")
+		argv = append(argv, args...)
+	}
+	return argv
+}
+
+// rpcOutputWriter streams what a command prints to the host.
+type rpcOutputWriter struct {
+	conn   *rpcConn
+	stream string
+}
+
+func (w *rpcOutputWriter) Write(p []byte) (int, error) {
+	if err := w.conn.notify("output", execOutputParams{Stream: w.stream, Data: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func describePlugin(root *Command) execPluginDescription {
+	return execPluginDescription{
+		Name:        root.Name(),
+		Version:     root.Version,
+		Description: root.Short,
+		APIVersion:  PluginAPIVersion,
+		Commands:    []execCommandSpec{describeCommand(root)},
+	}
+}
+
+func describeCommand(cmd *Command) execCommandSpec {
+	spec := execCommandSpec{
+		Use:        cmd.Use,
+		Short:      cmd.Short,
+		Long:       cmd.Long,
+		Example:    cmd.Example,
+		Aliases:    cmd.Aliases,
+		Hidden:     cmd.Hidden,
+		Deprecated: cmd.Deprecated,
+		Runnable:   cmd.Runnable(),
+		Complete:   cmd.ValidArgsFunction != nil || len(cmd.ValidArgs) > 0,
+	}
+	cmd.LocalNonPersistentFlags().VisitAll(func(f *flag.Flag) {
+		if f.Name != helpFlagName {
+			spec.Flags = append(spec.Flags, describeFlag(cmd, f, false))
+		}
+	})
+	cmd.PersistentFlags().VisitAll(func(f *flag.Flag) {
+		spec.Flags = append(spec.Flags, describeFlag(cmd, f, true))
+	})
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == helpCommandName || sub.Name() == ShellCompRequestCmd || sub.Name() == ShellCompNoDescRequestCmd {
+			continue
+		}
+		spec.Commands = append(spec.Commands, describeCommand(sub))
+	}
+	return spec
+}
+
+func describeFlag(cmd *Command, f *flag.Flag, persistent bool) execFlagSpec {
+	spec := execFlagSpec{
+		Name:       f.Name,
+		Shorthand:  f.Shorthand,
+		Type:       "string",
+		Default:    f.DefValue,
+		Usage:      f.Usage,
+		Persistent: persistent,
+		Hidden:     f.Hidden,
+	}
+	if slice, ok := f.Value.(flag.SliceValue); ok {
+		spec.Type = "strings"
+		spec.Default = strings.Join(slice.GetSlice(), ",")
+	} else {
+		switch f.Value.Type() {
+		case "int", "bool", "duration":
+			spec.Type = f.Value.Type()
+		case "float64":
+			spec.Type = "float"
+		}
+	}
+	if _, ok := f.Annotations[BashCompOneRequiredFlag]; ok {
+		spec.Required = true
+	}
+	_, spec.Complete = cmd.GetFlagCompletionFunc(f.Name)
+	return spec
+}
@@ -0,0 +1,409 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PluginSignaturePolicy tells how the plugins of the plugin directories are checked before
+// they are loaded. A plugin passes the checks when its signature is valid for one of the
+// trusted keys, and it has not been modified since it was approved.
+//
+// A plugin is signed by SignPlugin, which writes the signature next to its entrypoint, to
+// "<entrypoint>.sig". It is approved the first time it passes the checks, or with
+// "plugin approve". Plugins built into the program are not checked, and plugins found on
+// the PATH, which cannot be, always fail the checks.
+type PluginSignaturePolicy string
+
+const (
+	// PluginSignaturesOff loads plugins without checking them.
+	PluginSignaturesOff PluginSignaturePolicy = "off"
+	// PluginSignaturesWarn prints a warning for plugins failing the checks and loads them anyway.
+	PluginSignaturesWarn PluginSignaturePolicy = "warn"
+	// PluginSignaturesEnforce refuses to load plugins failing the checks.
+	PluginSignaturesEnforce PluginSignaturePolicy = "enforce"
+)
+
+// DefaultPluginSignaturePolicy is the policy used unless the <PROGRAM>_PLUGIN_SIGNATURES or
+// COBRA_PLUGIN_SIGNATURES environment variable sets another one. Unknown values are
+// treated as PluginSignaturesEnforce.
+var DefaultPluginSignaturePolicy = PluginSignaturesOff
+
+const (
+	pluginSignaturesEnvVar = "PLUGIN_SIGNATURES"
+	pluginTrustStoreFile   = "trusted_keys.json"
+	pluginLockFile         = "plugins.lock"
+	pluginSignatureExt     = ".sig"
+)
+
+func pluginSignaturePolicy(rootCmd *Command) PluginSignaturePolicy {
+	v := getEnvConfig(rootCmd, pluginSignaturesEnvVar)
+	if v == "" {
+		return DefaultPluginSignaturePolicy
+	}
+	switch policy := PluginSignaturePolicy(strings.ToLower(v)); policy {
+	case PluginSignaturesOff, PluginSignaturesWarn, PluginSignaturesEnforce:
+		return policy
+	}
+	return PluginSignaturesEnforce
+}
+
+// trustedKey is a public key plugins may be signed with.
+type trustedKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// pluginTrustStore is kept in the data directory of the program rather than in a plugin
+// directory, so that files dropped into a plugin directory cannot change it.
+type pluginTrustStore struct {
+	Keys []trustedKey `json:"keys"`
+	file string
+}
+
+func readPluginTrustStore(rootCmd *Command) (*pluginTrustStore, error) {
+	store := &pluginTrustStore{file: filepath.Join(pluginDataDir(rootCmd), pluginTrustStoreFile)}
+	if err := readJSONFile(store.file, store); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *pluginTrustStore) write() error {
+	sort.Slice(s.Keys, func(i, j int) bool { return s.Keys[i].Name < s.Keys[j].Name })
+	return writeJSONFile(s.file, s)
+}
+
+func (s *pluginTrustStore) add(name, key string) error {
+	if _, err := parsePluginPublicKey(key); err != nil {
+		return err
+	}
+	for _, k := range s.Keys {
+		if k.Name == name {
+			return fmt.Errorf("a key named %s is already trusted", name)
+		}
+		if k.Key == key {
+			return fmt.Errorf("the key is already trusted as %s", k.Name)
+		}
+	}
+	s.Keys = append(s.Keys, trustedKey{Name: name, Key: key})
+	return nil
+}
+
+func (s *pluginTrustStore) remove(name string) error {
+	for i, k := range s.Keys {
+		if k.Name == name {
+			s.Keys = append(s.Keys[:i], s.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no key named %s is trusted", name)
+}
+
+// signer returns the name of the trusted key the signature of p was made with.
+func (s *pluginTrustStore) signer(p *discoveredPlugin, digest []byte) (string, error) {
+	data, err := os.ReadFile(p.Path + pluginSignatureExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("plugin %s is not signed", p.Manifest.Name)
+		}
+		return "", err
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return "", fmt.Errorf("invalid signature %s: %v", p.Path+pluginSignatureExt, err)
+	}
+	for _, k := range s.Keys {
+		key, err := parsePluginPublicKey(k.Key)
+		if err == nil && ed25519.Verify(key, digest, sig) {
+			return k.Name, nil
+		}
+	}
+	return "", fmt.Errorf("plugin %s is not signed by a trusted key", p.Manifest.Name)
+}
+
+// parsePluginPublicKey decodes a base64 encoded ed25519 public key.
+func parsePluginPublicKey(key string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(data) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid key: expected a base64 encoded ed25519 public key")
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// pluginLock records the checksums of the approved plugins. Like the trust store, it is kept
+// in the data directory of the program.
+type pluginLock struct {
+	Plugins map[string]string `json:"plugins"`
+	file    string
+}
+
+func readPluginLock(rootCmd *Command) (*pluginLock, error) {
+	lock := &pluginLock{file: filepath.Join(pluginDataDir(rootCmd), pluginLockFile)}
+	if err := readJSONFile(lock.file, lock); err != nil {
+		return nil, err
+	}
+	if lock.Plugins == nil {
+		lock.Plugins = make(map[string]string)
+	}
+	return lock, nil
+}
+
+func (l *pluginLock) write() error {
+	return writeJSONFile(l.file, l)
+}
+
+// check returns an error if p was approved with a different checksum.
+func (l *pluginLock) check(p *discoveredPlugin, digest []byte, rootName string) error {
+	approved, ok := l.Plugins[p.Manifest.Name]
+	if ok && approved != pluginChecksum(digest) {
+		return fmt.Errorf("plugin %s has been modified since it was approved, run '%s plugin approve %s' to approve it again",
+			p.Manifest.Name, rootName, p.Manifest.Name)
+	}
+	return nil
+}
+
+func pluginChecksum(digest []byte) string {
+	return "sha256:" + hex.EncodeToString(digest)
+}
+
+// pluginDigest returns the digest plugins are signed and approved by. It covers the
+// entrypoint and the manifest of a single file plugin, and every file of a directory plugin
+// but its signatures, so that modules loaded by the entrypoint are covered as well.
+func pluginDigest(entrypoint, manifestFile string) ([]byte, error) {
+	if filepath.Base(manifestFile) == pluginManifestFile {
+		return pluginDirDigest(filepath.Dir(manifestFile))
+	}
+	h := sha256.New()
+	for _, file := range []string{entrypoint, manifestFile} {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		h.Write(sum[:])
+	}
+	return h.Sum(nil), nil
+}
+
+// pluginDirDigest hashes the relative path and the content of every regular file under dir,
+// in lexical order, except the signatures. Symbolic links are hashed by their target.
+func pluginDirDigest(dir string) ([]byte, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, pluginSignatureExt) {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		switch {
+		case d.Type()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			data = []byte(target)
+		case d.Type().IsRegular():
+			if data, err = os.ReadFile(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		h.Write([]byte(filepath.ToSlash(rel) + "\x00"))
+		h.Write(sum[:])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// SignPlugin signs the plugin at path with key. path is a plugin directory holding a
+// plugin.yaml manifest, or a single file plugin. The signature is written next to the
+// entrypoint of the plugin, to "<entrypoint>.sig".
+func SignPlugin(path string, key ed25519.PrivateKey) error {
+	entrypoint, manifestFile, err := pluginFiles(path)
+	if err != nil {
+		return err
+	}
+	digest, err := pluginDigest(entrypoint, manifestFile)
+	if err != nil {
+		return err
+	}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest))
+	return writeFileAtomic(entrypoint+pluginSignatureExt, []byte(sig+"\n"), 0644)
+}
+
+// pluginFiles returns the entrypoint and the manifest of the plugin at path.
+func pluginFiles(path string) (entrypoint, manifestFile string, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", "", err
+	}
+	if !info.IsDir() {
+		if manifestFile = pluginManifestPath(path); !fileExists(manifestFile) {
+			manifestFile = ""
+		}
+		return path, manifestFile, nil
+	}
+	manifestFile = filepath.Join(path, pluginManifestFile)
+	var manifest PluginManifest
+	if err := readPluginManifestFile(manifestFile, &manifest); err != nil {
+		return "", "", err
+	}
+	if manifest.Entrypoint == "" {
+		return "", "", fmt.Errorf("invalid plugin manifest %s: entrypoint is required", manifestFile)
+	}
+	return filepath.Join(path, manifest.Entrypoint), manifestFile, nil
+}
+
+// checkPluginTrust checks the plugins of the plugin directories according to the signature
+// policy of rootCmd. Under PluginSignaturesEnforce, the plugins failing the checks get Err
+// set. When load is set, plugins failing the checks under PluginSignaturesWarn are warned
+// about, and the plugins passing them for the first time are approved.
+func checkPluginTrust(rootCmd *Command, plugins []*discoveredPlugin, load bool) error {
+	policy := pluginSignaturePolicy(rootCmd)
+	if policy == PluginSignaturesOff {
+		return nil
+	}
+	store, err := readPluginTrustStore(rootCmd)
+	if err != nil {
+		return err
+	}
+	lock, err := readPluginLock(rootCmd)
+	if err != nil {
+		return err
+	}
+
+	approved := false
+	for _, p := range plugins {
+		if p.Err != nil || p.Disabled || p.Static != nil {
+			continue
+		}
+		var digest []byte
+		if p.OnPath {
+			// Executables found on the PATH have no signature nor approval to check, and would
+			// be run to describe their commands.
+			err = fmt.Errorf("plugin %s is found on the PATH, where plugins cannot be checked", p.Manifest.Name)
+		} else {
+			digest, err = pluginDigest(p.Path, p.ManifestFile)
+		}
+		if err == nil {
+			_, err = store.signer(p, digest)
+		}
+		if err == nil {
+			err = lock.check(p, digest, rootCmd.Name())
+		}
+		if err != nil && policy == PluginSignaturesEnforce {
+			p.Err = err
+			continue
+		}
+		if !load {
+			continue
+		}
+		if err != nil {
+			rootCmd.PrintErrln("Warning:", err)
+			continue
+		}
+		if _, ok := lock.Plugins[p.Manifest.Name]; !ok {
+			lock.Plugins[p.Manifest.Name] = pluginChecksum(digest)
+			approved = true
+		}
+	}
+	if approved {
+		return lock.write()
+	}
+	return nil
+}
+
+// approvePlugin records the current checksum of p in the lockfile.
+func approvePlugin(rootCmd *Command, p *discoveredPlugin) error {
+	if p.Static != nil || p.OnPath {
+		return fmt.Errorf("plugin %s is not installed in a plugin directory", p.Manifest.Name)
+	}
+	digest, err := pluginDigest(p.Path, p.ManifestFile)
+	if err != nil {
+		return err
+	}
+	lock, err := readPluginLock(rootCmd)
+	if err != nil {
+		return err
+	}
+	lock.Plugins[p.Manifest.Name] = pluginChecksum(digest)
+	return lock.write()
+}
+
+// pluginSignature describes the signature of p for "plugin info".
+func pluginSignature(rootCmd *Command, p *discoveredPlugin) string {
+	if p.Static != nil {
+		return "built into the program"
+	}
+	digest, err := pluginDigest(p.Path, p.ManifestFile)
+	if err != nil {
+		return err.Error()
+	}
+	store, err := readPluginTrustStore(rootCmd)
+	if err != nil {
+		return err.Error()
+	}
+	signer, err := store.signer(p, digest)
+	if err != nil {
+		return err.Error()
+	}
+	return "signed by " + signer
+}
+
+func readJSONFile(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %v", file, err)
+	}
+	return nil
+}
+
+func writeJSONFile(file string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, append(data, '\n'), 0644)
+}
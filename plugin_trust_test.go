@@ -0,0 +1,230 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newPluginKey(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(pub), priv
+}
+
+func runPluginCommand(t *testing.T, rootCmd *Command, args ...string) (string, error) {
+	t.Helper()
+	if !hasCommand(rootCmd, "plugin") {
+		rootCmd.AddCommand(CreatePluginCommand())
+	}
+	cmd, rest, err := rootCmd.Find(append([]string{"plugin"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	cmd.SetOut(out)
+	if err := cmd.ValidateArgs(rest); err != nil {
+		return "", err
+	}
+	err = cmd.RunE(cmd, rest)
+	return out.String(), err
+}
+
+func pluginStatuses(report *PluginLoadReport) map[string]PluginLoadResult {
+	results := make(map[string]PluginLoadResult)
+	for _, p := range report.Plugins {
+		results[p.Name] = p
+	}
+	return results
+}
+
+func TestPluginSignaturesEnforced(t *testing.T) {
+	setupPluginHome(t)
+	t.Setenv("COBRA_PLUGIN_SIGNATURES", "enforce")
+	dir := testPluginDir()
+	trusted, trustedKey := newPluginKey(t)
+	_, otherKey := newPluginKey(t)
+
+	writePluginFile(t, dir, "good/plugin.yaml", "entrypoint: main.lua\n")
+	writePluginFile(t, dir, "good/main.lua", `add_command{use = "good", run = function(cmd, args) end}`)
+	writePluginFile(t, dir, "unsigned.lua", `add_command{use = "unsigned", run = function(cmd, args) end}`)
+	writePluginFile(t, dir, "other.lua", `add_command{use = "other", run = function(cmd, args) end}`)
+	if err := SignPlugin(filepath.Join(dir, "good"), trustedKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := SignPlugin(filepath.Join(dir, "other.lua"), otherKey); err != nil {
+		t.Fatal(err)
+	}
+
+	rootCmd := &Command{Use: "root"}
+	if _, err := runPluginCommand(t, rootCmd, "trust", "add", "release", trusted); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = LoadPlugins(rootCmd)
+	results := pluginStatuses(rootCmd.PluginLoadReport())
+	if results["good"].Status != PluginLoaded {
+		t.Errorf("Expected the signed plugin to be loaded, got %v", results["good"].Err)
+	}
+	if err := results["unsigned"].Err; err == nil || !strings.Contains(err.Error(), "is not signed") {
+		t.Errorf("Expected the unsigned plugin to be refused, got %v", err)
+	}
+	if err := results["other"].Err; err == nil || !strings.Contains(err.Error(), "not signed by a trusted key") {
+		t.Errorf("Expected the plugin signed by an unknown key to be refused, got %v", err)
+	}
+
+	// A modified plugin is refused even when signed again, until it is approved.
+	writePluginFile(t, dir, "good/main.lua", `add_command{use = "better", run = function(cmd, args) end}`)
+	if err := SignPlugin(filepath.Join(dir, "good"), trustedKey); err != nil {
+		t.Fatal(err)
+	}
+	_ = LoadPlugins(rootCmd)
+	if err := pluginStatuses(rootCmd.PluginLoadReport())["good"].Err; err == nil || !strings.Contains(err.Error(), "modified since it was approved") {
+		t.Errorf("Expected the modified plugin to be refused, got %v", err)
+	}
+	if cmd, _, _ := rootCmd.Find([]string{"better"}); cmd != rootCmd {
+		t.Error("Expected the commands of the modified plugin not to be registered")
+	}
+
+	if _, err := runPluginCommand(t, rootCmd, "approve", "good"); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPlugins(rootCmd); err == nil {
+		t.Fatal("Expected the other plugins to still fail")
+	}
+	if status := pluginStatuses(rootCmd.PluginLoadReport())["good"].Status; status != PluginLoaded {
+		t.Errorf("Expected the approved plugin to be loaded, got %s", status)
+	}
+
+	// Other files of a directory plugin, such as the modules its entrypoint loads, are covered too.
+	writePluginFile(t, dir, "good/lib/util.lua", "return {}")
+	_ = LoadPlugins(rootCmd)
+	if err := pluginStatuses(rootCmd.PluginLoadReport())["good"].Err; err == nil || !strings.Contains(err.Error(), "not signed by a trusted key") {
+		t.Errorf("Expected the signature to be invalidated by a new module, got %v", err)
+	}
+	if err := SignPlugin(filepath.Join(dir, "good"), trustedKey); err != nil {
+		t.Fatal(err)
+	}
+	_ = LoadPlugins(rootCmd)
+	if err := pluginStatuses(rootCmd.PluginLoadReport())["good"].Err; err == nil || !strings.Contains(err.Error(), "modified since it was approved") {
+		t.Errorf("Expected the plugin with a new module to need approval, got %v", err)
+	}
+	if _, err := runPluginCommand(t, rootCmd, "approve", "good"); err != nil {
+		t.Fatal(err)
+	}
+	writePluginFile(t, dir, "good/lib/util.lua", "return {changed = true}")
+	if err := SignPlugin(filepath.Join(dir, "good"), trustedKey); err != nil {
+		t.Fatal(err)
+	}
+	_ = LoadPlugins(rootCmd)
+	if err := pluginStatuses(rootCmd.PluginLoadReport())["good"].Err; err == nil || !strings.Contains(err.Error(), "modified since it was approved") {
+		t.Errorf("Expected an edited module to need approval, got %v", err)
+	}
+	if _, err := runPluginCommand(t, rootCmd, "approve", "good"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the key revokes the plugins signed with it.
+	if _, err := runPluginCommand(t, rootCmd, "trust", "remove", "release"); err != nil {
+		t.Fatal(err)
+	}
+	_ = LoadPlugins(rootCmd)
+	if status := pluginStatuses(rootCmd.PluginLoadReport())["good"].Status; status != PluginFailed {
+		t.Errorf("Expected the plugin to be refused once its key is removed, got %s", status)
+	}
+}
+
+func TestPluginSignaturesWarn(t *testing.T) {
+	setupPluginHome(t)
+	t.Setenv("COBRA_PLUGIN_SIGNATURES", "warn")
+	dir := testPluginDir()
+	key, priv := newPluginKey(t)
+	writePluginFile(t, dir, "unsigned.lua", `add_command{use = "unsigned", run = function(cmd, args) end}`)
+	writePluginFile(t, dir, "signed.lua", `add_command{use = "signed", run = function(cmd, args) end}`)
+	if err := SignPlugin(filepath.Join(dir, "signed.lua"), priv); err != nil {
+		t.Fatal(err)
+	}
+
+	rootCmd := &Command{Use: "root"}
+	if _, err := runPluginCommand(t, rootCmd, "trust", "add", "release", key); err != nil {
+		t.Fatal(err)
+	}
+	stderr := new(bytes.Buffer)
+	rootCmd.SetErr(stderr)
+	if err := LoadPlugins(rootCmd); err != nil {
+		t.Fatalf("Expected the unsigned plugin to be loaded with a warning, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Warning: plugin unsigned is not signed") {
+		t.Errorf("Expected a warning about the unsigned plugin, got %q", stderr.String())
+	}
+
+	lock, err := readPluginLock(rootCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(lock.Plugins["signed"], "sha256:") {
+		t.Errorf("Expected the signed plugin to be approved on first use, got %v", lock.Plugins)
+	}
+	if _, ok := lock.Plugins["unsigned"]; ok {
+		t.Errorf("Expected the unsigned plugin not to be approved, got %v", lock.Plugins)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(testPluginDir()), pluginLockFile)); err != nil {
+		t.Errorf("Expected the lockfile to be written outside the plugin directory: %v", err)
+	}
+}
+
+func TestPluginTrustCommands(t *testing.T) {
+	setupPluginHome(t)
+	key, _ := newPluginKey(t)
+	keyFile := filepath.Join(t.TempDir(), "release.pub")
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rootCmd := &Command{Use: "root"}
+	if _, err := runPluginCommand(t, rootCmd, "trust", "add", "release", keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runPluginCommand(t, rootCmd, "trust", "add", "again", key); err == nil || !strings.Contains(err.Error(), "already trusted as release") {
+		t.Errorf("Expected a duplicate key to be rejected, got %v", err)
+	}
+	if _, err := runPluginCommand(t, rootCmd, "trust", "add", "bad", "not-a-key"); err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+
+	out, err := runPluginCommand(t, rootCmd, "trust", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "release") || !strings.Contains(out, key) {
+		t.Errorf("Expected the trusted key to be listed, got %q", out)
+	}
+
+	if _, err := runPluginCommand(t, rootCmd, "trust", "remove", "release"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runPluginCommand(t, rootCmd, "trust", "remove", "release"); err == nil {
+		t.Error("Expected removing an unknown key to fail")
+	}
+}
@@ -20,15 +20,20 @@ import (
 	"path/filepath"
 	"plugin"
 	"sync"
-
-	"github.com/fsnotify/fsnotify"
-	lua "github.com/yuin/gopher-lua"
 )
 
-var pluginWatcherStarted bool
+// pluginMutex serializes loading and unloading plugins.
 var pluginMutex sync.Mutex
-var globalRootCmd *Command
 
+// Plugin is implemented by Go plugins, either compiled into the program and registered with
+// RegisterPlugin, or built with "go build -buildmode=plugin" and exporting a variable named
+// Plugin. The methods are called in phases: every plugin is initialized, then every plugin
+// adds its commands, then modifies commands, then adds its middlewares, so that a plugin can
+// modify the commands added by any other plugin. Within a phase, plugins are called after the
+// plugins they depend on.
+//
+// Plugins implementing PluginShutdowner are shut down when the execution that loaded them
+// finishes, or when they are unloaded.
 type Plugin interface {
 	Init(rootCmd *Command) error
 	AddCommands(rootCmd *Command) error
@@ -36,129 +41,161 @@ type Plugin interface {
 	AddMiddlewares(rootCmd *Command) error
 }
 
+// LoadPlugins loads the enabled plugins of the plugin directories into rootCmd, every plugin
+// after the plugins it depends on. Plugins that are invalid, incompatible with this version
+// of the plugin API, whose dependencies cannot be loaded or that fail to load are skipped
+// without affecting the others, and are reported in the error. The full outcome is available
+// from rootCmd.PluginLoadReport().
+//
+// Calling LoadPlugins again reloads the plugins: the commands and middlewares added by the
+// previously loaded plugins are removed before the plugins are loaded again. See WatchPlugins
+// to reload plugins automatically.
 func LoadPlugins(rootCmd *Command) error {
 	pluginMutex.Lock()
 	defer pluginMutex.Unlock()
 
-	globalRootCmd = rootCmd
+	report, err := loadPlugins(rootCmd)
+	if err != nil {
+		return err
+	}
+	rootCmd.pluginReport = report
+	return report.Err()
+}
+
+func loadPlugins(rootCmd *Command) (*PluginLoadReport, error) {
+	report := &PluginLoadReport{}
+	// A corrupt state must not prevent the plugins from loading, they are all enabled instead.
+	state, err := readPluginState(pluginDirs(rootCmd)[0])
+	if err != nil {
+		report.StateErr = err
+		state = &pluginState{}
+	}
+	plugins, err := findPlugins(rootCmd, state)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPluginTrust(rootCmd, plugins, true); err != nil {
+		return nil, err
+	}
+	unloadPlugins(rootCmd)
 
-	if !pluginWatcherStarted {
-		go startPluginWatcher()
-		pluginWatcherStarted = true
+	ordered, failed := orderPlugins(plugins)
+	for _, l := range loadPluginsInPhases(rootCmd, ordered) {
+		result := PluginLoadResult{Name: l.name(), Path: l.p.Path, Status: PluginLoaded}
+		if l.err != nil {
+			result.Status, result.Err = PluginFailed, l.err
+		} else {
+			rootCmd.loadedPlugins = append(rootCmd.loadedPlugins, l.loaded)
+		}
+		report.Plugins = append(report.Plugins, result)
 	}
 
-	pluginDir := filepath.Join(os.Getenv("HOME"), ".cobra", "plugins")
-	if _, err := os.Stat(pluginDir); os.IsNotExist(err) {
-		return nil // No plugins
+	for _, p := range plugins {
+		result := PluginLoadResult{Name: p.Manifest.Name, Path: p.Path}
+		switch {
+		case p.Err != nil:
+			result.Status, result.Err = PluginFailed, p.Err
+		case p.Disabled:
+			result.Status = PluginSkipped
+		case failed[p.Manifest.Name] != nil:
+			result.Status, result.Err = PluginFailed, failed[p.Manifest.Name]
+		default:
+			continue
+		}
+		report.Plugins = append(report.Plugins, result)
 	}
+	return report, nil
+}
 
-	return filepath.Walk(pluginDir, func(path string, info os.FileInfo, err error) error {
+// findPlugins returns the plugins registered with RegisterPlugin, the plugins of the plugin
+// directories of rootCmd and, if EnablePathPlugins is set, the executables named
+// "<rootName>-<name>" on the PATH that are not shadowed by a plugin of the same name. The
+// plugins state disables are marked as such.
+func findPlugins(rootCmd *Command, state *pluginState) ([]*discoveredPlugin, error) {
+	dirs := pluginDirs(rootCmd)
+	plugins := registeredPlugins()
+	static := make(map[string]bool, len(plugins))
+	for _, p := range plugins {
+		static[p.Manifest.Name] = true
+	}
+	known := make(map[string]bool, len(plugins))
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		discovered, err := discoverPlugins(dir)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if info.IsDir() {
-			return nil
+		for _, p := range discovered {
+			if known[p.Manifest.Name] {
+				// Shadowed by a plugin of an earlier directory.
+				continue
+			}
+			if p.Err == nil && static[p.Manifest.Name] {
+				p.Err = fmt.Errorf("plugin %s is already built into the program", p.Manifest.Name)
+			}
+			known[p.Manifest.Name] = true
+			plugins = append(plugins, p)
 		}
-
-		ext := filepath.Ext(path)
-		switch ext {
-		case ".so":
-			return loadGoPlugin(path, rootCmd)
-		case ".lua":
-			return loadLuaPlugin(path, rootCmd)
+	}
+	if EnablePathPlugins {
+		for _, p := range discoverPathPlugins(rootCmd.Name()) {
+			if !known[p.Manifest.Name] && !static[p.Manifest.Name] {
+				plugins = append(plugins, p)
+			}
 		}
-		return nil
-	})
+	}
+
+	for _, p := range plugins {
+		p.Disabled = p.Disabled || stringInSlice(p.Manifest.Name, state.Disabled)
+	}
+	return plugins, nil
+}
+
+// preparePlugin returns the Plugin value of p, or for plugins that are not driven through
+// the Plugin phases, the function loading it.
+func preparePlugin(p *discoveredPlugin, rootCmd *Command) (Plugin, func() error, error) {
+	if p.Static != nil {
+		return p.Static, nil, nil
+	}
+	switch filepath.Ext(p.Path) {
+	case ".so":
+		return openGoPlugin(p.Path, rootCmd)
+	case ".lua":
+		return newLuaPlugin(p.Path, &p.Manifest), nil, nil
+	}
+	return nil, func() error { return loadExecPlugin(p, rootCmd) }, nil
 }
 
-func loadGoPlugin(path string, rootCmd *Command) error {
+// openGoPlugin opens the Go plugin at path. Plugins export either a variable named Plugin
+// implementing the Plugin interface, or an Init function called with the root command.
+func openGoPlugin(path string, rootCmd *Command) (Plugin, func() error, error) {
 	p, err := plugin.Open(path)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if sym, err := p.Lookup("Plugin"); err == nil {
+		switch v := sym.(type) {
+		case *Plugin:
+			if *v == nil {
+				return nil, nil, fmt.Errorf("plugin %s exports a nil Plugin", path)
+			}
+			return *v, nil, nil
+		case Plugin:
+			return v, nil, nil
+		}
+		return nil, nil, fmt.Errorf("plugin %s exports Plugin of type %T which does not implement cobra.Plugin", path, sym)
 	}
 	sym, err := p.Lookup("Init")
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("plugin %s exports neither Plugin nor Init", path)
 	}
 	initFunc, ok := sym.(func(*Command) error)
 	if !ok {
-		return fmt.Errorf("plugin %s has invalid Init function", path)
+		return nil, nil, fmt.Errorf("plugin %s has invalid Init function", path)
 	}
-	return initFunc(rootCmd)
-}
-
-// For plugins, they can implement the Plugin interface or just Init
-
-func loadLuaPlugin(path string, rootCmd *Command) error {
-	L := lua.NewState()
-	defer L.Close()
-
-	// Expose functions to Lua
-	L.SetGlobal("add_command", L.NewFunction(func(L *lua.LState) int {
-		name := L.ToString(1)
-		short := L.ToString(2)
-		run := L.ToFunction(3)
-
-		cmd := &Command{
-			Use:   name,
-			Short: short,
-			RunE: func(cmd *Command, args []string) error {
-				L.Push(run)
-				for _, arg := range args {
-					L.Push(lua.LString(arg))
-				}
-				return L.PCall(len(args), 0, nil)
-			},
-		}
-		rootCmd.AddCommand(cmd)
-		return 0
-	}))
-
-	L.SetGlobal("find_command", L.NewFunction(func(L *lua.LState) int {
-		name := L.ToString(1)
-		cmd := findCommandByName(rootCmd, name)
-		if cmd != nil {
-			// Push command table or something, but for now, just name
-			L.Push(lua.LString(cmd.Use))
-		} else {
-			L.Push(lua.LNil)
-		}
-		return 1
-	}))
-
-	L.SetGlobal("add_middleware", L.NewFunction(func(L *lua.LState) int {
-		cmdName := L.ToString(1)
-		middleware := L.ToFunction(2)
-		cmd := findCommandByName(rootCmd, cmdName)
-		if cmd != nil {
-			cmd.Middlewares = append(cmd.Middlewares, func(c *Command, args []string) error {
-				L.Push(middleware)
-				for _, arg := range args {
-					L.Push(lua.LString(arg))
-				}
-				return L.PCall(len(args), 0, nil)
-			})
-		}
-		return 0
-	}))
-
-	L.SetGlobal("modify_command", L.NewFunction(func(L *lua.LState) int {
-		name := L.ToString(1)
-		newShort := L.ToString(2)
-		cmd := findCommandByName(rootCmd, name)
-		if cmd != nil {
-			cmd.Short = newShort
-			// Can add more modifications
-		}
-		return 0
-	}))
-
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return err
-	}
-
-	return L.DoString(string(content))
+	return nil, func() error { return initFunc(rootCmd) }, nil
 }
 
 func findCommandByName(root *Command, name string) *Command {
@@ -173,34 +210,6 @@ func findCommandByName(root *Command, name string) *Command {
 	return nil
 }
 
-func startPluginWatcher() {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return
-	}
-	defer watcher.Close()
-
-	pluginDir := filepath.Join(os.Getenv("HOME"), ".cobra", "plugins")
-	os.MkdirAll(pluginDir, 0755)
-	watcher.Add(pluginDir)
-
-	for {
-		select {
-		case event := <-watcher.Events:
-			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove) != 0 {
-				pluginMutex.Lock()
-				if globalRootCmd != nil {
-					// Clear existing plugin commands? Hard, for now reload all
-					LoadPlugins(globalRootCmd)
-				}
-				pluginMutex.Unlock()
-			}
-		case err := <-watcher.Errors:
-			fmt.Println("Plugin watcher error:", err)
-		}
-	}
-}
-
 func init() {
 	// Load plugins on package init, but since rootCmd not available, perhaps call in ExecuteC
 }
\ No newline at end of file
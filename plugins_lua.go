@@ -0,0 +1,691 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// EnableLuaSandbox removes os.execute, os.exit, os.getenv, os.setenv, the io library and
+// the functions loading code from Lua plugins unless their manifest requests them, see
+// PluginManifest.Permissions. The debug library, which could undo the sandbox, is always
+// removed.
+var EnableLuaSandbox = true
+
+// LuaPluginPoolSize is the number of idle Lua states each Lua plugin keeps for reuse.
+// Commands of a plugin running concurrently each get a state of their own.
+var LuaPluginPoolSize = 4
+
+// LuaPluginError is returned when a Lua plugin raises an error.
+type LuaPluginError struct {
+	// Plugin is the path of the plugin script.
+	Plugin string
+	// Message is the error raised by the script.
+	Message string
+	// StackTrace is the Lua stack trace of the error.
+	StackTrace string
+}
+
+func (e *LuaPluginError) Error() string {
+	if e.StackTrace == "" {
+		return fmt.Sprintf("%s: %s", e.Plugin, e.Message)
+	}
+	return fmt.Sprintf("%s: %s\n%s", e.Plugin, e.Message, e.StackTrace)
+}
+
+// luaPlugin exposes the command tree to a Lua script.
+//
+// The script can use the following globals:
+//
+//	add_command(spec)              -- or add_command(name, short, fn)
+//	find_command(name)             -- returns {name, use, short, long, example, path, aliases} or nil
+//	modify_command(name, spec)     -- or modify_command(name, short)
+//	add_middleware(name, fn)
+//
+// A command spec is a table with the fields use, short, long, example, aliases, hidden,
+// deprecated, parent, args, valid_args, flags, run and complete. Flags are tables with the
+// fields name, type (string, int, bool, float, strings or duration), shorthand, usage,
+// default, required, persistent, hidden and complete.
+//
+// run is called as run(cmd, args), where cmd holds name, path, args and the parsed flags,
+// as well as print and printerr functions writing to the command output. It fails the
+// command by returning an error message, and so do middlewares, called as fn(args...)
+// before the command they are added to and its subcommands run.
+// complete is called as complete(args, to_complete) and returns a list of completions.
+//
+// Lua states are not safe for concurrent use, so the plugin keeps a pool of them. The
+// script runs once on each state: on the first one it defines the commands, and on the
+// others it only recreates the functions those commands call, which must be defined in
+// the same order every time.
+type luaPlugin struct {
+	path     string
+	manifest *PluginManifest
+	root     *Command
+	proto    *lua.FunctionProto
+	// handlers is the number of functions the commands and middlewares of the plugin call.
+	handlers int
+
+	mu     sync.Mutex
+	idle   []*luaState
+	closed bool
+}
+
+// luaState is a Lua state which ran the plugin script.
+type luaState struct {
+	p *luaPlugin
+	L *lua.LState
+	// replay is set on the states created after the commands were defined.
+	replay bool
+	// handlers are the functions passed by the script, in the order they were passed in.
+	handlers []*lua.LFunction
+}
+
+func newLuaPlugin(path string, manifest *PluginManifest) *luaPlugin {
+	return &luaPlugin{path: path, manifest: manifest}
+}
+
+// loadLuaPlugin runs the Lua plugin at path, adding its commands to rootCmd.
+func loadLuaPlugin(path string, manifest *PluginManifest, rootCmd *Command) error {
+	p := newLuaPlugin(path, manifest)
+	if err := p.Init(rootCmd); err != nil {
+		return err
+	}
+	return p.AddCommands(rootCmd)
+}
+
+// Init compiles the script.
+func (p *luaPlugin) Init(rootCmd *Command) error {
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	chunk, err := parse.Parse(bytes.NewReader(content), p.path)
+	if err != nil {
+		return err
+	}
+	p.proto, err = lua.Compile(chunk, p.path)
+	if err != nil {
+		return err
+	}
+	p.root = rootCmd
+	return nil
+}
+
+// AddCommands runs the script, which defines the commands and middlewares of the plugin.
+func (p *luaPlugin) AddCommands(rootCmd *Command) error {
+	s, err := p.newState(false)
+	if err != nil {
+		return err
+	}
+	p.handlers = len(s.handlers)
+	p.put(s)
+	return nil
+}
+
+func (p *luaPlugin) ModifyCommands(rootCmd *Command) error { return nil }
+
+func (p *luaPlugin) AddMiddlewares(rootCmd *Command) error { return nil }
+
+// Shutdown closes the idle states. States in use are closed when they are given back.
+func (p *luaPlugin) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.idle {
+		s.L.Close()
+	}
+	p.idle = nil
+	p.closed = true
+	return nil
+}
+
+// newState creates a state and runs the script on it.
+func (p *luaPlugin) newState(replay bool) (*luaState, error) {
+	s := &luaState{p: p, L: lua.NewState(), replay: replay}
+	s.sandbox()
+	s.register()
+
+	s.L.Push(s.L.NewFunctionFromProto(p.proto))
+	if err := s.L.PCall(0, 0, nil); err != nil {
+		s.L.Close()
+		return nil, p.luaError(err)
+	}
+	if replay && len(s.handlers) != p.handlers {
+		s.L.Close()
+		return nil, fmt.Errorf("%s: the script passed %d functions instead of %d when run again", p.path, len(s.handlers), p.handlers)
+	}
+	return s, nil
+}
+
+// get takes an idle state from the pool, or creates one.
+func (p *luaPlugin) get() (*luaState, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		s := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return s, nil
+	}
+	p.mu.Unlock()
+	return p.newState(true)
+}
+
+// put gives s back to the pool, closing it if the pool is full.
+func (p *luaPlugin) put(s *luaState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= LuaPluginPoolSize {
+		s.L.Close()
+		return
+	}
+	p.idle = append(p.idle, s)
+}
+
+// call calls the handler at index on a state of the pool, under ctx. The arguments are
+// built by args on that state, and the value returned by the handler is passed to result
+// before the state is given back.
+func (p *luaPlugin) call(ctx context.Context, handler int, args func(L *lua.LState) []lua.LValue, result func(L *lua.LState, ret lua.LValue) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s, err := p.get()
+	if err != nil {
+		return err
+	}
+	L := s.L
+	top := L.GetTop()
+	L.SetContext(ctx)
+
+	L.Push(s.handlers[handler])
+	values := args(L)
+	for _, v := range values {
+		L.Push(v)
+	}
+	err = L.PCall(len(values), 1, nil)
+	if err == nil {
+		err = result(L, L.Get(-1))
+	} else {
+		err = p.luaError(err)
+	}
+	L.SetTop(top)
+	L.RemoveContext()
+
+	if ctx.Err() != nil {
+		// The script may have been interrupted anywhere, so the state is not reused.
+		L.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.path, ctx.Err())
+		}
+		return nil
+	}
+	p.put(s)
+	return err
+}
+
+// errorResult turns a value returned by a handler other than nil or false into an error.
+func errorResult(L *lua.LState, ret lua.LValue) error {
+	if lua.LVAsBool(ret) {
+		return errors.New(L.ToStringMeta(ret).String())
+	}
+	return nil
+}
+
+// luaError converts an error returned by the Lua runtime.
+func (p *luaPlugin) luaError(err error) error {
+	var apiErr *lua.ApiError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("%s: %v", p.path, err)
+	}
+	return &LuaPluginError{Plugin: p.path, Message: apiErr.Object.String(), StackTrace: apiErr.StackTrace}
+}
+
+// handler records fn, returning its index.
+func (s *luaState) handler(fn *lua.LFunction) int {
+	s.handlers = append(s.handlers, fn)
+	return len(s.handlers) - 1
+}
+
+// sandbox removes the functions the manifest does not grant access to.
+func (s *luaState) sandbox() {
+	if !EnableLuaSandbox {
+		return
+	}
+	L, manifest := s.L, s.p.manifest
+	osLib, _ := L.GetGlobal("os").(*lua.LTable)
+	loaded, _ := L.GetField(L.GetGlobal("package"), "loaded").(*lua.LTable)
+
+	L.SetGlobal("debug", lua.LNil)
+	if loaded != nil {
+		loaded.RawSetString("debug", lua.LNil)
+	}
+	if !manifest.HasPermission(PluginPermissionExec) && osLib != nil {
+		osLib.RawSetString("execute", lua.LNil)
+		osLib.RawSetString("exit", lua.LNil)
+	}
+	if !manifest.HasPermission(PluginPermissionEnv) && osLib != nil {
+		osLib.RawSetString("getenv", lua.LNil)
+		osLib.RawSetString("setenv", lua.LNil)
+	}
+	if !manifest.HasPermission(PluginPermissionIO) {
+		L.SetGlobal("io", lua.LNil)
+		if loaded != nil {
+			loaded.RawSetString("io", lua.LNil)
+		}
+		if osLib != nil {
+			for _, name := range []string{"remove", "rename", "tmpname"} {
+				osLib.RawSetString(name, lua.LNil)
+			}
+		}
+	}
+	if !manifest.HasPermission(PluginPermissionLoadFile) {
+		for _, name := range []string{"loadfile", "dofile", "load", "loadstring"} {
+			L.SetGlobal(name, lua.LNil)
+		}
+		// require searches package.loaders, which the script cannot replace as require reads
+		// the table from the registry. Keeping only the first one, which returns the modules of
+		// package.preload, leaves require unable to load files whatever package.path is.
+		if loaders, ok := L.GetField(L.Get(lua.RegistryIndex), "_LOADERS").(*lua.LTable); ok {
+			for i := loaders.Len(); i > 1; i-- {
+				loaders.RawSetInt(i, lua.LNil)
+			}
+		}
+	}
+}
+
+func (s *luaState) register() {
+	s.L.SetGlobal("add_command", s.L.NewFunction(s.luaAddCommand))
+	s.L.SetGlobal("find_command", s.L.NewFunction(s.luaFindCommand))
+	s.L.SetGlobal("modify_command", s.L.NewFunction(s.luaModifyCommand))
+	s.L.SetGlobal("add_middleware", s.L.NewFunction(s.luaAddMiddleware))
+}
+
+func (s *luaState) luaAddCommand(L *lua.LState) int {
+	spec, ok := L.Get(1).(*lua.LTable)
+	legacy := !ok
+	if legacy {
+		// add_command(name, short, fn) calls fn with the arguments spread as parameters.
+		spec = L.NewTable()
+		spec.RawSetString("use", lua.LString(L.CheckString(1)))
+		spec.RawSetString("short", lua.LString(L.OptString(2, "")))
+		spec.RawSetString("run", L.CheckFunction(3))
+	}
+
+	cmd, err := s.newCommand(spec, legacy)
+	if err != nil {
+		L.RaiseError("add_command: %v", err)
+		return 0
+	}
+	if s.replay {
+		return 0
+	}
+	root := s.p.root
+	parent := root
+	if name := lua.LVAsString(spec.RawGetString("parent")); name != "" {
+		if parent = findCommandByName(root, name); parent == nil {
+			L.RaiseError("add_command: parent command %q not found", name)
+			return 0
+		}
+	}
+	parent.AddCommand(cmd)
+	return 0
+}
+
+func (s *luaState) luaFindCommand(L *lua.LState) int {
+	cmd := findCommandByName(s.p.root, L.CheckString(1))
+	if cmd == nil {
+		L.Push(lua.LNil)
+		return 1
+	}
+	info := L.NewTable()
+	info.RawSetString("name", lua.LString(cmd.Name()))
+	info.RawSetString("use", lua.LString(cmd.Use))
+	info.RawSetString("short", lua.LString(cmd.Short))
+	info.RawSetString("long", lua.LString(cmd.Long))
+	info.RawSetString("example", lua.LString(cmd.Example))
+	info.RawSetString("path", lua.LString(cmd.CommandPath()))
+	info.RawSetString("aliases", stringList(L, cmd.Aliases))
+	L.Push(info)
+	return 1
+}
+
+func (s *luaState) luaModifyCommand(L *lua.LState) int {
+	name := L.CheckString(1)
+	cmd := findCommandByName(s.p.root, name)
+	if cmd == nil || s.replay {
+		return 0
+	}
+	spec, ok := L.Get(2).(*lua.LTable)
+	if !ok {
+		cmd.Short = L.CheckString(2)
+		return 0
+	}
+	spec.ForEach(func(key, value lua.LValue) {
+		switch lua.LVAsString(key) {
+		case "short":
+			cmd.Short = lua.LVAsString(value)
+		case "long":
+			cmd.Long = lua.LVAsString(value)
+		case "example":
+			cmd.Example = lua.LVAsString(value)
+		case "aliases":
+			cmd.Aliases = luaStrings(value)
+		case "hidden":
+			cmd.Hidden = lua.LVAsBool(value)
+		case "deprecated":
+			cmd.Deprecated = lua.LVAsString(value)
+		default:
+			L.RaiseError("modify_command: unsupported field %q", lua.LVAsString(key))
+		}
+	})
+	return 0
+}
+
+func (s *luaState) luaAddMiddleware(L *lua.LState) int {
+	cmdName := L.CheckString(1)
+	handler := s.handler(L.CheckFunction(2))
+	cmd := findCommandByName(s.p.root, cmdName)
+	if cmd == nil {
+		L.RaiseError("add_middleware: command %q not found", cmdName)
+		return 0
+	}
+	if !s.replay {
+		p := s.p
+		cmd.Middlewares = append(cmd.Middlewares, func(c *Command, args []string) error {
+			return p.call(c.Context(), handler, func(L *lua.LState) []lua.LValue {
+				return stringValues(args)
+			}, errorResult)
+		})
+	}
+	return 0
+}
+
+// newCommand builds a command from a Lua spec table.
+func (s *luaState) newCommand(spec *lua.LTable, legacy bool) (*Command, error) {
+	use := lua.LVAsString(spec.RawGetString("use"))
+	if use == "" {
+		return nil, fmt.Errorf("use is required")
+	}
+	cmd := &Command{
+		Use:        use,
+		Short:      lua.LVAsString(spec.RawGetString("short")),
+		Long:       lua.LVAsString(spec.RawGetString("long")),
+		Example:    lua.LVAsString(spec.RawGetString("example")),
+		Aliases:    luaStrings(spec.RawGetString("aliases")),
+		Hidden:     lua.LVAsBool(spec.RawGetString("hidden")),
+		Deprecated: lua.LVAsString(spec.RawGetString("deprecated")),
+		ValidArgs:  luaStrings(spec.RawGetString("valid_args")),
+	}
+
+	args, err := luaPositionalArgs(spec.RawGetString("args"))
+	if err != nil {
+		return nil, err
+	}
+	cmd.Args = args
+
+	if flags, ok := spec.RawGetString("flags").(*lua.LTable); ok {
+		for i := 1; i <= flags.Len(); i++ {
+			flagSpec, ok := flags.RawGetInt(i).(*lua.LTable)
+			if !ok {
+				return nil, fmt.Errorf("flag %d must be a table", i)
+			}
+			if err := s.defineFlag(cmd, flagSpec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	switch complete := spec.RawGetString("complete").(type) {
+	case *lua.LFunction:
+		cmd.ValidArgsFunction = s.completionFunc(complete)
+	case *lua.LNilType:
+	default:
+		return nil, fmt.Errorf("complete must be a function")
+	}
+
+	switch run := spec.RawGetString("run").(type) {
+	case *lua.LFunction:
+		p, handler := s.p, s.handler(run)
+		cmd.RunE = func(c *Command, args []string) error {
+			return p.call(c.Context(), handler, func(L *lua.LState) []lua.LValue {
+				if legacy {
+					return stringValues(args)
+				}
+				return []lua.LValue{commandTable(L, c, args), stringList(L, args)}
+			}, errorResult)
+		}
+	case *lua.LNilType:
+	default:
+		return nil, fmt.Errorf("run must be a function")
+	}
+	return cmd, nil
+}
+
+// luaPositionalArgs converts the args field of a spec: "none", "any", "only_valid",
+// a number of exact arguments, or a table with exact, min and max.
+func luaPositionalArgs(v lua.LValue) (PositionalArgs, error) {
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LNumber:
+		return ExactArgs(int(v)), nil
+	case lua.LString:
+		switch string(v) {
+		case "none":
+			return NoArgs, nil
+		case "any":
+			return ArbitraryArgs, nil
+		case "only_valid":
+			return OnlyValidArgs, nil
+		}
+	case *lua.LTable:
+		exact, min, max := v.RawGetString("exact"), v.RawGetString("min"), v.RawGetString("max")
+		switch {
+		case exact != lua.LNil:
+			return ExactArgs(int(lua.LVAsNumber(exact))), nil
+		case min != lua.LNil && max != lua.LNil:
+			return RangeArgs(int(lua.LVAsNumber(min)), int(lua.LVAsNumber(max))), nil
+		case min != lua.LNil:
+			return MinimumNArgs(int(lua.LVAsNumber(min))), nil
+		case max != lua.LNil:
+			return MaximumNArgs(int(lua.LVAsNumber(max))), nil
+		}
+	}
+	return nil, fmt.Errorf("invalid args %s", v.String())
+}
+
+func (s *luaState) defineFlag(cmd *Command, spec *lua.LTable) error {
+	name := lua.LVAsString(spec.RawGetString("name"))
+	if name == "" {
+		return fmt.Errorf("flag name is required")
+	}
+	shorthand := lua.LVAsString(spec.RawGetString("shorthand"))
+	usage := lua.LVAsString(spec.RawGetString("usage"))
+	def := spec.RawGetString("default")
+	persistent := lua.LVAsBool(spec.RawGetString("persistent"))
+
+	fs := cmd.Flags()
+	if persistent {
+		fs = cmd.PersistentFlags()
+	}
+	switch typ := lua.LVAsString(spec.RawGetString("type")); typ {
+	case "", "string":
+		fs.StringP(name, shorthand, lua.LVAsString(def), usage)
+	case "int":
+		fs.IntP(name, shorthand, int(lua.LVAsNumber(def)), usage)
+	case "bool":
+		fs.BoolP(name, shorthand, lua.LVAsBool(def), usage)
+	case "float":
+		fs.Float64P(name, shorthand, float64(lua.LVAsNumber(def)), usage)
+	case "strings":
+		fs.StringSliceP(name, shorthand, luaStrings(def), usage)
+	case "duration":
+		var d time.Duration
+		switch def := def.(type) {
+		case lua.LNumber:
+			d = time.Duration(float64(def) * float64(time.Second))
+		case lua.LString:
+			var err error
+			if d, err = time.ParseDuration(string(def)); err != nil {
+				return fmt.Errorf("flag %s: invalid default: %v", name, err)
+			}
+		}
+		fs.DurationP(name, shorthand, d, usage)
+	default:
+		return fmt.Errorf("flag %s: unsupported type %q", name, typ)
+	}
+
+	if lua.LVAsBool(spec.RawGetString("hidden")) {
+		_ = fs.MarkHidden(name)
+	}
+	if lua.LVAsBool(spec.RawGetString("required")) {
+		if persistent {
+			_ = cmd.MarkPersistentFlagRequired(name)
+		} else {
+			_ = cmd.MarkFlagRequired(name)
+		}
+	}
+	switch complete := spec.RawGetString("complete").(type) {
+	case *lua.LFunction:
+		completion := s.completionFunc(complete)
+		if s.replay {
+			return nil
+		}
+		return cmd.RegisterFlagCompletionFunc(name, completion)
+	case *lua.LTable:
+		if s.replay {
+			return nil
+		}
+		return cmd.RegisterFlagCompletionFunc(name, FixedCompletions(luaStrings(complete), ShellCompDirectiveNoFileComp))
+	}
+	return nil
+}
+
+func (s *luaState) completionFunc(fn *lua.LFunction) CompletionFunc {
+	p, handler := s.p, s.handler(fn)
+	return func(cmd *Command, args []string, toComplete string) ([]Completion, ShellCompDirective) {
+		var comps []Completion
+		err := p.call(cmd.Context(), handler, func(L *lua.LState) []lua.LValue {
+			return []lua.LValue{stringList(L, args), lua.LString(toComplete)}
+		}, func(L *lua.LState, ret lua.LValue) error {
+			comps = luaStrings(ret)
+			return nil
+		})
+		if err != nil {
+			CompDebugln(fmt.Sprintf("Lua completion failed: %v", err), true)
+			return nil, ShellCompDirectiveError
+		}
+		return comps, ShellCompDirectiveNoFileComp
+	}
+}
+
+// commandTable describes an executing command to a run function.
+func commandTable(L *lua.LState, cmd *Command, args []string) *lua.LTable {
+	tbl := L.NewTable()
+	tbl.RawSetString("name", lua.LString(cmd.Name()))
+	tbl.RawSetString("path", lua.LString(cmd.CommandPath()))
+	tbl.RawSetString("args", stringList(L, args))
+
+	flags := L.NewTable()
+	cmd.Flags().VisitAll(func(f *flag.Flag) {
+		flags.RawSetString(f.Name, luaFlagValue(L, f))
+	})
+	tbl.RawSetString("flags", flags)
+
+	printer := func(toErr bool) *lua.LFunction {
+		return L.NewFunction(func(L *lua.LState) int {
+			var parts []string
+			for i := 1; i <= L.GetTop(); i++ {
+				if i == 1 && L.Get(i) == tbl {
+					// Called as cmd:print(...)
+					continue
+				}
+				parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+			}
+			if toErr {
+				cmd.PrintErrln(strings.Join(parts, "\t"))
+			} else {
+				cmd.Println(strings.Join(parts, "\t"))
+			}
+			return 0
+		})
+	}
+	tbl.RawSetString("print", printer(false))
+	tbl.RawSetString("printerr", printer(true))
+	return tbl
+}
+
+func luaFlagValue(L *lua.LState, f *flag.Flag) lua.LValue {
+	if slice, ok := f.Value.(flag.SliceValue); ok {
+		values := L.NewTable()
+		for _, v := range slice.GetSlice() {
+			values.Append(lua.LString(v))
+		}
+		return values
+	}
+	switch f.Value.Type() {
+	case "bool":
+		b, _ := strconv.ParseBool(f.Value.String())
+		return lua.LBool(b)
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64", "count":
+		n, _ := strconv.ParseFloat(f.Value.String(), 64)
+		return lua.LNumber(n)
+	case "duration":
+		d, _ := time.ParseDuration(f.Value.String())
+		return lua.LNumber(d.Seconds())
+	}
+	return lua.LString(f.Value.String())
+}
+
+func stringValues(values []string) []lua.LValue {
+	lvs := make([]lua.LValue, 0, len(values))
+	for _, v := range values {
+		lvs = append(lvs, lua.LString(v))
+	}
+	return lvs
+}
+
+func stringList(L *lua.LState, values []string) *lua.LTable {
+	tbl := L.NewTable()
+	for _, v := range values {
+		tbl.Append(lua.LString(v))
+	}
+	return tbl
+}
+
+// luaStrings converts a Lua list, or a single value, to a slice of strings.
+func luaStrings(v lua.LValue) []string {
+	switch v := v.(type) {
+	case *lua.LTable:
+		values := make([]string, 0, v.Len())
+		for i := 1; i <= v.Len(); i++ {
+			values = append(values, lua.LVAsString(v.RawGetInt(i)))
+		}
+		return values
+	case lua.LString, lua.LNumber:
+		return []string{lua.LVAsString(v)}
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func writeLuaPlugin(t *testing.T, script, manifest string) (string, *PluginManifest) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.lua")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &PluginManifest{}
+	if err := yaml.Unmarshal([]byte(manifest), m); err != nil {
+		t.Fatal(err)
+	}
+	return path, m
+}
+
+func TestLuaPluginCommandModel(t *testing.T) {
+	path, manifest := writeLuaPlugin(t, `
+add_command{
+	use = "greet <name>",
+	short = "Greet someone",
+	aliases = {"hello"},
+	args = {exact = 1},
+	flags = {
+		{name = "times", type = "int", shorthand = "n", default = 1, usage = "repeat count"},
+		{name = "shout", type = "bool"},
+		{name = "lang", complete = {"en", "fr"}},
+	},
+	complete = function(args, to_complete) return {"alice", "bob"} end,
+	run = function(cmd, args)
+		local name = args[1]
+		if cmd.flags.shout then name = string.upper(name) end
+		for i = 1, cmd.flags.times do cmd:print("hello " .. name) end
+		if name == "nobody" then return "nobody to greet" end
+	end,
+}
+modify_command("greet", {long = "Greets someone by name."})
+`, "")
+	rootCmd := &Command{Use: "root"}
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+
+	greet, _, err := rootCmd.Find([]string{"hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if greet.Long != "Greets someone by name." {
+		t.Errorf("expected modified long description, got %q", greet.Long)
+	}
+	if err := greet.ValidateArgs(nil); err == nil {
+		t.Errorf("expected exact args to be enforced")
+	}
+
+	var out bytes.Buffer
+	greet.SetOut(&out)
+	if err := greet.ParseFlags([]string{"-n", "2", "--shout"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := greet.RunE(greet, []string{"bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello BOB\nhello BOB\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	greet.Flags().Set("shout", "false")
+	if err := greet.RunE(greet, []string{"nobody"}); err == nil || err.Error() != "nobody to greet" {
+		t.Errorf("expected returned message to become an error, got %v", err)
+	}
+
+	comps, _ := greet.ValidArgsFunction(greet, nil, "")
+	if !reflect.DeepEqual(comps, []Completion{"alice", "bob"}) {
+		t.Errorf("unexpected completions %v", comps)
+	}
+}
+
+func TestLuaPluginLegacyAddCommand(t *testing.T) {
+	path, manifest := writeLuaPlugin(t, `
+add_command("legacy", "Legacy command", function(a, b) seen = a .. b end)
+`, "")
+	rootCmd := &Command{Use: "root"}
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	legacy, _, err := rootCmd.Find([]string{"legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.RunE(legacy, []string{"x", "y"}); err != nil {
+		t.Errorf("expected legacy command to run on an open state, got %v", err)
+	}
+}
+
+func TestLuaPluginSandbox(t *testing.T) {
+	script := `
+add_command{use = "check", run = function(cmd, args)
+	if os.execute ~= nil then return "os.execute available" end
+	if io ~= nil then return "io available" end
+	if loadfile ~= nil then return "loadfile available" end
+	for name, value in pairs{
+		["os.exit"] = os.exit, ["os.getenv"] = os.getenv, ["os.setenv"] = os.setenv,
+		load = load, loadstring = loadstring, debug = debug, ["package.loaded.debug"] = package.loaded.debug,
+	} do
+		if value ~= nil then return name .. " available" end
+	end
+end}
+`
+	rootCmd := &Command{Use: "root"}
+	path, manifest := writeLuaPlugin(t, script, "")
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	check, _, _ := rootCmd.Find([]string{"check"})
+	if err := check.RunE(check, nil); err != nil {
+		t.Errorf("expected sandbox to remove dangerous functions, got %v", err)
+	}
+
+	rootCmd = &Command{Use: "root"}
+	path, manifest = writeLuaPlugin(t, script, "permissions: [io]\n")
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	check, _, _ = rootCmd.Find([]string{"check"})
+	if err := check.RunE(check, nil); err == nil || err.Error() != "io available" {
+		t.Errorf("expected io to be granted by the manifest, got %v", err)
+	}
+
+	rootCmd = &Command{Use: "root"}
+	path, manifest = writeLuaPlugin(t, `
+add_command{use = "check", run = function(cmd, args)
+	if os.getenv == nil or os.exit == nil then return "env or exec not granted" end
+	if debug ~= nil then return "debug available" end
+end}
+`, "permissions: [env, exec]\n")
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	check, _, _ = rootCmd.Find([]string{"check"})
+	if err := check.RunE(check, nil); err != nil {
+		t.Errorf("expected env and exec to be granted by the manifest without debug, got %v", err)
+	}
+}
+
+func TestLuaPluginSandboxRequire(t *testing.T) {
+	modules := t.TempDir()
+	if err := os.WriteFile(filepath.Join(modules, "evil.lua"), []byte(`return "loaded"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf(`
+add_command{use = "check", run = function(cmd, args)
+	package.path = %q
+	local ok, module = pcall(require, "evil")
+	if ok then return "required " .. module end
+end}
+`, filepath.Join(modules, "?.lua"))
+
+	rootCmd := &Command{Use: "root"}
+	path, manifest := writeLuaPlugin(t, script, "")
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	check, _, _ := rootCmd.Find([]string{"check"})
+	if err := check.RunE(check, nil); err != nil {
+		t.Errorf("expected require to be unable to load files, got %v", err)
+	}
+
+	rootCmd = &Command{Use: "root"}
+	path, manifest = writeLuaPlugin(t, script, "permissions: [loadfile]\n")
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	check, _, _ = rootCmd.Find([]string{"check"})
+	if err := check.RunE(check, nil); err == nil || err.Error() != "required loaded" {
+		t.Errorf("expected require to load files with the loadfile permission, got %v", err)
+	}
+}
+
+func TestLuaPluginConcurrentRuns(t *testing.T) {
+	path, manifest := writeLuaPlugin(t, `
+add_command{use = "sum", args = {exact = 1}, run = function(cmd, args)
+	total = 0
+	for i = 1, tonumber(args[1]) do total = total + i end
+	cmd:print(total)
+end}
+add_middleware("sum", function(n)
+	if n == "0" then return "nothing to sum" end
+end)
+`, "")
+	rootCmd := &Command{Use: "root"}
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	sum, _, _ := rootCmd.Find([]string{"sum"})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			// Each run gets a command of its own to write to; the Lua handlers are shared.
+			cmd := &Command{Use: "sum"}
+			var out bytes.Buffer
+			cmd.SetOut(&out)
+			for _, m := range sum.Middlewares {
+				if err := m(cmd, []string{fmt.Sprint(n * 1000)}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			if err := sum.RunE(cmd, []string{fmt.Sprint(n * 1000)}); err != nil {
+				t.Error(err)
+				return
+			}
+			if want := fmt.Sprintln(n * 1000 * (n*1000 + 1) / 2); out.String() != want {
+				t.Errorf("expected %q, got %q", want, out.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := sum.Middlewares[0](sum, []string{"0"}); err == nil || err.Error() != "nothing to sum" {
+		t.Errorf("expected middleware to fail with its returned message, got %v", err)
+	}
+}
+
+func TestLuaPluginMiddlewares(t *testing.T) {
+	path, manifest := writeLuaPlugin(t, `
+add_command{use = "deploy"}
+add_command{use = "now", parent = "deploy", run = function(cmd, args) cmd:print("deployed") end}
+add_command{use = "other", run = function(cmd, args) end}
+add_middleware("deploy", function(env)
+	if env == "prod" then return "blocked " .. env end
+end)
+`, "")
+	rootCmd := &Command{Use: "root"}
+	var log []string
+	rootCmd.Middlewares = append(rootCmd.Middlewares, func(cmd *Command, args []string) error {
+		log = append(log, cmd.Name())
+		return nil
+	})
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := executeCommand(rootCmd, "deploy", "now", "prod"); err == nil || err.Error() != "blocked prod" {
+		t.Errorf("expected the middleware of the parent to block the subcommand, got %v", err)
+	}
+	if output, err := executeCommand(rootCmd, "deploy", "now", "dev"); err != nil || output != "deployed\n" {
+		t.Errorf("expected the subcommand to run, got %q and %v", output, err)
+	}
+	if _, err := executeCommand(rootCmd, "other", "prod"); err != nil {
+		t.Errorf("expected the middleware not to apply to other commands, got %v", err)
+	}
+	if !reflect.DeepEqual(log, []string{"now", "now", "other"}) {
+		t.Errorf("expected the root middleware to run first for every command, got %q", log)
+	}
+
+	path, manifest = writeLuaPlugin(t, `add_middleware("missing", function() end)`, "")
+	if err := loadLuaPlugin(path, manifest, &Command{Use: "root"}); err == nil || !strings.Contains(err.Error(), `command "missing" not found`) {
+		t.Errorf("expected a middleware for a missing command to fail, got %v", err)
+	}
+}
+
+func TestLuaPluginErrorStackTrace(t *testing.T) {
+	path, manifest := writeLuaPlugin(t, `
+local function fail(what)
+	error("cannot " .. what)
+end
+add_command{use = "fail", run = function(cmd, args) fail("fail") end}
+`, "")
+	rootCmd := &Command{Use: "root"}
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	fail, _, _ := rootCmd.Find([]string{"fail"})
+
+	for i := 0; i < 2; i++ {
+		err := fail.RunE(fail, nil)
+		var luaErr *LuaPluginError
+		if !errors.As(err, &luaErr) {
+			t.Fatalf("expected a LuaPluginError, got %v", err)
+		}
+		if luaErr.Plugin != path || !strings.Contains(luaErr.Message, "cannot fail") {
+			t.Errorf("unexpected error %+v", luaErr)
+		}
+		if !strings.Contains(luaErr.StackTrace, "stack traceback") || !strings.Contains(luaErr.StackTrace, "test.lua:3") {
+			t.Errorf("expected stack trace to point at the error, got %q", luaErr.StackTrace)
+		}
+	}
+}
+
+func TestLuaPluginContextTimeout(t *testing.T) {
+	path, manifest := writeLuaPlugin(t, `
+add_command{use = "spin", run = function(cmd, args)
+	if args[1] == "forever" then while true do end end
+	cmd:print("done")
+end}
+`, "")
+	rootCmd := &Command{Use: "root"}
+	if err := loadLuaPlugin(path, manifest, rootCmd); err != nil {
+		t.Fatal(err)
+	}
+	spin, _, _ := rootCmd.Find([]string{"spin"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	spin.SetContext(ctx)
+	if err := spin.RunE(spin, []string{"forever"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the run to stop at the deadline, got %v", err)
+	}
+
+	var out bytes.Buffer
+	spin.SetOut(&out)
+	spin.SetContext(context.Background())
+	if err := spin.RunE(spin, nil); err != nil || out.String() != "done\n" {
+		t.Errorf("expected the plugin to keep working after a timeout, got %v, %q", err, out.String())
+	}
+}
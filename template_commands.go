@@ -0,0 +1,195 @@
+// Saved templates as subcommands
+package cobra
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TemplateOptions controls how saved templates are exposed on a root command.
+type TemplateOptions struct {
+	// RegisterCommands adds every saved template as a subcommand of the root command when it is executed.
+	// Templates whose name is already used by another command are not registered.
+	RegisterCommands bool
+	// GroupID places the template commands in a help group. The group is created with
+	// GroupTitle if the root command does not define it.
+	GroupID    string
+	GroupTitle string
+}
+
+const (
+	// templateCommandAnnotation marks commands generated from templates, its value is the template source.
+	templateCommandAnnotation = "cobra_annotation_template_command"
+
+	defaultTemplateGroupTitle = "Template Commands:"
+)
+
+// registerTemplateCommands replaces the template commands of c with one command per effective template.
+func (c *Command) registerTemplateCommands(tm *TemplateManager) error {
+	for _, sub := range c.Commands() {
+		if _, ok := sub.Annotations[templateCommandAnnotation]; ok {
+			c.RemoveCommand(sub)
+		}
+	}
+
+	entries, err := tm.Entries()
+	if err != nil {
+		return err
+	}
+
+	opts := c.TemplateOptions
+	if opts.GroupID != "" && !c.ContainsGroup(opts.GroupID) {
+		title := opts.GroupTitle
+		if title == "" {
+			title = defaultTemplateGroupTitle
+		}
+		c.AddGroup(&Group{ID: opts.GroupID, Title: title})
+	}
+
+	for _, entry := range entries {
+		if entry.ShadowedBy != "" || entry.Template.Validate() != nil {
+			continue
+		}
+		if c.hasSubCommandNamed(entry.Name) {
+			continue
+		}
+		cmd := newTemplateCommand(entry)
+		cmd.GroupID = opts.GroupID
+		c.addBuiltinCommands(cmd)
+	}
+	return nil
+}
+
+func (c *Command) hasSubCommandNamed(name string) bool {
+	for _, sub := range c.commands {
+		if sub.Name() == name || sub.HasAlias(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTemplateCommand builds a command running the template of entry, with a flag per parameter.
+func newTemplateCommand(entry TemplateEntry) *Command {
+	t := entry.Template
+	short := t.Description
+	if short == "" {
+		short = fmt.Sprintf("Run the %s template", entry.Name)
+	}
+
+	var dryRun bool
+	cmd := &Command{
+		Use:               entry.Name,
+		Short:             short,
+		Long:              fmt.Sprintf("%s\n\nDefined in the %s templates %s.", short, entry.Source, entry.File),
+		Args:              NoArgs,
+		ValidArgsFunction: NoFileCompletions,
+		Annotations:       map[string]string{templateCommandAnnotation: string(entry.Source)},
+		RunE: func(cmd *Command, args []string) error {
+			values := make(map[string]string, len(t.Params))
+			for _, p := range t.Params {
+				if f := cmd.Flags().Lookup(p.Name); f != nil && f.Changed {
+					values[p.Name] = f.Value.String()
+				}
+			}
+			return runTemplate(cmd, t, values, dryRun)
+		},
+	}
+	for _, p := range t.Params {
+		cmd.Flags().String(p.Name, p.Default, p.Description)
+		if p.Required && p.Default == "" {
+			_ = cmd.MarkFlagRequired(p.Name)
+		}
+		if len(p.Values) > 0 {
+			_ = cmd.RegisterFlagCompletionFunc(p.Name, FixedCompletions(p.Values, ShellCompDirectiveNoFileComp))
+		}
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the resolved steps without executing them")
+	return cmd
+}
+
+// runTemplate renders t with the parameter values and runs it with the output of cmd.
+func runTemplate(cmd *Command, t Template, values map[string]string, dryRun bool) error {
+	rendered, err := t.Render(values)
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	runner := &TemplateRunner{
+		Stdout: cmd.OutOrStdout(),
+		Stderr: cmd.ErrOrStderr(),
+		DryRun: dryRun,
+	}
+	_, err = runner.Run(ctx, rendered)
+	return err
+}
+
+// completeTemplateNames completes the first argument with the names of the effective templates.
+func completeTemplateNames(tm *TemplateManager) CompletionFunc {
+	return func(cmd *Command, args []string, toComplete string) ([]Completion, ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, ShellCompDirectiveNoFileComp
+		}
+		templates, err := tm.Load()
+		if err != nil {
+			return nil, ShellCompDirectiveError
+		}
+		var comps []Completion
+		for name, t := range templates {
+			if strings.HasPrefix(name, toComplete) {
+				comps = append(comps, CompletionWithDesc(name, t.String()))
+			}
+		}
+		sort.Strings(comps)
+		return comps, ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeTemplateParams completes "name=value" pairs for the parameters of the template named by the first argument.
+func completeTemplateParams(tm *TemplateManager) CompletionFunc {
+	return func(cmd *Command, args []string, toComplete string) ([]Completion, ShellCompDirective) {
+		if len(args) == 0 {
+			return nil, ShellCompDirectiveNoFileComp
+		}
+		t, err := tm.GetTemplate(args[0])
+		if err != nil {
+			return nil, ShellCompDirectiveError
+		}
+		var comps []Completion
+		if name, prefix, found := strings.Cut(toComplete, "="); found {
+			for _, p := range t.Params {
+				if p.Name != name {
+					continue
+				}
+				for _, v := range p.Values {
+					if strings.HasPrefix(v, prefix) {
+						comps = append(comps, name+"="+v)
+					}
+				}
+			}
+			return comps, ShellCompDirectiveNoFileComp
+		}
+		for _, p := range t.Params {
+			if !strings.HasPrefix(p.Name, toComplete) {
+				continue
+			}
+			desc := p.Description
+			if p.Default != "" && desc != "" {
+				desc += ", defaults to " + p.Default
+			} else if p.Default != "" {
+				desc = "defaults to " + p.Default
+			}
+			if desc == "" {
+				comps = append(comps, p.Name+"=")
+			} else {
+				comps = append(comps, CompletionWithDesc(p.Name+"=", desc))
+			}
+		}
+		return comps, ShellCompDirectiveNoFileComp | ShellCompDirectiveNoSpace
+	}
+}
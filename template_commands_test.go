@@ -0,0 +1,130 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTemplateCommandsManager(t *testing.T) *TemplateManager {
+	dir := t.TempDir()
+	tm := newTemplateManager("", filepath.Join(dir, "user.json"), dir)
+	deploy := Template{
+		Description: "Deploy the application",
+		Params: []TemplateParam{
+			{Name: "env", Values: []string{"staging", "production"}, Required: true},
+			{Name: "tag", Default: "latest"},
+		},
+		Steps: []TemplateStep{{Run: "echo deploying {{.tag}} to {{.env}}"}},
+	}
+	if err := tm.SaveTemplate(TemplateSourceUser, "deploy", deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Add("child", "echo shadowed by a real command"); err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func TestRegisterTemplateCommands(t *testing.T) {
+	tm := newTemplateCommandsManager(t)
+	rootCmd := &Command{Use: "root", TemplateOptions: TemplateOptions{RegisterCommands: true, GroupID: "templates"}}
+	childCmd := &Command{Use: "child", Run: emptyRun}
+	rootCmd.AddCommand(childCmd)
+
+	if err := rootCmd.registerTemplateCommands(tm); err != nil {
+		t.Fatal(err)
+	}
+	// Registering again replaces the previous template commands.
+	if err := rootCmd.registerTemplateCommands(tm); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, sub := range rootCmd.Commands() {
+		names = append(names, sub.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"child", "deploy"}) {
+		t.Fatalf("expected child and deploy commands, got %v", names)
+	}
+	if !rootCmd.ContainsGroup("templates") {
+		t.Errorf("expected the templates group to be created")
+	}
+
+	deployCmd, _, err := rootCmd.Find([]string{"deploy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deployCmd.Short != "Deploy the application" || deployCmd.GroupID != "templates" {
+		t.Errorf("unexpected deploy command %q in group %q", deployCmd.Short, deployCmd.GroupID)
+	}
+
+	var out bytes.Buffer
+	deployCmd.SetOut(&out)
+	deployCmd.Flags().Set("env", "staging")
+	if err := deployCmd.RunE(deployCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "deploying latest to staging\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestTemplateCompletions(t *testing.T) {
+	tm := newTemplateCommandsManager(t)
+	cmd := &Command{Use: "run"}
+
+	comps, _ := completeTemplateNames(tm)(cmd, nil, "de")
+	if !reflect.DeepEqual(comps, []Completion{"deploy\tDeploy the application (echo deploying {{.tag}} to {{.env}})"}) {
+		t.Errorf("unexpected name completions %q", comps)
+	}
+
+	comps, _ = completeTemplateParams(tm)(cmd, []string{"deploy"}, "")
+	if !reflect.DeepEqual(comps, []Completion{"env=", "tag=\tdefaults to latest"}) {
+		t.Errorf("unexpected parameter completions %q", comps)
+	}
+
+	comps, _ = completeTemplateParams(tm)(cmd, []string{"deploy"}, "env=p")
+	if !reflect.DeepEqual(comps, []Completion{"env=production"}) {
+		t.Errorf("unexpected value completions %q", comps)
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	tm := newTemplateCommandsManager(t)
+	deploy, err := tm.GetTemplate("deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := deploy.Render(nil); err == nil {
+		t.Errorf("expected missing required parameter to fail")
+	}
+	if _, err := deploy.Render(map[string]string{"env": "dev"}); err == nil {
+		t.Errorf("expected value outside of the allowed values to fail")
+	}
+	if _, err := deploy.Render(map[string]string{"env": "staging", "region": "eu"}); err == nil {
+		t.Errorf("expected unknown parameter to fail")
+	}
+
+	legacy := ParseTemplateCommand("docker ps --format '{{.Names}}'")
+	rendered, err := legacy.Render(nil)
+	if err != nil || rendered.Steps[0].Run != legacy.Steps[0].Run {
+		t.Errorf("expected templates without parameters to be left untouched, got %q, %v", rendered.Steps[0].Run, err)
+	}
+}
@@ -0,0 +1,453 @@
+// Structured command templates
+package cobra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Template is a named, ordered list of shell steps.
+//
+// In a store a template is either a mapping with steps or, as in earlier versions,
+// a single string of commands separated by "&&" which runs each command as a step
+// and stops at the first failure.
+type Template struct {
+	Description string          `json:"description,omitempty" yaml:"description,omitempty"`
+	Params      []TemplateParam `json:"params,omitempty" yaml:"params,omitempty"`
+	Steps       []TemplateStep  `json:"steps" yaml:"steps"`
+}
+
+// TemplateParam is a value supplied when a template is run.
+//
+// Templates that declare parameters render the Run field of every step with text/template,
+// so a parameter named "env" is referenced as {{.env}}.
+type TemplateParam struct {
+	// Name must be a valid Go identifier; it is also used as the flag name.
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Default     string `json:"default,omitempty" yaml:"default,omitempty"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	// Values restricts the parameter to a fixed set, which is also offered for shell completion.
+	Values []string `json:"values,omitempty" yaml:"values,omitempty"`
+}
+
+// TemplateStep is a single shell command of a template.
+type TemplateStep struct {
+	// Name identifies the step in conditions and output. Defaults to "step-<n>".
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Run is the command executed with "sh -c".
+	Run string `json:"run" yaml:"run"`
+	// ContinueOnError keeps running the next steps when this step fails.
+	ContinueOnError bool `json:"continue_on_error,omitempty" yaml:"continue_on_error,omitempty"`
+	// Retries is the number of additional attempts made when the step fails.
+	Retries int `json:"retries,omitempty" yaml:"retries,omitempty"`
+	// Backoff is the delay before the first retry, doubled on every following retry. Defaults to 1s.
+	Backoff string `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+	// Timeout bounds every attempt of the step, e.g. "30s".
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// When makes the step conditional on the result of a prior step.
+	When *StepCondition `json:"when,omitempty" yaml:"when,omitempty"`
+}
+
+// StepCondition is evaluated against the result of a prior step. All set fields must match.
+// A condition on a step that was skipped or has not run is false.
+type StepCondition struct {
+	// Step is the name of the prior step. Defaults to the step immediately before.
+	Step           string `json:"step,omitempty" yaml:"step,omitempty"`
+	ExitCode       *int   `json:"exit_code,omitempty" yaml:"exit_code,omitempty"`
+	Succeeded      *bool  `json:"succeeded,omitempty" yaml:"succeeded,omitempty"`
+	OutputContains string `json:"output_contains,omitempty" yaml:"output_contains,omitempty"`
+	OutputMatches  string `json:"output_matches,omitempty" yaml:"output_matches,omitempty"`
+}
+
+// StepResult records the outcome of running a template step.
+type StepResult struct {
+	Name     string
+	Skipped  bool
+	ExitCode int
+	Output   string
+	Attempts int
+	Duration time.Duration
+	Err      error
+}
+
+const defaultStepBackoff = time.Second
+
+// ParseTemplateCommand converts a "&&" separated command line into a template.
+func ParseTemplateCommand(command string) Template {
+	var t Template
+	for _, part := range strings.Split(command, "&&") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t.Steps = append(t.Steps, TemplateStep{Run: part})
+	}
+	return t
+}
+
+// UnmarshalJSON accepts both the structured form and a legacy command string.
+func (t *Template) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*t = ParseTemplateCommand(command)
+		return nil
+	}
+	type plain Template
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// UnmarshalYAML accepts both the structured form and a legacy command string.
+func (t *Template) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*t = ParseTemplateCommand(value.Value)
+		return nil
+	}
+	type plain Template
+	return value.Decode((*plain)(t))
+}
+
+// String returns a one line summary of the template.
+func (t Template) String() string {
+	runs := make([]string, 0, len(t.Steps))
+	for _, step := range t.Steps {
+		runs = append(runs, step.Run)
+	}
+	summary := strings.Join(runs, " && ")
+	if t.Description != "" {
+		return t.Description + " (" + summary + ")"
+	}
+	return summary
+}
+
+func (s TemplateStep) stepName(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("step-%d", i+1)
+}
+
+var templateParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validate checks parameters, step names, durations and conditions.
+func (t Template) Validate() error {
+	if len(t.Steps) == 0 {
+		return errors.New("template has no steps")
+	}
+	params := make(map[string]bool)
+	for _, p := range t.Params {
+		if !templateParamName.MatchString(p.Name) {
+			return fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+		if params[p.Name] {
+			return fmt.Errorf("duplicate parameter %q", p.Name)
+		}
+		params[p.Name] = true
+	}
+	seen := make(map[string]bool)
+	for i, step := range t.Steps {
+		name := step.stepName(i)
+		if seen[name] {
+			return fmt.Errorf("duplicate step name %q", name)
+		}
+		if strings.TrimSpace(step.Run) == "" {
+			return fmt.Errorf("step %s has nothing to run", name)
+		}
+		if len(t.Params) > 0 {
+			if _, err := template.New(name).Parse(step.Run); err != nil {
+				return fmt.Errorf("step %s: %v", name, err)
+			}
+		}
+		if step.Retries < 0 {
+			return fmt.Errorf("step %s: retries must not be negative", name)
+		}
+		if _, err := parseStepDuration(step.Timeout, 0); err != nil {
+			return fmt.Errorf("step %s: invalid timeout: %v", name, err)
+		}
+		if _, err := parseStepDuration(step.Backoff, defaultStepBackoff); err != nil {
+			return fmt.Errorf("step %s: invalid backoff: %v", name, err)
+		}
+		if cond := step.When; cond != nil {
+			if i == 0 {
+				return fmt.Errorf("step %s: the first step cannot be conditional", name)
+			}
+			if cond.Step != "" && !seen[cond.Step] {
+				return fmt.Errorf("step %s: condition refers to %q which is not a prior step", name, cond.Step)
+			}
+			if cond.OutputMatches != "" {
+				if _, err := regexp.Compile(cond.OutputMatches); err != nil {
+					return fmt.Errorf("step %s: invalid output_matches: %v", name, err)
+				}
+			}
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// Render resolves the parameters of t from values, falling back to their defaults,
+// and substitutes them into the steps. Templates without parameters are returned unchanged.
+func (t Template) Render(values map[string]string) (Template, error) {
+	data := make(map[string]string, len(t.Params))
+	for _, p := range t.Params {
+		value, ok := values[p.Name]
+		if !ok || value == "" {
+			value = p.Default
+		}
+		if value == "" && p.Required {
+			return Template{}, fmt.Errorf("parameter %s is required", p.Name)
+		}
+		if len(p.Values) > 0 && value != "" && !stringInSlice(value, p.Values) {
+			return Template{}, fmt.Errorf("invalid value %q for parameter %s, must be one of: %s",
+				value, p.Name, strings.Join(p.Values, ", "))
+		}
+		data[p.Name] = value
+	}
+	for name := range values {
+		if _, ok := data[name]; !ok {
+			return Template{}, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	if len(t.Params) == 0 {
+		return t, nil
+	}
+
+	rendered := t
+	rendered.Steps = make([]TemplateStep, len(t.Steps))
+	for i, step := range t.Steps {
+		tmpl, err := template.New(step.stepName(i)).Option("missingkey=error").Parse(step.Run)
+		if err != nil {
+			return Template{}, err
+		}
+		var run strings.Builder
+		if err := tmpl.Execute(&run, data); err != nil {
+			return Template{}, err
+		}
+		step.Run = run.String()
+		rendered.Steps[i] = step
+	}
+	return rendered, nil
+}
+
+func parseStepDuration(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %s is negative", value)
+	}
+	return d, nil
+}
+
+func (c *StepCondition) describe(prior string) string {
+	var parts []string
+	if c.ExitCode != nil {
+		parts = append(parts, fmt.Sprintf("exit code is %d", *c.ExitCode))
+	}
+	if c.Succeeded != nil {
+		if *c.Succeeded {
+			parts = append(parts, "succeeded")
+		} else {
+			parts = append(parts, "failed")
+		}
+	}
+	if c.OutputContains != "" {
+		parts = append(parts, fmt.Sprintf("output contains %q", c.OutputContains))
+	}
+	if c.OutputMatches != "" {
+		parts = append(parts, fmt.Sprintf("output matches /%s/", c.OutputMatches))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "ran")
+	}
+	return prior + " " + strings.Join(parts, " and ")
+}
+
+func (c *StepCondition) matches(result *StepResult) bool {
+	if result == nil || result.Skipped {
+		return false
+	}
+	if c.ExitCode != nil && result.ExitCode != *c.ExitCode {
+		return false
+	}
+	if c.Succeeded != nil && (result.ExitCode == 0) != *c.Succeeded {
+		return false
+	}
+	if c.OutputContains != "" && !strings.Contains(result.Output, c.OutputContains) {
+		return false
+	}
+	if c.OutputMatches != "" && !regexp.MustCompile(c.OutputMatches).MatchString(result.Output) {
+		return false
+	}
+	return true
+}
+
+// TemplateRunner executes templates with "sh -c".
+type TemplateRunner struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	// DryRun prints the resolved plan to Stdout without executing anything.
+	DryRun bool
+
+	// sleep waits between retries; replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Run executes the steps of t in order and returns the result of every step.
+// It stops at the first failing step that does not set ContinueOnError.
+func (r *TemplateRunner) Run(ctx context.Context, t Template) ([]StepResult, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	if r.DryRun {
+		r.printPlan(t)
+		return nil, nil
+	}
+
+	results := make([]StepResult, 0, len(t.Steps))
+	byName := make(map[string]*StepResult)
+	for i, step := range t.Steps {
+		name := step.stepName(i)
+		if cond := step.When; cond != nil {
+			prior := cond.Step
+			if prior == "" {
+				prior = t.Steps[i-1].stepName(i - 1)
+			}
+			if !cond.matches(byName[prior]) {
+				results = append(results, StepResult{Name: name, Skipped: true})
+				byName[name] = &results[len(results)-1]
+				continue
+			}
+		}
+
+		result := r.runStep(ctx, name, step)
+		results = append(results, result)
+		byName[name] = &results[len(results)-1]
+		if result.Err != nil && !step.ContinueOnError {
+			return results, fmt.Errorf("step %s failed: %v", name, result.Err)
+		}
+	}
+	return results, nil
+}
+
+func (r *TemplateRunner) runStep(ctx context.Context, name string, step TemplateStep) StepResult {
+	timeout, _ := parseStepDuration(step.Timeout, 0)
+	backoff, _ := parseStepDuration(step.Backoff, defaultStepBackoff)
+	sleep := r.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	result := StepResult{Name: name}
+	start := time.Now()
+	for attempt := 0; attempt <= step.Retries; attempt++ {
+		if attempt > 0 {
+			fmt.Fprintf(r.stderr(), "Step %s failed, retrying in %s (attempt %d of %d)\n", name, backoff, attempt+1, step.Retries+1)
+			if err := sleep(ctx, backoff); err != nil {
+				result.Err = err
+				break
+			}
+			backoff *= 2
+		}
+		result.Attempts++
+		result.ExitCode, result.Output, result.Err = r.exec(ctx, step.Run, timeout)
+		if result.Err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+func (r *TemplateRunner) exec(ctx context.Context, command string, timeout time.Duration) (int, string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = io.MultiWriter(r.stdout(), &output)
+	cmd.Stderr = r.stderr()
+	// Children of the shell may keep the output pipes open after it is killed.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return -1, output.String(), fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), output.String(), err
+	}
+	if err != nil {
+		return -1, output.String(), err
+	}
+	return 0, output.String(), nil
+}
+
+func (r *TemplateRunner) printPlan(t Template) {
+	out := r.stdout()
+	if t.Description != "" {
+		fmt.Fprintln(out, t.Description)
+	}
+	for i, step := range t.Steps {
+		fmt.Fprintf(out, "%d. %s: %s\n", i+1, step.stepName(i), step.Run)
+		if cond := step.When; cond != nil {
+			prior := cond.Step
+			if prior == "" {
+				prior = t.Steps[i-1].stepName(i - 1)
+			}
+			fmt.Fprintf(out, "   only if %s\n", cond.describe(prior))
+		}
+		if step.Timeout != "" {
+			fmt.Fprintf(out, "   timeout %s\n", step.Timeout)
+		}
+		if step.Retries > 0 {
+			backoff, _ := parseStepDuration(step.Backoff, defaultStepBackoff)
+			fmt.Fprintf(out, "   retry %d times, backoff %s\n", step.Retries, backoff)
+		}
+		if step.ContinueOnError {
+			fmt.Fprintln(out, "   continue on error")
+		}
+	}
+}
+
+func (r *TemplateRunner) stdout() io.Writer {
+	if r.Stdout == nil {
+		return io.Discard
+	}
+	return r.Stdout
+}
+
+func (r *TemplateRunner) stderr() io.Writer {
+	if r.Stderr == nil {
+		return io.Discard
+	}
+	return r.Stderr
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func noSleep(ctx context.Context, d time.Duration) error { return nil }
+
+func TestTemplateLegacyCommandString(t *testing.T) {
+	var templates map[string]Template
+	if err := yaml.Unmarshal([]byte("build: go build && go test\n"), &templates); err != nil {
+		t.Fatal(err)
+	}
+	steps := templates["build"].Steps
+	if len(steps) != 2 || steps[0].Run != "go build" || steps[1].Run != "go test" {
+		t.Errorf("expected two steps, got %+v", steps)
+	}
+}
+
+func TestTemplateRunnerStopsOnFailure(t *testing.T) {
+	tmpl := Template{Steps: []TemplateStep{
+		{Name: "fail", Run: "exit 3", ContinueOnError: true},
+		{Name: "echo", Run: "echo hello"},
+		{Name: "stop", Run: "exit 1"},
+		{Name: "never", Run: "echo never"},
+	}}
+	var out bytes.Buffer
+	runner := &TemplateRunner{Stdout: &out, sleep: noSleep}
+
+	results, err := runner.Run(context.Background(), tmpl)
+	if err == nil || !strings.Contains(err.Error(), "step stop failed") {
+		t.Fatalf("expected step stop to fail, got %v", err)
+	}
+	if len(results) != 3 || results[0].ExitCode != 3 || results[1].Output != "hello\n" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if strings.Contains(out.String(), "never") {
+		t.Errorf("expected steps after the failure not to run, got %q", out.String())
+	}
+}
+
+func TestTemplateRunnerConditions(t *testing.T) {
+	succeeded := true
+	exitCode := 2
+	tmpl := Template{Steps: []TemplateStep{
+		{Name: "check", Run: "echo ready; exit 2", ContinueOnError: true},
+		{Name: "on-success", Run: "echo success", When: &StepCondition{Succeeded: &succeeded}},
+		{Name: "on-code", Run: "echo code", When: &StepCondition{Step: "check", ExitCode: &exitCode}},
+		{Name: "on-output", Run: "echo output", When: &StepCondition{Step: "check", OutputMatches: "^re.dy"}},
+		{Name: "after-skip", Run: "echo after", When: &StepCondition{Step: "on-success"}},
+	}}
+	runner := &TemplateRunner{sleep: noSleep}
+
+	results, err := runner.Run(context.Background(), tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var skipped []string
+	for _, r := range results {
+		if r.Skipped {
+			skipped = append(skipped, r.Name)
+		}
+	}
+	if strings.Join(skipped, ",") != "on-success,after-skip" {
+		t.Errorf("expected on-success and after-skip to be skipped, got %v", skipped)
+	}
+}
+
+func TestTemplateRunnerRetries(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := Template{Steps: []TemplateStep{
+		{Run: "echo x >> " + dir + "/count; test $(wc -l < " + dir + "/count) -ge 3", Retries: 5},
+	}}
+	var delays []time.Duration
+	runner := &TemplateRunner{sleep: func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}}
+
+	results, err := runner.Run(context.Background(), tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", results[0].Attempts)
+	}
+	if len(delays) != 2 || delays[0] != time.Second || delays[1] != 2*time.Second {
+		t.Errorf("expected exponential backoff, got %v", delays)
+	}
+}
+
+func TestTemplateRunnerTimeout(t *testing.T) {
+	tmpl := Template{Steps: []TemplateStep{{Run: "sleep 5", Timeout: "50ms"}}}
+	runner := &TemplateRunner{sleep: noSleep}
+
+	_, err := runner.Run(context.Background(), tmpl)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
+
+func TestTemplateRunnerDryRun(t *testing.T) {
+	succeeded := false
+	tmpl := Template{Steps: []TemplateStep{
+		{Name: "build", Run: "exit 1", Retries: 2},
+		{Run: "echo cleanup", When: &StepCondition{Succeeded: &succeeded}},
+	}}
+	var out bytes.Buffer
+	runner := &TemplateRunner{Stdout: &out, DryRun: true}
+
+	if _, err := runner.Run(context.Background(), tmpl); err != nil {
+		t.Fatal(err)
+	}
+	expected := "1. build: exit 1\n   retry 2 times, backoff 1s\n2. step-2: echo cleanup\n   only if build failed\n"
+	if out.String() != expected {
+		t.Errorf("expected plan %q, got %q", expected, out.String())
+	}
+}
+
+func TestTemplateValidate(t *testing.T) {
+	tmpl := Template{Steps: []TemplateStep{
+		{Run: "true"},
+		{Run: "true", When: &StepCondition{Step: "later"}},
+		{Name: "later", Run: "true"},
+	}}
+	if err := tmpl.Validate(); err == nil || !strings.Contains(err.Error(), "not a prior step") {
+		t.Errorf("expected forward reference to be rejected, got %v", err)
+	}
+}
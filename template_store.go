@@ -0,0 +1,295 @@
+// Layered template stores
+package cobra
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateSource identifies the store a template was loaded from.
+type TemplateSource string
+
+const (
+	TemplateSourceSystem  TemplateSource = "system"
+	TemplateSourceUser    TemplateSource = "user"
+	TemplateSourceProject TemplateSource = "project"
+)
+
+// templateSourcePrecedence lists the sources from lowest to highest precedence.
+// A template defined in a later source shadows one with the same name in an earlier source.
+var templateSourcePrecedence = []TemplateSource{
+	TemplateSourceSystem,
+	TemplateSourceUser,
+	TemplateSourceProject,
+}
+
+// SystemTemplatesFile is the machine-wide template store shared by all users.
+// Set it to an empty string to disable the system store.
+var SystemTemplatesFile = filepath.Join(string(filepath.Separator), "etc", "cobra", "templates.yaml")
+
+// ProjectTemplatesFile is the path, relative to a project directory, of the project template store.
+// It is discovered by walking up from the working directory.
+var ProjectTemplatesFile = filepath.Join(".cobra", "templates.yaml")
+
+// ParseTemplateSource converts a user supplied name into a TemplateSource.
+func ParseTemplateSource(name string) (TemplateSource, error) {
+	for _, source := range templateSourcePrecedence {
+		if strings.EqualFold(string(source), name) {
+			return source, nil
+		}
+	}
+	return "", fmt.Errorf("invalid template source %q, must be one of: system, user, project", name)
+}
+
+// templateStoreVersion is the schema version written to template store files.
+// Version 1 stored every template as a single command string, version 2 stores structured templates.
+const templateStoreVersion = 2
+
+// ErrTemplateStoreVersion is returned when a template store was written by a newer
+// version of the application and cannot be read safely.
+var ErrTemplateStoreVersion = errors.New("unsupported template store version")
+
+// errCorruptTemplateStore marks a store whose content cannot be decoded.
+var errCorruptTemplateStore = errors.New("corrupt template store")
+
+// templateDocument is the on-disk layout of a template store.
+// Files without a version are read as a plain map of name to template.
+type templateDocument struct {
+	Version   *int                `json:"version" yaml:"version"`
+	Templates map[string]Template `json:"templates" yaml:"templates"`
+}
+
+// templateStore is a single file holding templates, encoded as JSON or YAML depending on its extension.
+//
+// Writes are serialized with an advisory lock on a sibling ".lock" file and replace the store
+// atomically, keeping the previous content in a ".bak" file. A store that cannot be decoded is
+// moved aside and restored from its backup instead of failing every later command.
+type templateStore struct {
+	source TemplateSource
+	file   string
+}
+
+func (s *templateStore) load() (map[string]Template, error) {
+	templates, err := s.read()
+	if !errors.Is(err, errCorruptTemplateStore) {
+		return templates, err
+	}
+	err = s.withLock(func() error {
+		templates, err = s.recoverLocked()
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %s templates %s: %v\n", s.source, s.file, err)
+		return make(map[string]Template), nil
+	}
+	return templates, nil
+}
+
+// update applies fn to the content of the store and writes the result back while holding the store lock.
+func (s *templateStore) update(fn func(templates map[string]Template) error) error {
+	return s.withLock(func() error {
+		templates, err := s.read()
+		if errors.Is(err, errCorruptTemplateStore) {
+			templates, err = s.recoverLocked()
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(templates); err != nil {
+			return err
+		}
+		return s.writeLocked(templates)
+	})
+}
+
+func (s *templateStore) save(templates map[string]Template) error {
+	return s.withLock(func() error {
+		return s.writeLocked(templates)
+	})
+}
+
+func (s *templateStore) read() (map[string]Template, error) {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[string]Template), nil
+		}
+		return nil, err
+	}
+	templates, err := decodeTemplates(s.file, data)
+	if errors.Is(err, ErrTemplateStoreVersion) {
+		return nil, fmt.Errorf("%s templates %s: %w", s.source, s.file, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", errCorruptTemplateStore, s.file, err)
+	}
+	return templates, nil
+}
+
+func (s *templateStore) withLock(fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(s.file+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return fmt.Errorf("failed to lock %s templates: %v", s.source, err)
+	}
+	defer unlockFile(lock)
+	return fn()
+}
+
+// recoverLocked moves a corrupt store aside and restores the last backup, if any.
+// It must be called with the store lock held.
+func (s *templateStore) recoverLocked() (map[string]Template, error) {
+	// Another process may have repaired the store while we waited for the lock.
+	templates, err := s.read()
+	if !errors.Is(err, errCorruptTemplateStore) {
+		return templates, err
+	}
+
+	corrupt := fmt.Sprintf("%s.corrupt-%s", s.file, time.Now().Format("20060102-150405"))
+	if err := os.Rename(s.file, corrupt); err != nil {
+		return nil, err
+	}
+
+	templates = make(map[string]Template)
+	restored := "no backup was available"
+	if data, err := os.ReadFile(s.file + ".bak"); err == nil {
+		if backup, err := decodeTemplates(s.file, data); err == nil {
+			templates = backup
+			restored = fmt.Sprintf("restored %d templates from %s.bak", len(templates), s.file)
+		}
+	}
+	if err := s.writeFile(templates); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s templates %s were corrupt and have been moved to %s; %s\n",
+		s.source, s.file, corrupt, restored)
+	return templates, nil
+}
+
+// writeLocked backs up the current content of the store and replaces it with templates.
+// It must be called with the store lock held.
+func (s *templateStore) writeLocked(templates map[string]Template) error {
+	if data, err := os.ReadFile(s.file); err == nil {
+		if _, err := decodeTemplates(s.file, data); err == nil {
+			if err := writeFileAtomic(s.file+".bak", data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return s.writeFile(templates)
+}
+
+func (s *templateStore) writeFile(templates map[string]Template) error {
+	data, err := encodeTemplates(s.file, templates)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.file, data, 0644)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of file and renames it
+// over file, so readers observe either the old or the new content and never a partial write.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(file)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func isYAMLFile(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func unmarshalTemplates(file string, data []byte, v interface{}) error {
+	if isYAMLFile(file) {
+		return yaml.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeTemplates(file string, data []byte) (map[string]Template, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return make(map[string]Template), nil
+	}
+
+	var doc templateDocument
+	if err := unmarshalTemplates(file, data, &doc); err == nil && doc.Version != nil {
+		if *doc.Version > templateStoreVersion {
+			return nil, fmt.Errorf("%w %d, this version supports up to %d", ErrTemplateStoreVersion, *doc.Version, templateStoreVersion)
+		}
+		if doc.Templates == nil {
+			doc.Templates = make(map[string]Template)
+		}
+		return doc.Templates, nil
+	}
+
+	// Stores written before the version field was introduced are a plain map.
+	var templates map[string]Template
+	if err := unmarshalTemplates(file, data, &templates); err != nil {
+		return nil, err
+	}
+	if templates == nil {
+		templates = make(map[string]Template)
+	}
+	return templates, nil
+}
+
+func encodeTemplates(file string, templates map[string]Template) ([]byte, error) {
+	version := templateStoreVersion
+	doc := templateDocument{Version: &version, Templates: templates}
+	if isYAMLFile(file) {
+		return yaml.Marshal(doc)
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// findProjectTemplates walks up from dir looking for ProjectTemplatesFile.
+// It returns an empty string if no project store exists.
+func findProjectTemplates(dir string) string {
+	for {
+		candidate := filepath.Join(dir, ProjectTemplatesFile)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
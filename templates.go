@@ -2,77 +2,253 @@
 package cobra
 
 import (
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 )
 
+// TemplateManager resolves templates across the system, user and project stores.
+// Templates in the project store shadow user templates, which in turn shadow system templates.
 type TemplateManager struct {
-	file string
+	stores map[TemplateSource]*templateStore
+}
+
+// TemplateEntry is a template together with the store it was loaded from.
+type TemplateEntry struct {
+	Name     string
+	Template Template
+	Source   TemplateSource
+	File     string
+	// ShadowedBy is set when a store with higher precedence defines a template with the same name.
+	ShadowedBy TemplateSource
 }
 
 func NewTemplateManager() *TemplateManager {
 	dir := filepath.Join(os.Getenv("HOME"), ".cobra")
 	os.MkdirAll(dir, 0755)
-	return &TemplateManager{file: filepath.Join(dir, "templates.json")}
+	wd, _ := os.Getwd()
+	return newTemplateManager(SystemTemplatesFile, filepath.Join(dir, "templates.json"), wd)
 }
 
-func (tm *TemplateManager) Load() (map[string]string, error) {
-	data, err := os.ReadFile(tm.file)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return make(map[string]string), nil
+func newTemplateManager(systemFile, userFile, workDir string) *TemplateManager {
+	projectFile := findProjectTemplates(workDir)
+	if projectFile == "" {
+		// Nothing to discover yet; writes create the store in the working directory.
+		projectFile = filepath.Join(workDir, ProjectTemplatesFile)
+	}
+	tm := &TemplateManager{stores: make(map[TemplateSource]*templateStore)}
+	tm.stores[TemplateSourceUser] = &templateStore{source: TemplateSourceUser, file: userFile}
+	tm.stores[TemplateSourceProject] = &templateStore{source: TemplateSourceProject, file: projectFile}
+	if systemFile != "" {
+		tm.stores[TemplateSourceSystem] = &templateStore{source: TemplateSourceSystem, file: systemFile}
+	}
+	return tm
+}
+
+func (tm *TemplateManager) store(source TemplateSource) (*templateStore, error) {
+	s, ok := tm.stores[source]
+	if !ok {
+		return nil, fmt.Errorf("%s template store is not configured", source)
+	}
+	return s, nil
+}
+
+// StoreFile returns the file backing the given source.
+func (tm *TemplateManager) StoreFile(source TemplateSource) string {
+	if s, ok := tm.stores[source]; ok {
+		return s.file
+	}
+	return ""
+}
+
+// Load returns the effective templates after applying precedence across all stores.
+func (tm *TemplateManager) Load() (map[string]Template, error) {
+	templates := make(map[string]Template)
+	for _, source := range templateSourcePrecedence {
+		s, ok := tm.stores[source]
+		if !ok {
+			continue
+		}
+		loaded, err := s.load()
+		if err != nil {
+			return nil, err
 		}
+		for name, t := range loaded {
+			templates[name] = t
+		}
+	}
+	return templates, nil
+}
+
+// LoadSource returns the templates defined in a single store.
+func (tm *TemplateManager) LoadSource(source TemplateSource) (map[string]Template, error) {
+	s, err := tm.store(source)
+	if err != nil {
 		return nil, err
 	}
-	var templates map[string]string
-	err = json.Unmarshal(data, &templates)
-	return templates, err
+	return s.load()
+}
+
+// Save replaces the content of the user store.
+func (tm *TemplateManager) Save(templates map[string]Template) error {
+	return tm.SaveSource(TemplateSourceUser, templates)
 }
 
-func (tm *TemplateManager) Save(templates map[string]string) error {
-	data, err := json.MarshalIndent(templates, "", "  ")
+// SaveSource replaces the content of the given store.
+func (tm *TemplateManager) SaveSource(source TemplateSource, templates map[string]Template) error {
+	s, err := tm.store(source)
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(tm.file, data, 0644)
+	return s.save(templates)
 }
 
+// Add saves a "&&" separated command line as a template in the user store.
 func (tm *TemplateManager) Add(name, command string) error {
-	templates, err := tm.Load()
+	return tm.AddTo(TemplateSourceUser, name, command)
+}
+
+// AddTo saves a "&&" separated command line as a template in the given store.
+func (tm *TemplateManager) AddTo(source TemplateSource, name, command string) error {
+	return tm.SaveTemplate(source, name, ParseTemplateCommand(command))
+}
+
+// SaveTemplate validates t and saves it in the given store.
+func (tm *TemplateManager) SaveTemplate(source TemplateSource, name string, t Template) error {
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("invalid template %s: %v", name, err)
+	}
+	s, err := tm.store(source)
 	if err != nil {
 		return err
 	}
-	templates[name] = command
-	return tm.Save(templates)
+	return s.update(func(templates map[string]Template) error {
+		templates[name] = t
+		return nil
+	})
 }
 
+// Get returns a one line summary of the effective template for name.
 func (tm *TemplateManager) Get(name string) (string, error) {
-	templates, err := tm.Load()
+	t, err := tm.GetTemplate(name)
 	if err != nil {
 		return "", err
 	}
-	cmd, ok := templates[name]
-	if !ok {
-		return "", fmt.Errorf("template %s not found", name)
+	return t.String(), nil
+}
+
+// GetTemplate returns the effective template for name.
+func (tm *TemplateManager) GetTemplate(name string) (Template, error) {
+	entry, err := tm.Lookup(name)
+	if err != nil {
+		return Template{}, err
+	}
+	return entry.Template, nil
+}
+
+// Lookup returns the effective template for name and the store it comes from.
+func (tm *TemplateManager) Lookup(name string) (TemplateEntry, error) {
+	entries, err := tm.Entries()
+	if err != nil {
+		return TemplateEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Name == name && entry.ShadowedBy == "" {
+			return entry, nil
+		}
 	}
-	return cmd, nil
+	return TemplateEntry{}, fmt.Errorf("template %s not found", name)
 }
 
-func (tm *TemplateManager) List() (map[string]string, error) {
+func (tm *TemplateManager) List() (map[string]Template, error) {
 	return tm.Load()
 }
 
+// Entries returns every template of every store, sorted by name and then by
+// decreasing precedence. Entries hidden by a store with higher precedence have
+// ShadowedBy set.
+func (tm *TemplateManager) Entries() ([]TemplateEntry, error) {
+	var entries []TemplateEntry
+	for i := len(templateSourcePrecedence) - 1; i >= 0; i-- {
+		s, ok := tm.stores[templateSourcePrecedence[i]]
+		if !ok {
+			continue
+		}
+		loaded, err := s.load()
+		if err != nil {
+			return nil, err
+		}
+		for name, t := range loaded {
+			entries = append(entries, TemplateEntry{Name: name, Template: t, Source: s.source, File: s.file})
+		}
+	}
+	// Stable sort keeps entries of the same name in precedence order.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Name != entries[i-1].Name {
+			continue
+		}
+		if entries[i-1].ShadowedBy != "" {
+			entries[i].ShadowedBy = entries[i-1].ShadowedBy
+		} else {
+			entries[i].ShadowedBy = entries[i-1].Source
+		}
+	}
+	return entries, nil
+}
+
 func (tm *TemplateManager) Delete(name string) error {
-	templates, err := tm.Load()
+	return tm.DeleteFrom(TemplateSourceUser, name)
+}
+
+// DeleteFrom removes a template from the given store.
+func (tm *TemplateManager) DeleteFrom(source TemplateSource, name string) error {
+	s, err := tm.store(source)
+	if err != nil {
+		return err
+	}
+	return s.update(func(templates map[string]Template) error {
+		delete(templates, name)
+		return nil
+	})
+}
+
+// Import merges templates into the given store. Unless overwrite is set, it fails
+// without writing anything when an imported template would replace a different
+// existing definition.
+func (tm *TemplateManager) Import(source TemplateSource, imported map[string]Template, overwrite bool) error {
+	for name, t := range imported {
+		if err := t.Validate(); err != nil {
+			return fmt.Errorf("invalid template %s: %v", name, err)
+		}
+	}
+	s, err := tm.store(source)
 	if err != nil {
 		return err
 	}
-	delete(templates, name)
-	return tm.Save(templates)
+	return s.update(func(templates map[string]Template) error {
+		var conflicts []string
+		for name, t := range imported {
+			if existing, ok := templates[name]; ok && !reflect.DeepEqual(existing, t) && !overwrite {
+				conflicts = append(conflicts, name)
+			}
+		}
+		if len(conflicts) > 0 {
+			sort.Strings(conflicts)
+			return fmt.Errorf("templates already exist in %s store: %s (use --force to overwrite)",
+				source, strings.Join(conflicts, ", "))
+		}
+		for name, t := range imported {
+			templates[name] = t
+		}
+		return nil
+	})
 }
 
 func CreateTemplateCommand() *Command {
@@ -81,70 +257,216 @@ func CreateTemplateCommand() *Command {
 	cmd := &Command{
 		Use:   "template",
 		Short: "Manage command templates",
+		Long: `Manage command templates.
+
+Templates are read from three stores, from lowest to highest precedence:
+  system   ` + SystemTemplatesFile + `
+  user     $HOME/.cobra/templates.json
+  project  ` + ProjectTemplatesFile + `, found by walking up from the working directory
+
+A template in a store with higher precedence shadows a template with the same name
+in a store with lower precedence.`,
 	}
 
+	var saveScope, saveFile string
 	saveCmd := &Command{
-		Use:   "save <name> <command>",
+		Use:   "save <name> [command]",
 		Short: "Save a command template",
-		Args:  MinimumNArgs(2),
+		Long: `Save a command template.
+
+The template is either given on the command line as commands separated by "&&",
+or read with --file from a YAML or JSON file describing its steps:
+
+  description: Build and publish
+  steps:
+    - name: build
+      run: go build ./...
+      timeout: 5m
+    - name: publish
+      run: ./publish.sh
+      retries: 3
+      backoff: 2s
+      when:
+        step: build
+        succeeded: true
+    - run: echo "publish failed"
+      continue_on_error: true
+      when:
+        step: publish
+        succeeded: false`,
+		Args: func(cmd *Command, args []string) error {
+			if saveFile != "" {
+				return ExactArgs(1)(cmd, args)
+			}
+			return MinimumNArgs(2)(cmd, args)
+		},
 		RunE: func(cmd *Command, args []string) error {
+			source, err := ParseTemplateSource(saveScope)
+			if err != nil {
+				return err
+			}
 			name := args[0]
-			command := strings.Join(args[1:], " ")
-			return tm.Add(name, command)
+			if saveFile == "" {
+				command := strings.Join(args[1:], " ")
+				return tm.AddTo(source, name, command)
+			}
+			data, err := os.ReadFile(saveFile)
+			if err != nil {
+				return err
+			}
+			var t Template
+			if err := unmarshalTemplates(saveFile, data, &t); err != nil {
+				return fmt.Errorf("failed to parse %s: %v", saveFile, err)
+			}
+			return tm.SaveTemplate(source, name, t)
 		},
 	}
+	saveCmd.Flags().StringVar(&saveScope, "scope", string(TemplateSourceUser), "store to save the template in (system, user or project)")
+	saveCmd.Flags().StringVarP(&saveFile, "file", "f", "", "read the template steps from a YAML or JSON file")
 
+	var dryRun bool
+	var params map[string]string
 	runCmd := &Command{
-		Use:   "run <name>",
-		Short: "Run a command template",
-		Args:  ExactArgs(1),
+		Use:               "run <name>",
+		Short:             "Run a command template",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeTemplateNames(tm),
 		RunE: func(cmd *Command, args []string) error {
-			template, err := tm.Get(args[0])
+			template, err := tm.GetTemplate(args[0])
 			if err != nil {
 				return err
 			}
-			// Execute the template
-			parts := strings.Split(template, "&&")
-			for _, part := range parts {
-				part = strings.TrimSpace(part)
-				if part == "" {
+			return runTemplate(cmd, template, params, dryRun)
+		},
+	}
+	runCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the resolved steps without executing them")
+	runCmd.Flags().StringToStringVarP(&params, "param", "p", nil, "template parameter as name=value")
+	_ = runCmd.RegisterFlagCompletionFunc("param", completeTemplateParams(tm))
+
+	var showSource bool
+	listCmd := &Command{
+		Use:   "list",
+		Short: "List all templates",
+		RunE: func(cmd *Command, args []string) error {
+			entries, err := tm.Entries()
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			for _, entry := range entries {
+				if !showSource {
+					if entry.ShadowedBy == "" {
+						fmt.Fprintf(out, "%s: %s\n", entry.Name, entry.Template)
+					}
 					continue
 				}
-				cmd := exec.Command("sh", "-c", part)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err := cmd.Run(); err != nil {
-					return fmt.Errorf("failed to run %s: %v", part, err)
+				fmt.Fprintf(out, "%s [%s %s]: %s", entry.Name, entry.Source, entry.File, entry.Template)
+				if entry.ShadowedBy != "" {
+					fmt.Fprintf(out, " (shadowed by %s)", entry.ShadowedBy)
 				}
+				fmt.Fprintln(out)
 			}
 			return nil
 		},
 	}
+	listCmd.Flags().BoolVar(&showSource, "source", false, "show the store of each template, including shadowed templates")
 
-	listCmd := &Command{
-		Use:   "list",
-		Short: "List all templates",
+	var deleteScope string
+	deleteCmd := &Command{
+		Use:               "delete <name>",
+		Short:             "Delete a template",
+		Args:              ExactArgs(1),
+		ValidArgsFunction: completeTemplateNames(tm),
 		RunE: func(cmd *Command, args []string) error {
-			templates, err := tm.List()
+			source, err := ParseTemplateSource(deleteScope)
 			if err != nil {
 				return err
 			}
-			for name, command := range templates {
-				fmt.Printf("%s: %s\n", name, command)
+			return tm.DeleteFrom(source, args[0])
+		},
+	}
+	deleteCmd.Flags().StringVar(&deleteScope, "scope", string(TemplateSourceUser), "store to delete the template from (system, user or project)")
+
+	var importScope string
+	var importForce bool
+	importCmd := &Command{
+		Use:   "import <file>",
+		Short: "Import templates from a YAML or JSON file",
+		Long: `Import templates from a YAML or JSON file, or from standard input when the file is "-".
+
+Templates are imported into the project store by default so they can be committed
+and shared with the rest of the team.`,
+		Args: ExactArgs(1),
+		RunE: func(cmd *Command, args []string) error {
+			source, err := ParseTemplateSource(importScope)
+			if err != nil {
+				return err
+			}
+			file := args[0]
+			var data []byte
+			if file == "-" {
+				file = "stdin.yaml"
+				data, err = io.ReadAll(cmd.InOrStdin())
+			} else {
+				data, err = os.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+			imported, err := decodeTemplates(file, data)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %v", args[0], err)
+			}
+			if err := tm.Import(source, imported, importForce); err != nil {
+				return err
 			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Imported %d templates into %s\n", len(imported), tm.StoreFile(source))
 			return nil
 		},
 	}
+	importCmd.Flags().StringVar(&importScope, "scope", string(TemplateSourceProject), "store to import the templates into (system, user or project)")
+	importCmd.Flags().BoolVar(&importForce, "force", false, "overwrite existing templates with the same name")
 
-	deleteCmd := &Command{
-		Use:   "delete <name>",
-		Short: "Delete a template",
-		Args:  ExactArgs(1),
+	var exportScope string
+	exportCmd := &Command{
+		Use:   "export [file]",
+		Short: "Export templates to a YAML or JSON file",
+		Long: `Export templates to a YAML or JSON file, or to standard output when no file is given.
+
+Without --scope the effective templates of all stores are exported.`,
+		Args: MaximumNArgs(1),
 		RunE: func(cmd *Command, args []string) error {
-			return tm.Delete(args[0])
+			var templates map[string]Template
+			var err error
+			if exportScope == "" {
+				templates, err = tm.Load()
+			} else {
+				var source TemplateSource
+				if source, err = ParseTemplateSource(exportScope); err != nil {
+					return err
+				}
+				templates, err = tm.LoadSource(source)
+			}
+			if err != nil {
+				return err
+			}
+			file := "stdout.yaml"
+			if len(args) == 1 {
+				file = args[0]
+			}
+			data, err := encodeTemplates(file, templates)
+			if err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				_, err = cmd.OutOrStdout().Write(data)
+				return err
+			}
+			return os.WriteFile(file, data, 0644)
 		},
 	}
+	exportCmd.Flags().StringVar(&exportScope, "scope", "", "only export the templates of this store (system, user or project)")
 
-	cmd.AddCommand(saveCmd, runCmd, listCmd, deleteCmd)
+	cmd.AddCommand(saveCmd, runCmd, listCmd, deleteCmd, importCmd, exportCmd)
 	return cmd
-}
\ No newline at end of file
+}
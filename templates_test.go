@@ -0,0 +1,199 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestTemplateManager(t *testing.T) (*TemplateManager, string) {
+	dir := t.TempDir()
+	workDir := filepath.Join(dir, "repo", "sub", "dir")
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	projectFile := filepath.Join(dir, "repo", ProjectTemplatesFile)
+	if err := os.MkdirAll(filepath.Dir(projectFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(projectFile, []byte("build: make project\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tm := newTemplateManager(filepath.Join(dir, "system.yaml"), filepath.Join(dir, "user.json"), workDir)
+	return tm, projectFile
+}
+
+func TestTemplateProjectStoreDiscovery(t *testing.T) {
+	tm, projectFile := newTestTemplateManager(t)
+
+	if got := tm.StoreFile(TemplateSourceProject); got != projectFile {
+		t.Errorf("expected project store %q, got %q", projectFile, got)
+	}
+}
+
+func TestTemplatePrecedence(t *testing.T) {
+	tm, _ := newTestTemplateManager(t)
+
+	if err := tm.AddTo(TemplateSourceSystem, "build", "make system"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.AddTo(TemplateSourceSystem, "lint", "make lint"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Add("build", "make user"); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := tm.Lookup("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Source != TemplateSourceProject || entry.Template.String() != "make project" {
+		t.Errorf("expected build from project store, got %+v", entry)
+	}
+
+	entries, err := tm.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name+":"+string(e.Source)+":"+string(e.ShadowedBy))
+	}
+	expected := "build:project:,build:user:project,build:system:project,lint:system:"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("expected entries %q, got %q", expected, strings.Join(got, ","))
+	}
+}
+
+func TestTemplateImportConflicts(t *testing.T) {
+	tm, _ := newTestTemplateManager(t)
+
+	imported := map[string]Template{
+		"build": ParseTemplateCommand("make other"),
+		"test":  ParseTemplateCommand("go test"),
+	}
+	err := tm.Import(TemplateSourceProject, imported, false)
+	if err == nil || !strings.Contains(err.Error(), "build") {
+		t.Fatalf("expected conflict on build, got %v", err)
+	}
+	if _, err := tm.Get("test"); err == nil {
+		t.Errorf("expected failed import to leave the store untouched")
+	}
+
+	if err := tm.Import(TemplateSourceProject, map[string]Template{"build": ParseTemplateCommand("make other")}, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tm.Get("build"); got != "make other" {
+		t.Errorf("expected forced import to overwrite build, got %q", got)
+	}
+}
+
+func TestTemplateStoreConcurrentWrites(t *testing.T) {
+	store := &templateStore{source: TemplateSourceUser, file: filepath.Join(t.TempDir(), "templates.json")}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := store.update(func(templates map[string]Template) error {
+				templates[fmt.Sprintf("t%d", i)] = ParseTemplateCommand("echo")
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	templates, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 20 {
+		t.Errorf("expected 20 templates, got %d", len(templates))
+	}
+}
+
+func TestTemplateStoreLegacyFormat(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(file, []byte(`{"build": "make"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &templateStore{source: TemplateSourceUser, file: file}
+
+	if err := store.update(func(templates map[string]Template) error {
+		templates["test"] = ParseTemplateCommand("go test")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"version": 2`) || !strings.Contains(string(data), `"run": "make"`) {
+		t.Errorf("expected legacy store to be rewritten with a version, got %s", data)
+	}
+}
+
+func TestTemplateStoreCorruptRecovery(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "templates.json")
+	store := &templateStore{source: TemplateSourceUser, file: file}
+	if err := store.save(map[string]Template{"build": ParseTemplateCommand("make")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.save(map[string]Template{
+		"build": ParseTemplateCommand("make"),
+		"test":  ParseTemplateCommand("go test"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(`{"version": 1, "templ`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 1 || templates["build"].String() != "make" {
+		t.Errorf("expected templates restored from backup, got %v", templates)
+	}
+	corrupt, _ := filepath.Glob(file + ".corrupt-*")
+	if len(corrupt) != 1 {
+		t.Errorf("expected the corrupt store to be kept aside, got %v", corrupt)
+	}
+}
+
+func TestTemplateStoreNewerVersion(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(file, []byte(`{"version": 99, "templates": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := &templateStore{source: TemplateSourceUser, file: file}
+
+	if _, err := store.load(); err == nil || !strings.Contains(err.Error(), ErrTemplateStoreVersion.Error()) {
+		t.Errorf("expected version error, got %v", err)
+	}
+}
@@ -3,43 +3,170 @@ package cobra
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Modes of running test cases, see TestCase.Mode.
+const (
+	// TestModeInProcess runs the case against the root command, in the process of the program.
+	TestModeInProcess = "in-process"
+	// TestModeExec runs the case as an external process, for end-to-end checks.
+	TestModeExec = "exec"
+)
+
 type TestCase struct {
-	Name        string            `yaml:"name"`
-	Command     string            `yaml:"command"`
-	Args        []string          `yaml:"args,omitempty"`
-	Env         map[string]string `yaml:"env,omitempty"`
-	ExpectExit  int               `yaml:"expect_exit,omitempty"`
-	ExpectOutput string           `yaml:"expect_output,omitempty"`
-	ExpectError string            `yaml:"expect_error,omitempty"`
-	Mocks       map[string]string `yaml:"mocks,omitempty"`
+	Name string `yaml:"name"`
+	// Command is the program run in exec mode, or a shell command line if there are no Args.
+	// In in-process mode, it is empty or the name of the root command.
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args,omitempty"`
+	Env     map[string]string `yaml:"env,omitempty"`
+	// Stdin is the standard input of the command.
+	Stdin string `yaml:"stdin,omitempty"`
+	// Mode is TestModeInProcess or TestModeExec, and defaults to the mode of the suite. When
+	// neither is set, the cases run in-process unless their Command is another program.
+	Mode string `yaml:"mode,omitempty"`
+	// ExpectExit is the expected exit code. Any exit code is accepted if it is not set.
+	ExpectExit *int `yaml:"expect_exit,omitempty"`
+	// ExpectOutput and ExpectError are strings the standard and error outputs must contain.
+	ExpectOutput string `yaml:"expect_output,omitempty"`
+	ExpectError  string `yaml:"expect_error,omitempty"`
+	// Stdout and Stderr check the standard and error outputs.
+	Stdout *OutputAssertion `yaml:"stdout,omitempty"`
+	Stderr *OutputAssertion `yaml:"stderr,omitempty"`
+	// Files are checked after the run.
+	Files []FileAssertion `yaml:"files,omitempty"`
+	// ExpectGolden is the path of a file holding the expected standard output, relative to the
+	// test file. The output is normalized first, see normalizeTestOutput.
+	ExpectGolden string `yaml:"expect_golden,omitempty"`
+	// MaxDuration is the time the run may take at most, e.g. "500ms".
+	MaxDuration string `yaml:"max_duration,omitempty"`
+	// Mocks replace programs, by name, in a directory prepended to PATH. They run the program
+	// testing them, which must call RunTestMockIfRequested.
+	Mocks map[string]TestMock `yaml:"mocks,omitempty"`
+	// ExpectCalls are the arguments of the expected calls of mocks, by mock name, e.g.
+	// {kubectl: [[apply, -f, app.yaml]]}. Mocks not listed may be called any number of times.
+	ExpectCalls map[string][][]string `yaml:"expect_calls,omitempty"`
+	// Fixtures are files written to the working directory of the case before it runs, by
+	// path relative to it. Every case runs in a temporary directory of its own.
+	Fixtures map[string]string `yaml:"fixtures,omitempty"`
+	// Setup and Teardown are shell commands run in the working directory of the case, before
+	// and after it. Teardown runs even if the case failed.
+	Setup    []string `yaml:"setup,omitempty"`
+	Teardown []string `yaml:"teardown,omitempty"`
+	// Matrix expands the case into a case for each combination of the values of its
+	// variables. The strings of the case are rendered with text/template, so a variable
+	// named "os" is referenced as {{.os}}.
+	Matrix map[string][]string `yaml:"matrix,omitempty"`
 }
 
 type TestSuite struct {
-	Tests []TestCase `yaml:"tests"`
+	// Mode is the default mode of the cases.
+	Mode string `yaml:"mode,omitempty"`
+	// Setup and Teardown are shell commands run in the directory of the test file, before the
+	// first case and after the last one.
+	Setup    []string `yaml:"setup,omitempty"`
+	Teardown []string `yaml:"teardown,omitempty"`
+	// Parallel runs the cases on Workers goroutines, GOMAXPROCS by default. In-process cases
+	// share the root command and still run one at a time.
+	Parallel bool       `yaml:"parallel,omitempty"`
+	Workers  int        `yaml:"workers,omitempty"`
+	Tests    []TestCase `yaml:"tests"`
+}
+
+// testResult is the outcome of running a test case.
+type testResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	// Dir is the working directory of the case, which the paths of FileAssertions are
+	// relative to.
+	Dir string
 }
 
 func CreateTestCommand() *Command {
+	var opts testOptions
 	cmd := &Command{
 		Use:   "test <file>",
 		Short: "Run tests from YAML file",
-		Args:  ExactArgs(1),
+		Long: `Run the test cases of a YAML file, and fail if any of them fails.
+
+Cases run in-process, against the commands of this program, or in exec mode, as external
+processes, depending on their mode. In-process cases start from the default values of the
+flags, with an empty analytics database, whatever the cases before them did.
+
+The output of a case can be compared with a golden file, see expect_golden, after replacing
+temporary paths, timestamps and terminal escape codes with placeholders. Run with --update
+to write the golden files instead.
+
+Reports are written as JUnit XML or JSON, depending on the extension of the file, with the
+outcome, duration and output of each case.`,
+		Args:         ExactArgs(1),
+		SilenceUsage: true,
 		RunE: func(cmd *Command, args []string) error {
-			return runTests(args[0])
+			return runTests(cmd, args[0], opts)
 		},
 	}
+	cmd.Flags().BoolVar(&opts.update, "update", false, "write the golden files instead of comparing the output with them")
+	cmd.Flags().StringVar(&opts.run, "run", "", "only run the cases whose name matches this regular expression")
+	cmd.Flags().StringArrayVar(&opts.reports, "report", nil, "write a report to this .xml (JUnit) or .json file, can be repeated")
 	return cmd
 }
 
-func runTests(filename string) error {
+// testOptions are the options of the test command.
+type testOptions struct {
+	update  bool
+	run     string
+	reports []string
+}
+
+// testRunner runs the cases of a test file.
+type testRunner struct {
+	root *Command
+	// dir is the directory of the test file, which golden files are relative to.
+	dir string
+	// update writes the golden files instead of comparing them.
+	update bool
+	// environ is the environment of the program when the run started. In-process cases
+	// change the environment of the program while they run, the other cases start from this.
+	environ []string
+	// inProcess serializes the in-process cases.
+	inProcess sync.Mutex
+}
+
+// testOutcome is the outcome of a case run by a worker.
+type testOutcome struct {
+	result testResult
+	err    error
+	done   chan struct{}
+}
+
+func runTests(cmd *Command, filename string, opts testOptions) error {
+	var match *regexp.Regexp
+	if opts.run != "" {
+		var err error
+		if match, err = regexp.Compile(opts.run); err != nil {
+			return fmt.Errorf("invalid --run: %v", err)
+		}
+	}
+	for _, file := range opts.reports {
+		if _, err := testReportFormat(file); err != nil {
+			return err
+		}
+	}
+
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
@@ -50,54 +177,182 @@ func runTests(filename string) error {
 	if err != nil {
 		return err
 	}
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return err
+	}
 
-	passed := 0
-	failed := 0
-
+	var tests []TestCase
 	for _, test := range suite.Tests {
-		fmt.Printf("Running test: %s\n", test.Name)
-		err := runTestCase(test)
+		expanded, err := expandTestMatrix(test)
 		if err != nil {
-			fmt.Printf("  FAILED: %v\n", err)
-			failed++
-		} else {
-			fmt.Printf("  PASSED\n")
-			passed++
+			return fmt.Errorf("test %s: %v", test.Name, err)
+		}
+		for _, test := range expanded {
+			if match != nil && !match.MatchString(test.Name) {
+				continue
+			}
+			if test.Mode == "" {
+				test.Mode = suite.Mode
+			}
+			tests = append(tests, test)
 		}
 	}
 
-	fmt.Printf("\nResults: %d passed, %d failed\n", passed, failed)
-	return nil
-}
+	// In-process cases redirect the output of the root command, print with the writer the
+	// test command was called with.
+	out := cmd.OutOrStdout()
+	r := &testRunner{root: cmd.Root(), dir: dir, update: opts.update, environ: os.Environ()}
+	state := saveTestState(r.root)
+	defer state.restore()
 
-func runTestCase(test TestCase) error {
-	// Set up environment
-	env := os.Environ()
-	for k, v := range test.Env {
-		env = append(env, k+"="+v)
+	if err := r.runSteps("setup", suite.Setup, dir, nil); err != nil {
+		return errors.Join(err, r.runSteps("teardown", suite.Teardown, dir, nil))
 	}
 
-	// Set up mocks
-	if len(test.Mocks) > 0 {
-		mockDir, err := os.MkdirTemp("", "cobra_test_mocks")
+	workers := 1
+	if suite.Parallel {
+		workers = suite.Workers
+		if workers <= 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+	}
+	outcomes := make([]testOutcome, len(tests))
+	for i := range outcomes {
+		outcomes[i].done = make(chan struct{})
+	}
+	jobs := make(chan int)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				outcomes[i].result, outcomes[i].err = r.runCase(tests[i])
+				close(outcomes[i].done)
+			}
+		}()
+	}
+	go func() {
+		for i := range tests {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	// Print the outcomes in the order of the cases, whichever finishes first
+	report := &testReport{File: filename}
+	start := time.Now()
+	for i, test := range tests {
+		fmt.Fprintf(out, "Running test: %s\n", test.Name)
+		<-outcomes[i].done
+		result, err := outcomes[i].result, outcomes[i].err
+		report.add(test, result, err)
 		if err != nil {
-			return err
+			// Indent the lines of errors listing several failures
+			fmt.Fprintf(out, "  FAILED: %s\n", strings.ReplaceAll(err.Error(), "\n", "\n    "))
+		} else {
+			fmt.Fprintf(out, "  PASSED\n")
 		}
-		defer os.RemoveAll(mockDir)
+	}
+	report.Duration = time.Since(start).Seconds()
 
-		for mockCmd, mockScript := range test.Mocks {
-			mockPath := filepath.Join(mockDir, mockCmd)
-			err := os.WriteFile(mockPath, []byte(mockScript), 0755)
-			if err != nil {
-				return err
-			}
+	fmt.Fprintf(out, "\nResults: %d passed, %d failed\n", report.Passed, report.Failed)
+	var errs []error
+	for _, file := range opts.reports {
+		if err := report.write(file); err != nil {
+			errs = append(errs, fmt.Errorf("cannot write the report %s: %v", file, err))
 		}
+	}
+	if report.Failed > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d tests failed", report.Failed, report.Passed+report.Failed))
+	}
+	errs = append(errs, r.runSteps("teardown", suite.Teardown, dir, nil))
+	return errors.Join(errs...)
+}
+
+// runCase runs test, and returns its result and the reason it failed, if it did.
+func (r *testRunner) runCase(test TestCase) (result testResult, err error) {
+	workDir, err := os.MkdirTemp("", "cobra_test_case")
+	if err != nil {
+		return testResult{}, err
+	}
+	defer os.RemoveAll(workDir)
+	if err := writeTestFixtures(workDir, test.Fixtures); err != nil {
+		return testResult{}, err
+	}
+	env, mocks, err := r.testCaseEnv(test)
+	if err != nil {
+		return testResult{}, err
+	}
+	if mocks != nil {
+		defer mocks.remove()
+	}
+
+	if err := r.runSteps("setup", test.Setup, workDir, env); err != nil {
+		return testResult{}, errors.Join(err, r.runSteps("teardown", test.Teardown, workDir, env))
+	}
+	defer func() {
+		err = errors.Join(err, r.runSteps("teardown", test.Teardown, workDir, env))
+	}()
+
+	switch mode := testCaseMode(r.root, test); mode {
+	case TestModeInProcess:
+		r.inProcess.Lock()
+		result, err = runInProcess(r.root, test, workDir, env)
+		r.inProcess.Unlock()
+	case TestModeExec:
+		result, err = runExternal(test, workDir, testEnviron(r.environ, env))
+	default:
+		err = fmt.Errorf("unknown mode %q, expected %s or %s", mode, TestModeInProcess, TestModeExec)
+	}
+	if err != nil {
+		return result, err
+	}
+	err = checkTestResult(test, result)
+	if test.ExpectGolden != "" {
+		err = errors.Join(err, r.checkGolden(test.ExpectGolden, result.Stdout))
+	}
+	if len(test.ExpectCalls) > 0 {
+		err = errors.Join(err, mocks.checkCalls(test.ExpectCalls))
+	}
+	return result, err
+}
 
-		// Prepend mock dir to PATH
-		env = append(env, "PATH="+mockDir+":"+os.Getenv("PATH"))
+func testCaseMode(root *Command, test TestCase) string {
+	switch {
+	case test.Mode != "":
+		return test.Mode
+	case test.Command == "" || test.Command == root.Name():
+		return TestModeInProcess
+	}
+	return TestModeExec
+}
+
+// testCaseEnv returns the environment variables set for the case, including the PATH
+// leading to its mocks, and the mocks, if any.
+func (r *testRunner) testCaseEnv(test TestCase) (map[string]string, *testMocks, error) {
+	env := make(map[string]string, len(test.Env)+2)
+	for k, v := range test.Env {
+		env[k] = v
+	}
+	if len(test.Mocks) == 0 {
+		return env, nil, nil
 	}
 
-	// Prepare command
+	mocks, err := setupTestMocks(test.Mocks)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Prepend mock dir to PATH
+	path, ok := env["PATH"]
+	if !ok {
+		path = lookupEnviron(r.environ, "PATH")
+	}
+	env["PATH"] = mocks.dir + string(os.PathListSeparator) + path
+	env[testMockEnvVar] = mocks.dir
+	return env, mocks, nil
+}
+
+// runExternal runs the case as an external process in dir, with the environment environ.
+func runExternal(test TestCase, dir string, environ []string) (testResult, error) {
 	var cmd *exec.Cmd
 	if len(test.Args) > 0 {
 		cmd = exec.Command(test.Command, test.Args...)
@@ -106,7 +361,9 @@ func runTestCase(test TestCase) error {
 		cmd = exec.Command("sh", "-c", test.Command)
 	}
 
-	cmd.Env = env
+	cmd.Dir = dir
+	cmd.Env = environ
+	cmd.Stdin = strings.NewReader(test.Stdin)
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
@@ -114,30 +371,15 @@ func runTestCase(test TestCase) error {
 	cmd.Stderr = &stderr
 
 	// Run
+	start := time.Now()
 	err := cmd.Run()
-	exitCode := 0
+	result := testResult{Stdout: stdout.String(), Stderr: stderr.String(), Duration: time.Since(start), Dir: dir}
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
+			result.ExitCode = exitErr.ExitCode()
 		} else {
-			return fmt.Errorf("failed to run command: %v", err)
+			return result, fmt.Errorf("failed to run command: %v", err)
 		}
 	}
-
-	// Check expectations
-	if test.ExpectExit != 0 && exitCode != test.ExpectExit {
-		return fmt.Errorf("expected exit code %d, got %d", test.ExpectExit, exitCode)
-	}
-
-	outStr := stdout.String()
-	if test.ExpectOutput != "" && !strings.Contains(outStr, test.ExpectOutput) {
-		return fmt.Errorf("expected output to contain '%s', got '%s'", test.ExpectOutput, outStr)
-	}
-
-	errStr := stderr.String()
-	if test.ExpectError != "" && !strings.Contains(errStr, test.ExpectError) {
-		return fmt.Errorf("expected error to contain '%s', got '%s'", test.ExpectError, errStr)
-	}
-
-	return nil
-}
\ No newline at end of file
+	return result, nil
+}
@@ -0,0 +1,253 @@
+// Assertions on the results of test cases
+package cobra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestStrings is a list of strings, which may be written as a single string in test files.
+type TestStrings []string
+
+// UnmarshalYAML accepts both a string and a list of strings.
+func (s *TestStrings) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*s = TestStrings{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// OutputAssertion checks an output of a test case, or the content of a file. All set fields
+// must match.
+type OutputAssertion struct {
+	// Equals is the exact expected content.
+	Equals *string `yaml:"equals,omitempty"`
+	// Contains are strings the content must contain.
+	Contains TestStrings `yaml:"contains,omitempty"`
+	// NotContains are strings the content must not contain.
+	NotContains TestStrings `yaml:"not_contains,omitempty"`
+	// Matches is a regular expression the content must match.
+	Matches string `yaml:"matches,omitempty"`
+	// JSON maps paths in the content parsed as JSON, such as "items[0].name" or "$.count", to
+	// their expected values.
+	JSON map[string]interface{} `yaml:"json,omitempty"`
+}
+
+// FileAssertion checks a file after a test case ran.
+type FileAssertion struct {
+	// Path is the path of the file, relative to the working directory of the case.
+	Path string `yaml:"path"`
+	// Exists is whether the file must exist, and defaults to true.
+	Exists *bool `yaml:"exists,omitempty"`
+	// The content of the file is checked like an output.
+	OutputAssertion `yaml:",inline"`
+}
+
+func checkTestResult(test TestCase, result testResult) error {
+	var failures []error
+	fail := func(format string, a ...interface{}) {
+		failures = append(failures, fmt.Errorf(format, a...))
+	}
+
+	if test.ExpectExit != nil && result.ExitCode != *test.ExpectExit {
+		fail("expected exit code %d, got %d", *test.ExpectExit, result.ExitCode)
+	}
+
+	if test.ExpectOutput != "" && !strings.Contains(result.Stdout, test.ExpectOutput) {
+		fail("expected output to contain '%s', got '%s'", test.ExpectOutput, result.Stdout)
+	}
+
+	if test.ExpectError != "" && !strings.Contains(result.Stderr, test.ExpectError) {
+		fail("expected error to contain '%s', got '%s'", test.ExpectError, result.Stderr)
+	}
+
+	test.Stdout.check("output", result.Stdout, fail)
+	test.Stderr.check("error output", result.Stderr, fail)
+	for _, f := range test.Files {
+		f.check(result.Dir, fail)
+	}
+
+	if test.MaxDuration != "" {
+		limit, err := parseStepDuration(test.MaxDuration, 0)
+		switch {
+		case err != nil:
+			fail("invalid max_duration: %v", err)
+		case result.Duration > limit:
+			fail("expected to run within %s, took %s", limit, result.Duration.Round(time.Millisecond))
+		}
+	}
+
+	return errors.Join(failures...)
+}
+
+// check checks content, described by name in the failures, if a is set.
+func (a *OutputAssertion) check(name, content string, fail func(string, ...interface{})) {
+	if a == nil {
+		return
+	}
+	if a.Equals != nil && content != *a.Equals {
+		fail("expected %s to equal %q, got %q", name, *a.Equals, content)
+	}
+	for _, s := range a.Contains {
+		if !strings.Contains(content, s) {
+			fail("expected %s to contain %q, got %q", name, s, content)
+		}
+	}
+	for _, s := range a.NotContains {
+		if strings.Contains(content, s) {
+			fail("expected %s not to contain %q, got %q", name, s, content)
+		}
+	}
+	if a.Matches != "" {
+		re, err := regexp.Compile(a.Matches)
+		switch {
+		case err != nil:
+			fail("invalid regular expression for %s: %v", name, err)
+		case !re.MatchString(content):
+			fail("expected %s to match /%s/, got %q", name, a.Matches, content)
+		}
+	}
+	if len(a.JSON) == 0 {
+		return
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(content), &doc); err != nil {
+		fail("expected %s to be JSON: %v", name, err)
+		return
+	}
+	paths := make([]string, 0, len(a.JSON))
+	for path := range a.JSON {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		value, err := lookupJSONPath(doc, path)
+		if err != nil {
+			fail("%s of %s: %v", path, name, err)
+			continue
+		}
+		expected, err := normalizeJSON(a.JSON[path])
+		if err != nil {
+			fail("invalid expected value for %s of %s: %v", path, name, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, value) {
+			fail("expected %s of %s to be %s, got %s", path, name, formatJSON(expected), formatJSON(value))
+		}
+	}
+}
+
+// check checks the file, at a path relative to dir.
+func (f FileAssertion) check(dir string, fail func(string, ...interface{})) {
+	name := "file " + f.Path
+	exists := f.Exists == nil || *f.Exists
+	path := f.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if exists {
+			fail("expected %s to exist", name)
+		}
+		return
+	case !exists:
+		fail("expected %s not to exist", name)
+		return
+	case err != nil:
+		fail("cannot read %s: %v", name, err)
+		return
+	}
+	f.OutputAssertion.check(name, string(content), fail)
+}
+
+// lookupJSONPath returns the value at path in doc, a document decoded by encoding/json. Paths
+// are made of field names and array indexes, such as "items[0].name", and may start with "$".
+func lookupJSONPath(doc interface{}, path string) (interface{}, error) {
+	value := doc
+	rest := strings.TrimPrefix(path, "$")
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+	at := "$"
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path: missing ] after %s", at)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path: index %q after %s", rest[1:end], at)
+			}
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an array", at)
+			}
+			if index < 0 || index >= len(array) {
+				return nil, fmt.Errorf("%s has %d elements, no index %d", at, len(array), index)
+			}
+			value, at, rest = array[index], at+rest[:end+1], rest[end+1:]
+			continue
+		}
+
+		if rest[0] != '.' {
+			return nil, fmt.Errorf("invalid path: expected . or [ after %s", at)
+		}
+		end := strings.IndexAny(rest[1:], ".[") + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		key := rest[1:end]
+		if key == "" {
+			return nil, fmt.Errorf("invalid path: empty field name after %s", at)
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is not an object", at)
+		}
+		if value, ok = object[key]; !ok {
+			return nil, fmt.Errorf("%s has no field %q", at, key)
+		}
+		at, rest = at+rest[:end], rest[end:]
+	}
+	return value, nil
+}
+
+// normalizeJSON converts a value decoded from YAML to the value encoding/json would decode.
+func normalizeJSON(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var normalized interface{}
+	err = json.Unmarshal(data, &normalized)
+	return normalized, err
+}
+
+func formatJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
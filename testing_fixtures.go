@@ -0,0 +1,195 @@
+// Working directories, setup steps and matrices of test cases
+package cobra
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeTestFixtures writes the files of fixtures, by path relative to dir.
+func writeTestFixtures(dir string, fixtures map[string]string) error {
+	for name, content := range fixtures {
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("fixture %s is not within the working directory", name)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSteps runs the shell commands of a setup or teardown in dir, with env added to the
+// environment of the runner. It stops at the first command that fails.
+func (r *testRunner) runSteps(kind string, steps []string, dir string, env map[string]string) error {
+	for _, step := range steps {
+		cmd := exec.Command("sh", "-c", step)
+		cmd.Dir = dir
+		cmd.Env = testEnviron(r.environ, env)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			if output = bytes.TrimSpace(output); len(output) > 0 {
+				return fmt.Errorf("%s %q failed: %v: %s", kind, step, err, output)
+			}
+			return fmt.Errorf("%s %q failed: %v", kind, step, err)
+		}
+	}
+	return nil
+}
+
+// testEnviron returns environ with the variables of env set.
+func testEnviron(environ []string, env map[string]string) []string {
+	result := make([]string, 0, len(environ)+len(env))
+	for _, kv := range environ {
+		k, _, _ := strings.Cut(kv, "=")
+		if _, ok := env[k]; !ok {
+			result = append(result, kv)
+		}
+	}
+	for k, v := range env {
+		result = append(result, k+"="+v)
+	}
+	return result
+}
+
+// lookupEnviron returns the value of the variable key in environ.
+func lookupEnviron(environ []string, key string) string {
+	for _, kv := range environ {
+		if k, v, _ := strings.Cut(kv, "="); k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+// expandTestMatrix returns the cases test expands into: a case for each combination of the
+// values of its matrix, with its strings rendered with the values, or test itself if it has no
+// matrix. The names of the cases that do not reference the variables are suffixed with them.
+func expandTestMatrix(test TestCase) ([]TestCase, error) {
+	if len(test.Matrix) == 0 {
+		return []TestCase{test}, nil
+	}
+	vars := make([]string, 0, len(test.Matrix))
+	for name, values := range test.Matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix variable %s has no values", name)
+		}
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+
+	combinations := []map[string]string{{}}
+	for _, name := range vars {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range test.Matrix[name] {
+				c := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					c[k] = v
+				}
+				c[name] = value
+				next = append(next, c)
+			}
+		}
+		combinations = next
+	}
+
+	base := test
+	base.Matrix = nil
+	data, err := yaml.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	var cases []TestCase
+	for _, combination := range combinations {
+		// Render a copy, the cases would share the maps and slices of base
+		var test TestCase
+		if err := yaml.Unmarshal(data, &test); err != nil {
+			return nil, err
+		}
+		if err := renderTestStrings(reflect.ValueOf(&test).Elem(), combination); err != nil {
+			return nil, err
+		}
+		if test.Name == base.Name {
+			values := make([]string, len(vars))
+			for i, name := range vars {
+				values[i] = name + "=" + combination[name]
+			}
+			test.Name += " (" + strings.Join(values, ", ") + ")"
+		}
+		cases = append(cases, test)
+	}
+	return cases, nil
+}
+
+// renderTestStrings renders the strings held by v, such as the fields of a case and the
+// elements and values of its slices and maps, as templates executed with data.
+func renderTestStrings(v reflect.Value, data map[string]string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !strings.Contains(v.String(), "{{") {
+			return nil
+		}
+		tmpl, err := template.New("").Option("missingkey=error").Parse(v.String())
+		if err != nil {
+			return err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return err
+		}
+		v.SetString(b.String())
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return renderTestStrings(v.Elem(), data)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// The values held by interfaces cannot be set, render a copy
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := renderTestStrings(elem, data); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				if err := renderTestStrings(v.Field(i), data); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := renderTestStrings(v.Index(i), data); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			if err := renderTestStrings(value, data); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Golden files of test cases
+package cobra
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/Pritam499/clifusion/internal/diff"
+)
+
+var (
+	// ansiEscapeRegexp matches the escape sequences of terminals, for colors and hyperlinks.
+	ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)`)
+	// timestampRegexp matches RFC 3339 timestamps and those formatted by time.Time.String.
+	timestampRegexp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?( ?(Z|[+-]\d{2}:?\d{2})( [A-Z]{2,5})?)?`)
+)
+
+// normalizeTestOutput replaces the parts of output that change from run to run with
+// placeholders: temporary paths with <TMP> followed by their path below the temporary
+// directory created for the run, and timestamps with <TIMESTAMP>. Terminal escape sequences
+// are removed, and line endings converted to "\n".
+func normalizeTestOutput(output string) string {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	output = ansiEscapeRegexp.ReplaceAllString(output, "")
+	output = tempPathRegexp().ReplaceAllString(output, "<TMP>")
+	return timestampRegexp.ReplaceAllString(output, "<TIMESTAMP>")
+}
+
+// tempPathRegexp matches the temporary directory followed by the name of a directory in it.
+func tempPathRegexp() *regexp.Regexp {
+	dirs := []string{os.TempDir()}
+	if resolved, err := filepath.EvalSymlinks(dirs[0]); err == nil && resolved != dirs[0] {
+		dirs = append(dirs, resolved)
+	}
+	for i, dir := range dirs {
+		dirs[i] = regexp.QuoteMeta(strings.TrimRight(dir, `/\`))
+	}
+	return regexp.MustCompile(`(` + strings.Join(dirs, "|") + `)[/\\][^\s/\\"']+`)
+}
+
+// checkGolden compares output, normalized, with the golden file, or writes the golden file in
+// update mode.
+func (r *testRunner) checkGolden(file, output string) error {
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.dir, path)
+	}
+	output = normalizeTestOutput(output)
+	if r.update {
+		return writeFileAtomic(path, []byte(output), 0644)
+	}
+
+	golden, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("golden file %s does not exist, run with --update to create it", file)
+	}
+	if err != nil {
+		return err
+	}
+	if expected := strings.ReplaceAll(string(golden), "\r\n", "\n"); expected != output {
+		return fmt.Errorf("output differs from golden file %s, run with --update to update it:\n%s",
+			file, strings.TrimSuffix(diff.Unified(file, "output", expected, output), "\n"))
+	}
+	return nil
+}
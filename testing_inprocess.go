@@ -0,0 +1,184 @@
+// In-process execution of test cases
+package cobra
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	flag "github.com/spf13/pflag"
+)
+
+// runInProcess executes the case against root in dir, capturing its output. The flags of the
+// command tree are reset to their defaults and the case records its analytics in a database
+// of its own, so that cases do not depend on each other.
+func runInProcess(root *Command, test TestCase, dir string, env map[string]string) (testResult, error) {
+	if test.Command != "" && test.Command != root.Name() {
+		return testResult{}, fmt.Errorf("in-process cases run %s, not %s", root.Name(), test.Command)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return testResult{}, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return testResult{}, err
+	}
+	defer os.Chdir(wd)
+	restoreEnv := setTestEnv(env)
+	defer restoreEnv()
+	closeAnalytics := IsolateAnalytics()
+	defer closeAnalytics()
+	visitTreeFlags(root, func(f *flag.Flag) {
+		defaultFlagState(f).apply(f)
+	})
+
+	var stdout, stderr bytes.Buffer
+	root.SetArgs(append([]string{}, test.Args...))
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+	root.SetIn(strings.NewReader(test.Stdin))
+
+	start := time.Now()
+	_, err = root.ExecuteC()
+	return testResult{
+		ExitCode: testExitCode(err),
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(start),
+		Dir:      dir,
+	}, nil
+}
+
+// testExitCode returns the exit code of a program whose root command returned err.
+func testExitCode(err error) int {
+	var exitErr *ExecPluginExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	}
+	return 1
+}
+
+// setTestEnv sets the environment variables of env, and returns a function restoring them.
+func setTestEnv(env map[string]string) func() {
+	type previous struct {
+		value string
+		set   bool
+	}
+	saved := make(map[string]previous, len(env))
+	for k, v := range env {
+		value, set := os.LookupEnv(k)
+		saved[k] = previous{value, set}
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, p := range saved {
+			if p.set {
+				os.Setenv(k, p.value)
+			} else {
+				os.Unsetenv(k)
+			}
+		}
+	}
+}
+
+// testState is the state of the program changed by in-process test cases.
+type testState struct {
+	root      *Command
+	args      []string
+	in        io.Reader
+	out, err  io.Writer
+	flags     map[*flag.Flag]flagState
+	analytics *AnalyticsDB
+}
+
+func saveTestState(root *Command) *testState {
+	s := &testState{
+		root:      root,
+		args:      root.args,
+		in:        root.inReader,
+		out:       root.outWriter,
+		err:       root.errWriter,
+		flags:     make(map[*flag.Flag]flagState),
+		analytics: GlobalAnalyticsDB,
+	}
+	visitTreeFlags(root, func(f *flag.Flag) {
+		s.flags[f] = currentFlagState(f)
+	})
+	return s
+}
+
+// restore restores the state saved, including the flags of the commands that existed then.
+func (s *testState) restore() {
+	s.root.args = s.args
+	s.root.inReader = s.in
+	s.root.outWriter = s.out
+	s.root.errWriter = s.err
+	for f, state := range s.flags {
+		state.apply(f)
+	}
+	GlobalAnalyticsDB = s.analytics
+}
+
+// visitTreeFlags calls fn once for each flag of root and its subcommands.
+func visitTreeFlags(root *Command, fn func(*flag.Flag)) {
+	seen := make(map[*flag.Flag]bool)
+	visit := func(f *flag.Flag) {
+		if !seen[f] {
+			seen[f] = true
+			fn(f)
+		}
+	}
+	var walk func(cmd *Command)
+	walk = func(cmd *Command) {
+		cmd.Flags().VisitAll(visit)
+		cmd.PersistentFlags().VisitAll(visit)
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+}
+
+// flagState is the value of a flag, and whether it was set on the command line.
+type flagState struct {
+	value   string
+	slice   []string
+	changed bool
+}
+
+func currentFlagState(f *flag.Flag) flagState {
+	s := flagState{value: f.Value.String(), changed: f.Changed}
+	if sv, ok := f.Value.(flag.SliceValue); ok {
+		s.slice = sv.GetSlice()
+	}
+	return s
+}
+
+func defaultFlagState(f *flag.Flag) flagState {
+	s := flagState{value: f.DefValue}
+	if _, ok := f.Value.(flag.SliceValue); ok {
+		// Slices are appended to when set, their default is formatted as "[a,b]"
+		s.slice = []string{}
+		if def := strings.TrimSuffix(strings.TrimPrefix(f.DefValue, "["), "]"); def != "" {
+			s.slice = strings.Split(def, ",")
+		}
+	}
+	return s
+}
+
+func (s flagState) apply(f *flag.Flag) {
+	if sv, ok := f.Value.(flag.SliceValue); ok {
+		_ = sv.Replace(s.slice)
+	} else {
+		_ = f.Value.Set(s.value)
+	}
+	f.Changed = s.changed
+}
@@ -0,0 +1,373 @@
+// Mocks of the programs called by test cases
+package cobra
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testMockEnvVar holds the directory of the mocks of a test case, in the environment of the
+// case. A program started from that directory under the name of a mock acts as the mock
+// instead, see RunTestMockIfRequested.
+const testMockEnvVar = "COBRA_TEST_MOCKS"
+
+// TestMock is a program replaced during a test case. The mock records each call, with its
+// arguments, standard input and environment, see TestCase.ExpectCalls, and responds with
+// Responses or runs Script.
+//
+// In test files, a mock is either a script, as a string, or its responses:
+//
+//	mocks:
+//	  kubectl:
+//	    responses:
+//	    - stdout: "deployment.apps/web created\n"
+//	    - stderr: "error: already exists\n"
+//	      exit: 1
+//	  git:
+//	    stdout: "main\n"
+type TestMock struct {
+	// Script is the content of an executable file run on each call.
+	Script string `yaml:"-"`
+	// MockResponse is the response to every call, if there are no Responses.
+	MockResponse `yaml:",inline"`
+	// Responses are the responses to the successive calls, the last one is repeated.
+	Responses []MockResponse `yaml:"responses,omitempty"`
+}
+
+// MockResponse is the response of a mock to a call.
+type MockResponse struct {
+	Stdout string `json:"stdout,omitempty" yaml:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty" yaml:"stderr,omitempty"`
+	// Exit is the exit code.
+	Exit int `json:"exit,omitempty" yaml:"exit,omitempty"`
+}
+
+// MockCall is a call of a mock, as recorded by the mock.
+type MockCall struct {
+	Args  []string          `json:"args"`
+	Stdin string            `json:"stdin,omitempty"`
+	Env   map[string]string `json:"env,omitempty"`
+}
+
+// UnmarshalYAML accepts both a script and the responses of the mock.
+func (m *TestMock) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*m = TestMock{Script: node.Value}
+		return nil
+	}
+	type plain TestMock
+	return node.Decode((*plain)(m))
+}
+
+// MarshalYAML encodes mocks with a script as the script.
+func (m TestMock) MarshalYAML() (interface{}, error) {
+	if m.Script != "" {
+		return m.Script, nil
+	}
+	type plain TestMock
+	return plain(m), nil
+}
+
+// testMocks are the mocks of a test case, in a directory prepended to PATH. The directory
+// holds an executable per mock, which is the program itself, and a configuration and a log
+// of the calls per mock in the .mocks directory.
+type testMocks struct {
+	dir   string
+	names []string
+}
+
+// testMockConfig is the configuration read by the processes acting as a mock.
+type testMockConfig struct {
+	// Script is the path of the script of the mock, if any.
+	Script    string         `json:"script,omitempty"`
+	Responses []MockResponse `json:"responses"`
+}
+
+func setupTestMocks(mocks map[string]TestMock) (*testMocks, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot mock programs: %v", err)
+	}
+	dir, err := os.MkdirTemp("", "cobra_test_mocks")
+	if err != nil {
+		return nil, err
+	}
+	m := &testMocks{dir: dir}
+	if err := m.setup(exe, mocks); err != nil {
+		m.remove()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *testMocks) setup(exe string, mocks map[string]TestMock) error {
+	if err := os.Mkdir(filepath.Join(m.dir, ".mocks"), 0755); err != nil {
+		return err
+	}
+	for name, mock := range mocks {
+		if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid mock name %q", name)
+		}
+		config := testMockConfig{Responses: mock.Responses}
+		if len(config.Responses) == 0 {
+			config.Responses = []MockResponse{mock.MockResponse}
+		}
+		if mock.Script != "" {
+			config.Script = m.file(name, ".script")
+			if err := os.WriteFile(config.Script, []byte(mock.Script), 0755); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(config)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(m.file(name, ".json"), data, 0644); err != nil {
+			return err
+		}
+		if err := linkExecutable(exe, filepath.Join(m.dir, name+executableSuffix())); err != nil {
+			return err
+		}
+		m.names = append(m.names, name)
+	}
+	sort.Strings(m.names)
+	return nil
+}
+
+// file returns the path of a file of the mock name in the .mocks directory.
+func (m *testMocks) file(name, ext string) string {
+	return filepath.Join(m.dir, ".mocks", name+ext)
+}
+
+func (m *testMocks) remove() {
+	os.RemoveAll(m.dir)
+}
+
+func executableSuffix() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// linkExecutable makes file an executable running exe, linking to it if possible.
+func linkExecutable(exe, file string) error {
+	if err := os.Symlink(exe, file); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(exe)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0755)
+}
+
+// calls returns the calls recorded by the mock name.
+func (m *testMocks) calls(name string) ([]MockCall, error) {
+	f, err := os.Open(m.file(name, ".calls"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var calls []MockCall
+	decoder := json.NewDecoder(f)
+	for {
+		var call MockCall
+		if err := decoder.Decode(&call); err == io.EOF {
+			return calls, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid call log of mock %s: %v", name, err)
+		}
+		calls = append(calls, call)
+	}
+}
+
+// checkCalls compares the arguments of the calls of the mocks with expected, by mock name.
+func (m *testMocks) checkCalls(expected map[string][][]string) error {
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []error
+	for _, name := range names {
+		if m == nil || !stringInSlice(name, m.names) {
+			failures = append(failures, fmt.Errorf("expected calls of %s, which is not mocked", name))
+			continue
+		}
+		calls, err := m.calls(name)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		got := make([][]string, len(calls))
+		for i, call := range calls {
+			got[i] = call.Args
+		}
+		if !sameCalls(expected[name], got) {
+			failures = append(failures, fmt.Errorf("expected calls of %s:%s\ngot:%s",
+				name, formatCalls(name, expected[name]), formatCalls(name, got)))
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// sameCalls compares the arguments of calls, considering no arguments and nil equal.
+func sameCalls(expected, got [][]string) bool {
+	if len(expected) != len(got) {
+		return false
+	}
+	for i := range expected {
+		if len(expected[i]) != len(got[i]) || len(got[i]) > 0 && !reflect.DeepEqual(expected[i], got[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatCalls(name string, calls [][]string) string {
+	if len(calls) == 0 {
+		return " no calls"
+	}
+	var b strings.Builder
+	for _, args := range calls {
+		b.WriteString("\n  ")
+		b.WriteString(quoteArgs(append([]string{name}, args...)))
+	}
+	return b.String()
+}
+
+// RunTestMockIfRequested makes the program act as a mock of the test case being run, and exit,
+// if it was started as one. The mocks of a test case run the program testing it, which is the
+// program tested or, for cases run in process, the test binary. Programs mocking commands in
+// their test files therefore call it first, in main or in TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		cobra.RunTestMockIfRequested()
+//		os.Exit(m.Run())
+//	}
+//
+// It returns without doing anything otherwise.
+func RunTestMockIfRequested() {
+	dir := os.Getenv(testMockEnvVar)
+	if dir == "" {
+		return
+	}
+	// The processes started by the mock, or by the program when it is not a mock, are not mocks
+	os.Unsetenv(testMockEnvVar)
+	if code, ok := runTestMock(dir, os.Args); ok {
+		os.Exit(code)
+	}
+}
+
+// runTestMock acts as the mock of the test case whose mocks are in dir, if the program was
+// called under the name of one of them, and returns the exit code of the mock. It reports
+// false if the program is not a mock, such as the program tested calling itself.
+func runTestMock(dir string, args []string) (int, bool) {
+	name := strings.TrimSuffix(filepath.Base(args[0]), executableSuffix())
+	m := &testMocks{dir: dir}
+	data, err := os.ReadFile(m.file(name, ".json"))
+	if err != nil {
+		return 0, false
+	}
+	var config testMockConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid mock %s: %v\n", name, err)
+		return 1, true
+	}
+
+	call := MockCall{Args: args[1:], Env: make(map[string]string)}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		call.Env[k] = v
+	}
+	// Do not wait for the input of a terminal
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		stdin, _ := io.ReadAll(os.Stdin)
+		call.Stdin = string(stdin)
+	}
+	n, err := m.record(name, call)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mock %s cannot record the call: %v\n", name, err)
+		return 1, true
+	}
+
+	if config.Script != "" {
+		return runMockScript(config.Script, call), true
+	}
+	response := config.Responses[min(n, len(config.Responses)-1)]
+	_, _ = io.WriteString(os.Stdout, response.Stdout)
+	_, _ = io.WriteString(os.Stderr, response.Stderr)
+	return response.Exit, true
+}
+
+// record appends call to the call log of the mock name, and returns the number of calls
+// recorded before.
+func (m *testMocks) record(name string, call MockCall) (int, error) {
+	f, err := os.OpenFile(m.file(name, ".calls"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// Concurrent calls are numbered in the order they are recorded
+	if err := lockFile(f); err != nil {
+		return 0, err
+	}
+	defer unlockFile(f)
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(call)
+	if err != nil {
+		return 0, err
+	}
+	_, err = f.Write(append(data, '\n'))
+	return n, err
+}
+
+// runMockScript runs the script of a mock with the arguments and input of call, and returns
+// its exit code. Scripts without a #! line are run by sh, as a shell would.
+func runMockScript(script string, call MockCall) int {
+	var cmd *exec.Cmd
+	if content, err := os.ReadFile(script); err == nil && !strings.HasPrefix(string(content), "#!") {
+		cmd = exec.Command("sh", append([]string{script}, call.Args...)...)
+	} else {
+		cmd = exec.Command(script, call.Args...)
+	}
+	cmd.Stdin = strings.NewReader(call.Stdin)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	}
+	fmt.Fprintf(os.Stderr, "cannot run the script of the mock: %v\n", err)
+	return 1
+}
@@ -0,0 +1,146 @@
+// Reports of test runs
+package cobra
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// testReport is the outcome of running the cases of a test file, written with --report.
+type testReport struct {
+	File   string `json:"file"`
+	Passed int    `json:"passed"`
+	Failed int    `json:"failed"`
+	// Duration is in seconds, like the durations of the cases.
+	Duration float64          `json:"duration"`
+	Cases    []testCaseReport `json:"cases"`
+}
+
+type testCaseReport struct {
+	Name     string  `json:"name"`
+	Passed   bool    `json:"passed"`
+	Duration float64 `json:"duration"`
+	ExitCode int     `json:"exit_code"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	// Failure is the reason the case failed, including the diffs with golden files.
+	Failure string `json:"failure,omitempty"`
+}
+
+// add records the outcome of the case test, which failed if err is not nil.
+func (r *testReport) add(test TestCase, result testResult, err error) {
+	c := testCaseReport{
+		Name:     test.Name,
+		Passed:   err == nil,
+		Duration: result.Duration.Seconds(),
+		ExitCode: result.ExitCode,
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+	}
+	if err != nil {
+		c.Failure = err.Error()
+		r.Failed++
+	} else {
+		r.Passed++
+	}
+	r.Cases = append(r.Cases, c)
+}
+
+// testReportFormat returns the format of a report, from the extension of its file.
+func testReportFormat(file string) (string, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".xml":
+		return "junit", nil
+	case ".json":
+		return "json", nil
+	}
+	return "", fmt.Errorf("unknown format of report %s, expected a .xml (JUnit) or .json file", file)
+}
+
+func (r *testReport) write(file string) error {
+	format, err := testReportFormat(file)
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if format == "junit" {
+		data, err = xml.MarshalIndent(r.junit(), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	} else {
+		data, err = json.MarshalIndent(r, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, append(data, '\n'), 0644)
+}
+
+// The JUnit XML format, as read by CI systems.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junit converts r to JUnit XML, with a test suite named after the test file.
+func (r *testReport) junit() junitTestSuites {
+	name := strings.TrimSuffix(filepath.Base(r.File), filepath.Ext(r.File))
+	suite := junitTestSuite{
+		Name:     name,
+		Tests:    r.Passed + r.Failed,
+		Failures: r.Failed,
+		Time:     junitTime(r.Duration),
+	}
+	for _, c := range r.Cases {
+		tc := junitTestCase{
+			Name:      c.Name,
+			ClassName: name,
+			Time:      junitTime(c.Duration),
+			SystemOut: c.Stdout,
+			SystemErr: c.Stderr,
+		}
+		if !c.Passed {
+			message, _, _ := strings.Cut(c.Failure, "\n")
+			tc.Failure = &junitFailure{Message: message, Text: c.Failure}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	return junitTestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+}
+
+func junitTime(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
@@ -0,0 +1,707 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMain(m *testing.M) {
+	// The mocks of the test cases run the test binary
+	RunTestMockIfRequested()
+	os.Exit(m.Run())
+}
+
+func testSuiteCLI() *Command {
+	rootCmd := &Command{Use: "app", Run: emptyRun}
+	rootCmd.PersistentFlags().Bool("verbose", false, "verbose output")
+	greetCmd := &Command{
+		Use: "greet",
+		RunE: func(cmd *Command, args []string) error {
+			name, _ := cmd.Flags().GetString("name")
+			tags, _ := cmd.Flags().GetStringSlice("tag")
+			verbose, _ := cmd.Flags().GetBool("verbose")
+			cmd.Printf("hello %s tags=%v verbose=%v\n", name, tags, verbose)
+			return nil
+		},
+	}
+	greetCmd.Flags().String("name", "world", "who to greet")
+	greetCmd.Flags().StringSlice("tag", []string{"a"}, "tags")
+	rootCmd.AddCommand(greetCmd,
+		&Command{
+			Use: "cat",
+			RunE: func(cmd *Command, args []string) error {
+				_, err := io.Copy(cmd.OutOrStdout(), cmd.InOrStdin())
+				return err
+			},
+		},
+		&Command{
+			Use: "env",
+			Run: func(cmd *Command, args []string) {
+				cmd.Println(os.Getenv(args[0]))
+			},
+		},
+		&Command{
+			Use: "json",
+			Run: func(cmd *Command, args []string) {
+				cmd.Println(`{"count": 2, "items": [{"name": "a", "tags": ["x"]}, {"name": "b"}], "ok": true}`)
+			},
+		},
+		&Command{
+			Use: "write",
+			RunE: func(cmd *Command, args []string) error {
+				return os.WriteFile(args[0], []byte(args[1]), 0600)
+			},
+		},
+		&Command{
+			Use: "run",
+			RunE: func(cmd *Command, args []string) error {
+				c := exec.Command(args[0], args[1:]...)
+				c.Stdin = cmd.InOrStdin()
+				c.Stdout = cmd.OutOrStdout()
+				c.Stderr = cmd.ErrOrStderr()
+				return c.Run()
+			},
+			DisableFlagParsing: true,
+		},
+		&Command{
+			Use: "fail",
+			RunE: func(cmd *Command, args []string) error {
+				return fmt.Errorf("boom")
+			},
+			SilenceUsage: true,
+		},
+	)
+	return rootCmd
+}
+
+// runTestSuite runs the test file suite with the test command and args, expecting failed
+// cases to fail.
+func runTestSuite(t *testing.T, rootCmd *Command, suite string, failed int, args ...string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "tests.yaml")
+	if err := os.WriteFile(file, []byte(suite), 0600); err != nil {
+		t.Fatal(err)
+	}
+	output, err := executeCommand(rootCmd, append(append([]string{"test"}, args...), file)...)
+	switch {
+	case failed == 0 && err != nil:
+		t.Fatalf("Unexpected error: %v", err)
+	case failed > 0 && (err == nil || !strings.HasPrefix(err.Error(), fmt.Sprintf("%d of ", failed))):
+		t.Fatalf("Expected %d tests to fail, got error %v", failed, err)
+	}
+	return output
+}
+
+func TestRunTestsInProcess(t *testing.T) {
+	rootCmd := testSuiteCLI()
+	output := runTestSuite(t, rootCmd, `
+tests:
+- name: set flags
+  args: [--verbose, greet, --name, bob, --tag, b]
+  expect_output: hello bob tags=[b] verbose=true
+- name: default flags
+  args: [greet]
+  expect_output: hello world tags=[a] verbose=false
+- name: stdin
+  args: [cat]
+  stdin: piped input
+  expect_output: piped input
+- name: env
+  args: [env, COBRA_TEST_GREETING]
+  env:
+    COBRA_TEST_GREETING: hi
+  expect_output: hi
+- name: error
+  command: app
+  args: [fail]
+  expect_exit: 1
+  expect_error: "Error: boom"
+- name: unknown command
+  mode: in-process
+  command: other
+`, 1)
+
+	for _, expected := range []string{
+		"Running test: default flags\n  PASSED\n",
+		"Running test: unknown command\n  FAILED: in-process cases run app, not other\n",
+		"Results: 5 passed, 1 failed",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+		}
+	}
+	if _, set := os.LookupEnv("COBRA_TEST_GREETING"); set {
+		t.Error("Expected the environment of the case to be restored")
+	}
+}
+
+func TestRunTestsRestoresState(t *testing.T) {
+	rootCmd := testSuiteCLI()
+	global := GlobalAnalyticsDB
+	var caseDB *AnalyticsDB
+	rootCmd.AddCommand(&Command{
+		Use: "analytics",
+		Run: func(cmd *Command, args []string) {
+			caseDB = GlobalAnalyticsDB
+		},
+	})
+
+	output := runTestSuite(t, rootCmd, `
+mode: in-process
+tests:
+- name: analytics
+  args: [analytics]
+- name: flags
+  args: [greet]
+  expect_output: verbose=false
+`, 0, "--verbose")
+
+	if !strings.Contains(output, "Results: 2 passed, 0 failed") {
+		t.Errorf("Expected the cases to pass, got:\n%s", output)
+	}
+	if caseDB == nil || caseDB == global {
+		t.Error("Expected the case to record its analytics in a database of its own")
+	}
+	if GlobalAnalyticsDB != global {
+		t.Error("Expected GlobalAnalyticsDB to be restored")
+	}
+	if verbose, _ := rootCmd.PersistentFlags().GetBool("verbose"); !verbose {
+		t.Error("Expected the flags of the test command invocation to be restored")
+	}
+	names := make(map[string]bool)
+	for _, cmd := range rootCmd.Commands() {
+		if names[cmd.Name()] {
+			t.Errorf("Expected the builtin commands to be added once, got %s twice", cmd.Name())
+		}
+		names[cmd.Name()] = true
+	}
+}
+
+func TestRunTestsExec(t *testing.T) {
+	rootCmd := testSuiteCLI()
+	output := runTestSuite(t, rootCmd, `
+tests:
+- name: shell
+  command: echo "$COBRA_TEST_GREETING"
+  env:
+    COBRA_TEST_GREETING: hi
+  expect_output: hi
+- name: stdin
+  command: cat
+  stdin: piped input
+  expect_output: piped input
+- name: mock
+  command: mycommand
+  mocks:
+    mycommand: |
+      #!/bin/sh
+      echo "mocked output"
+  expect_output: mocked output
+- name: exit code
+  command: exit 3
+  expect_exit: 3
+`, 0)
+
+	if !strings.Contains(output, "Results: 4 passed, 0 failed") {
+		t.Errorf("Expected the cases to pass, got:\n%s", output)
+	}
+}
+
+func TestRunTestsAssertions(t *testing.T) {
+	rootCmd := testSuiteCLI()
+	dir := t.TempDir()
+	output := runTestSuite(t, rootCmd, fmt.Sprintf(`
+tests:
+- name: exit zero
+  args: [fail]
+  expect_exit: 0
+- name: exact
+  args: [greet]
+  stdout:
+    equals: "hello world tags=[a] verbose=false\n"
+- name: regex
+  args: [greet, --name, bob]
+  stdout:
+    matches: ^hello b.b
+    not_contains: [world, alice]
+- name: negative
+  args: [greet]
+  stdout:
+    contains: hello
+    not_contains: world
+- name: json
+  args: [json]
+  stdout:
+    json:
+      count: 2
+      $.ok: true
+      items[0].name: a
+      items[0].tags: [x]
+      items[1]: {name: b}
+- name: json mismatch
+  args: [json]
+  stdout:
+    json:
+      count: 3
+      items[2].name: c
+- name: stderr
+  args: [fail]
+  stderr:
+    matches: "Error: bo+m"
+- name: files
+  args: [write, %[1]s/out.txt, "line 1\nline 2"]
+  files:
+  - path: %[1]s/out.txt
+    contains: line 2
+  - path: %[1]s/missing.txt
+    exists: false
+- name: missing file
+  args: [greet]
+  files:
+  - path: %[1]s/missing.txt
+- name: duration
+  args: [greet]
+  max_duration: 1m
+- name: too slow
+  args: [greet]
+  max_duration: 1ns
+`, dir), 5)
+
+	for _, expected := range []string{
+		"Running test: exit zero\n  FAILED: expected exit code 0, got 1\n",
+		"Running test: negative\n  FAILED: expected output not to contain \"world\", got \"hello world tags=[a] verbose=false\\n\"\n",
+		"Running test: json mismatch\n  FAILED: expected count of output to be 3, got 2\n    items[2].name of output: $.items has 2 elements, no index 2\n",
+		"Running test: missing file\n  FAILED: expected file " + dir + "/missing.txt to exist\n",
+		"Running test: too slow\n  FAILED: expected to run within 1ns, took ",
+		"Results: 6 passed, 5 failed",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+		}
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"a": map[string]interface{}{"b": []interface{}{"x", map[string]interface{}{"c": 1.0}}},
+	}
+	tests := map[string]struct {
+		path     string
+		expected interface{}
+		err      string
+	}{
+		"root":         {path: "$", expected: doc},
+		"field":        {path: "a.b[0]", expected: "x"},
+		"dollar":       {path: "$.a.b[1].c", expected: 1.0},
+		"missing":      {path: "a.c", err: `$.a has no field "c"`},
+		"out of range": {path: "a.b[2]", err: "$.a.b has 2 elements, no index 2"},
+		"not an array": {path: "a[0]", err: "$.a is not an array"},
+		"not object":   {path: "a.b.c", err: "$.a.b is not an object"},
+		"bad index":    {path: "a.b[x]", err: `invalid path: index "x" after $.a.b`},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, err := lookupJSONPath(doc, tc.path)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("Expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if fmt.Sprint(value) != fmt.Sprint(tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestRunTestsGolden(t *testing.T) {
+	rootCmd := testSuiteCLI()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tests.yaml")
+	suite := `
+tests:
+- name: golden
+  args: [greet, --name, bob]
+  expect_golden: testdata/greet.golden
+`
+	if err := os.WriteFile(file, []byte(suite), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output, _ := executeCommand(rootCmd, "test", file)
+	expected := "FAILED: golden file testdata/greet.golden does not exist, run with --update to create it"
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+	}
+
+	if _, err := executeCommand(rootCmd, "test", "--update", file); err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join(dir, "testdata", "greet.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(golden) != "hello bob tags=[a] verbose=false\n" {
+		t.Errorf("Unexpected golden file %q", golden)
+	}
+	output, err = executeCommand(rootCmd, "test", "--update=false", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "Results: 1 passed, 0 failed") {
+		t.Errorf("Expected the golden file to match, got:\n%s", output)
+	}
+
+	if err := os.WriteFile(file, []byte(strings.Replace(suite, "bob", "alice", 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	output, _ = executeCommand(rootCmd, "test", file)
+	expected = `  FAILED: output differs from golden file testdata/greet.golden, run with --update to update it:
+    --- testdata/greet.golden
+    +++ output
+    @@ -1 +1 @@
+    -hello bob tags=[a] verbose=false
+    +hello alice tags=[a] verbose=false
+`
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+	}
+}
+
+func TestNormalizeTestOutput(t *testing.T) {
+	tmp := filepath.Join(os.TempDir(), "cobra_test_123", "out.txt")
+	output := "\x1b[1;32mcreated\x1b[0m " + tmp + " at 2024-05-01T10:20:30.123Z\r\n" +
+		"started 2024-05-01 10:20:30.5 +0000 UTC, see \x1b]8;;https://example.com\x1b\\link\x1b]8;;\x1b\\\n"
+	expected := "created <TMP>/out.txt at <TIMESTAMP>\nstarted <TIMESTAMP>, see link\n"
+	if got := normalizeTestOutput(output); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRunTestsReports(t *testing.T) {
+	dir := t.TempDir()
+	jsonReport := filepath.Join(dir, "report.json")
+	xmlReport := filepath.Join(dir, "report.xml")
+	suite := `
+tests:
+- name: greet bob
+  args: [greet, --name, bob]
+  expect_output: hello bob
+- name: greet alice
+  args: [greet, --name, alice]
+  stdout:
+    contains: hello bob
+- name: fail
+  args: [fail]
+  expect_exit: 1
+`
+	output := runTestSuite(t, testSuiteCLI(), suite, 1, "--run", "^greet", "--report", jsonReport, "--report", xmlReport)
+	if strings.Contains(output, "Running test: fail") {
+		t.Errorf("Expected --run to select the cases, got:\n%s", output)
+	}
+
+	data, err := os.ReadFile(jsonReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report testReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Passed != 1 || report.Failed != 1 || len(report.Cases) != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	failed := report.Cases[1]
+	if failed.Name != "greet alice" || failed.Passed || failed.Stdout != "hello alice tags=[a] verbose=false\n" ||
+		!strings.HasPrefix(failed.Failure, `expected output to contain "hello bob"`) || failed.Duration <= 0 {
+		t.Errorf("Unexpected case report %+v", failed)
+	}
+
+	data, err = os.ReadFile(xmlReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<testsuites tests="2" failures="1" time="`,
+		`<testsuite name="tests" tests="2" failures="1" time="`,
+		`<testcase name="greet bob" classname="tests" time="`,
+		`<failure message="expected output to contain &#34;hello bob&#34;, got &#34;hello alice tags=[a] verbose=false\n&#34;">`,
+		`<system-out>hello alice tags=[a] verbose=false&#xA;</system-out>`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Expected the JUnit report to contain %q, got:\n%s", expected, data)
+		}
+	}
+
+	_, err = executeCommand(testSuiteCLI(), "test", "--report", "report.txt", "tests.yaml")
+	if err == nil || err.Error() != "unknown format of report report.txt, expected a .xml (JUnit) or .json file" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestRunTestsFixtures(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log.txt")
+	output := runTestSuite(t, testSuiteCLI(), fmt.Sprintf(`
+setup:
+- echo suite setup >> %[1]s
+teardown:
+- echo suite teardown >> %[1]s
+tests:
+- name: in-process
+  fixtures:
+    config/app.yaml: "name: app\n"
+  setup:
+  - echo case setup >> %[1]s
+  - test -f config/app.yaml
+  teardown:
+  - echo case teardown >> %[1]s
+  args: [write, out.txt, written]
+  files:
+  - path: out.txt
+    equals: written
+  - path: config/app.yaml
+    contains: "name: app"
+- name: exec
+  fixtures:
+    input.txt: from fixture
+  command: cat input.txt
+  expect_output: from fixture
+- name: setup failure
+  setup:
+  - echo broken >&2; exit 3
+  teardown:
+  - echo failed case teardown >> %[1]s
+  args: [greet]
+- name: escaping fixture
+  fixtures:
+    ../outside.txt: x
+`, log), 2)
+
+	for _, expected := range []string{
+		"Running test: in-process\n  PASSED\n",
+		"Running test: exec\n  PASSED\n",
+		"Running test: setup failure\n  FAILED: setup \"echo broken >&2; exit 3\" failed: exit status 3: broken\n",
+		"Running test: escaping fixture\n  FAILED: fixture ../outside.txt is not within the working directory\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+		}
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "suite setup\ncase setup\ncase teardown\nfailed case teardown\nsuite teardown\n"
+	if string(data) != expected {
+		t.Errorf("Expected the steps to run in order:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestRunTestsParallel(t *testing.T) {
+	dir := t.TempDir()
+	// The cases wait for each other, so that they only pass if they run in parallel
+	output := runTestSuite(t, testSuiteCLI(), fmt.Sprintf(`
+parallel: true
+workers: 3
+tests:
+- name: "exec {{.n}}"
+  matrix:
+    n: ["1", "2", "3"]
+  command: touch %[1]s/{{.n}}; for i in $(seq 100); do [ $(ls %[1]s | wc -l) -eq 3 ] && exit 0; sleep 0.05; done; exit 1
+- name: in-process
+  args: [greet]
+  expect_output: hello world
+`, dir), 0)
+
+	expected := "Running test: exec 1\n  PASSED\nRunning test: exec 2\n  PASSED\nRunning test: exec 3\n  PASSED\n" +
+		"Running test: in-process\n  PASSED\n"
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+	}
+}
+
+func TestExpandTestMatrix(t *testing.T) {
+	var suite TestSuite
+	err := yaml.Unmarshal([]byte(`
+tests:
+- name: greet
+  args: [greet, --name, "{{.name}}"]
+  matrix:
+    name: [bob, alice]
+    tag: [x, y]
+  env:
+    TAG: "{{.tag}}"
+  stdout:
+    contains: ["hello {{.name}}"]
+    json:
+      tags: ["{{.tag}}"]
+`), &suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := expandTestMatrix(suite.Tests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range cases {
+		got = append(got, fmt.Sprintf("%s: %v %v %v %v", c.Name, c.Args, c.Env, c.Stdout.Contains, c.Stdout.JSON))
+	}
+	expected := []string{
+		"greet (name=bob, tag=x): [greet --name bob] map[TAG:x] [hello bob] map[tags:[x]]",
+		"greet (name=bob, tag=y): [greet --name bob] map[TAG:y] [hello bob] map[tags:[y]]",
+		"greet (name=alice, tag=x): [greet --name alice] map[TAG:x] [hello alice] map[tags:[x]]",
+		"greet (name=alice, tag=y): [greet --name alice] map[TAG:y] [hello alice] map[tags:[y]]",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	_, err = expandTestMatrix(TestCase{Name: "{{.missing}}", Matrix: map[string][]string{"n": {"1"}}})
+	if err == nil || !strings.Contains(err.Error(), `map has no entry for key "missing"`) {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestRunTestsMocks(t *testing.T) {
+	output := runTestSuite(t, testSuiteCLI(), `
+tests:
+- name: in-process
+  args: [run, kubectl, apply, -f, x.yaml]
+  stdin: "kind: Deployment"
+  mocks:
+    kubectl:
+      stdout: "deployment created\n"
+  expect_output: deployment created
+  expect_calls:
+    kubectl: [[apply, -f, x.yaml]]
+- name: responses
+  command: kubectl get; kubectl get; kubectl get
+  mocks:
+    kubectl:
+      responses:
+      - stdout: "pending\n"
+      - stdout: "ready\n"
+        stderr: "warning\n"
+        exit: 3
+  stdout:
+    equals: "pending\nready\nready\n"
+  stderr:
+    equals: "warning\nwarning\n"
+  expect_exit: 3
+  expect_calls:
+    kubectl: [[get], [get], [get]]
+- name: exit code
+  args: [run, git, push]
+  mocks:
+    git:
+      exit: 128
+  expect_exit: 1
+  expect_error: exit status 128
+- name: unexpected calls
+  command: kubectl delete pod "a b"
+  mocks:
+    kubectl: {}
+    helm: {}
+  expect_calls:
+    kubectl: [[apply]]
+    helm: []
+    git: []
+`, 1)
+
+	for _, expected := range []string{
+		"Running test: in-process\n  PASSED\n",
+		"Running test: responses\n  PASSED\n",
+		"Running test: exit code\n  PASSED\n",
+		"Running test: unexpected calls\n  FAILED: expected calls of git, which is not mocked\n" +
+			"    expected calls of kubectl:\n      kubectl apply\n    got:\n      kubectl delete pod \"a b\"\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q, got:\n%s", expected, output)
+		}
+	}
+}
+
+func TestTestMockRecordsCalls(t *testing.T) {
+	mocks, err := setupTestMocks(map[string]TestMock{
+		"kubectl": {Responses: []MockResponse{{Stdout: "first"}, {Stdout: "second", Exit: 2}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mocks.remove()
+
+	for i, expected := range []string{"first", "second", "second"} {
+		cmd := exec.Command(filepath.Join(mocks.dir, "kubectl"), "apply", "-f", "-")
+		cmd.Env = append(os.Environ(), testMockEnvVar+"="+mocks.dir, fmt.Sprintf("CALL=%d", i))
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("input %d", i))
+		output, err := cmd.Output()
+		if string(output) != expected {
+			t.Errorf("Expected call %d to output %q, got %q (%v)", i, expected, output, err)
+		}
+		if (i > 0) != (err != nil) {
+			t.Errorf("Unexpected error for call %d: %v", i, err)
+		}
+	}
+
+	calls, err := mocks.calls("kubectl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("Expected 3 calls, got %d", len(calls))
+	}
+	for i, call := range calls {
+		if strings.Join(call.Args, " ") != "apply -f -" || call.Stdin != fmt.Sprintf("input %d", i) ||
+			call.Env["CALL"] != fmt.Sprint(i) {
+			t.Errorf("Unexpected call %d: %v %q CALL=%s", i, call.Args, call.Stdin, call.Env["CALL"])
+		}
+	}
+}
+
+func TestTestMockEnvironmentIsNotPassedOn(t *testing.T) {
+	mocks, err := setupTestMocks(map[string]TestMock{
+		"kubectl": {Script: "echo \"[$" + testMockEnvVar + "]\"\n"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mocks.remove()
+
+	cmd := exec.Command(filepath.Join(mocks.dir, "kubectl"))
+	cmd.Env = append(os.Environ(), testMockEnvVar+"="+mocks.dir)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "[]\n" {
+		t.Errorf("Expected the script of the mock not to see %s, got %q", testMockEnvVar, output)
+	}
+}
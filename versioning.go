@@ -3,50 +3,107 @@ package cobra
 
 import (
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
-	"reflect"
 	"sort"
 	"strings"
+
+	flag "github.com/spf13/pflag"
 )
 
 type CommandSchema struct {
-	Version    string
-	CommandPath string
-	Use        string
-	Short      string
-	Long       string
-	Flags      map[string]string // flag name -> type:description
-	Args       string
-	SubCommands []string
-	SchemaHash string
+	Version     string            `json:"version,omitempty" yaml:"version,omitempty"`
+	CommandPath string            `json:"path" yaml:"path"`
+	Use         string            `json:"use" yaml:"use"`
+	Short       string            `json:"short,omitempty" yaml:"short,omitempty"`
+	Long        string            `json:"long,omitempty" yaml:"long,omitempty"`
+	Aliases     []string          `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	// Flags describes the flags defined by the command, by name. The flags added by Cobra,
+	// such as --help, are not included.
+	Flags map[string]FlagSchema `json:"flags,omitempty" yaml:"flags,omitempty"`
+	// Args describes the numbers of positional arguments accepted, e.g. "1", "0-2" or "1+", or
+	// is "unknown" for validators other than those of this package.
+	Args string `json:"args" yaml:"args"`
+	// SubCommands are the names of the subcommands, except those added by Cobra or plugins.
+	SubCommands []string `json:"subcommands,omitempty" yaml:"subcommands,omitempty"`
+	SchemaHash  string   `json:"hash,omitempty" yaml:"hash,omitempty"`
+}
+
+// FlagSchema describes a flag in a CommandSchema.
+type FlagSchema struct {
+	Type      string `json:"type" yaml:"type"`
+	Shorthand string `json:"shorthand,omitempty" yaml:"shorthand,omitempty"`
+	Default   string `json:"default,omitempty" yaml:"default,omitempty"`
+	Usage     string `json:"usage,omitempty" yaml:"usage,omitempty"`
+	// Persistent is set for the flags inherited by subcommands.
+	Persistent  bool                `json:"persistent,omitempty" yaml:"persistent,omitempty"`
+	Required    bool                `json:"required,omitempty" yaml:"required,omitempty"`
+	Hidden      bool                `json:"hidden,omitempty" yaml:"hidden,omitempty"`
+	Deprecated  string              `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Annotations map[string][]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// takesValue reports whether the flag needs a value, unlike --verbose.
+func (f FlagSchema) takesValue() bool {
+	return f.Type != "bool" && f.Type != "count"
 }
 
 type MigrationFunc func(fromVersion, toVersion string, cmd *Command) error
 
 type VersionManager struct {
-	schemas    map[string]CommandSchema
-	migrations map[string]MigrationFunc // "from-to" -> func
+	schemas    map[string]map[string]CommandSchema // command path -> version -> schema
+	migrations map[MigrationStep]MigrationFunc
+	argvRules  []ArgvRule // sorted by version
 }
 
 var GlobalVersionManager *VersionManager
 
 func InitVersioning() {
 	GlobalVersionManager = &VersionManager{
-		schemas:    make(map[string]CommandSchema),
-		migrations: make(map[string]MigrationFunc),
+		schemas:    make(map[string]map[string]CommandSchema),
+		migrations: make(map[MigrationStep]MigrationFunc),
 	}
 }
 
+// RegisterCommand records the schema of cmd for version, which must be a semantic version.
+// Versions are normalized, "v1.2" is registered as "1.2.0".
 func (vm *VersionManager) RegisterCommand(cmd *Command, version string) error {
-	schema := vm.computeSchema(cmd, version)
-	key := cmd.CommandPath() + ":" + version
+	v, err := ParseVersion(version)
+	if err != nil {
+		return fmt.Errorf("cannot register %s: %v", cmd.CommandPath(), err)
+	}
+	return vm.addSchema(vm.computeSchema(cmd, v.String()))
+}
 
-	if existing, exists := vm.schemas[key]; exists {
+// registerTree registers the commands of the tree of root that have a semantic version, so
+// that their current schemas are known to the version commands and pinned invocations.
+// Executions do not register the commands, which would compute their schemas on every run.
+// Commands whose schema differs from the one already registered for their version keep the
+// registered one.
+func (vm *VersionManager) registerTree(root *Command) {
+	if root.Version != "" && isProgramCommand(root) {
+		if _, err := ParseVersion(root.Version); err == nil {
+			_ = vm.RegisterCommand(root, root.Version)
+		}
+	}
+	for _, sub := range root.Commands() {
+		vm.registerTree(sub)
+	}
+}
+
+// addSchema registers schema, whose version must be normalized.
+func (vm *VersionManager) addSchema(schema CommandSchema) error {
+	path, version := schema.CommandPath, schema.Version
+	if existing, exists := vm.schemas[path][version]; exists {
 		if existing.SchemaHash != schema.SchemaHash {
-			return fmt.Errorf("schema mismatch for %s version %s", cmd.CommandPath(), version)
+			return fmt.Errorf("schema mismatch for %s version %s", path, version)
 		}
 	} else {
-		vm.schemas[key] = schema
+		if vm.schemas[path] == nil {
+			vm.schemas[path] = make(map[string]CommandSchema)
+		}
+		vm.schemas[path][version] = schema
 		// Store in DB if available
 		if GlobalAnalyticsDB != nil {
 			GlobalAnalyticsDB.StoreSchema(schema)
@@ -63,61 +120,344 @@ func (vm *VersionManager) computeSchema(cmd *Command, version string) CommandSch
 		Use:         cmd.Use,
 		Short:       cmd.Short,
 		Long:        cmd.Long,
-		Flags:       make(map[string]string),
+		Aliases:     append([]string(nil), cmd.Aliases...),
+		Annotations: cmd.Annotations,
+		Flags:       make(map[string]FlagSchema),
+		Args:        describeArgs(cmd),
 		SubCommands: make([]string, 0),
 	}
 
-	// Collect flags
-	cmd.Flags().VisitAll(func(f *flag.Flag) {
-		desc := fmt.Sprintf("%s:%s", f.Value.Type(), f.Usage)
-		schema.Flags[f.Name] = desc
+	// Collect the flags defined by the command, whether or not they were merged yet
+	cmd.LocalFlags().VisitAll(func(f *flag.Flag) {
+		if len(f.Annotations[FlagSetByCobraAnnotation]) > 0 {
+			return
+		}
+		schema.Flags[f.Name] = FlagSchema{
+			Type:        f.Value.Type(),
+			Shorthand:   f.Shorthand,
+			Default:     f.DefValue,
+			Usage:       f.Usage,
+			Persistent:  cmd.PersistentFlags().Lookup(f.Name) != nil,
+			Required:    len(f.Annotations[BashCompOneRequiredFlag]) > 0 && f.Annotations[BashCompOneRequiredFlag][0] == "true",
+			Hidden:      f.Hidden,
+			Deprecated:  f.Deprecated,
+			Annotations: f.Annotations,
+		}
 	})
 
 	// Collect subcommands
 	for _, sub := range cmd.Commands() {
+		if !isProgramCommand(sub) {
+			continue
+		}
 		schema.SubCommands = append(schema.SubCommands, sub.Name())
 	}
 	sort.Strings(schema.SubCommands)
 
-	// Compute hash
-	hashInput := fmt.Sprintf("%s|%s|%s|%s|%v|%v",
-		schema.Use, schema.Short, schema.Long,
-		schema.Args, schema.Flags, schema.SubCommands)
-	schema.SchemaHash = fmt.Sprintf("%x", md5.Sum([]byte(hashInput)))
-
+	schema.SchemaHash = schemaHash(schema)
 	return schema
 }
 
-func (vm *VersionManager) AddMigration(fromVersion, toVersion string, migration MigrationFunc) {
-	key := fromVersion + "-" + toVersion
-	vm.migrations[key] = migration
+// schemaHash hashes the interface described by schema, regardless of its version and path.
+func schemaHash(schema CommandSchema) string {
+	// JSON encodes the maps sorted by key
+	schema.Version, schema.CommandPath, schema.SchemaHash = "", "", ""
+	if schema.SubCommands == nil {
+		schema.SubCommands = []string{}
+	}
+	hashInput, _ := json.Marshal(schema)
+	return fmt.Sprintf("%x", md5.Sum(hashInput))
 }
 
-func (vm *VersionManager) MigrateCommand(cmd *Command, fromVersion, toVersion string) error {
-	key := fromVersion + "-" + toVersion
-	if migration, exists := vm.migrations[key]; exists {
-		return migration(fromVersion, toVersion, cmd)
+// isProgramCommand reports whether cmd is part of the program, rather than added by Cobra,
+// such as the help command, or by a plugin. Only those are described by command schemas.
+func isProgramCommand(cmd *Command) bool {
+	if cmd.builtin {
+		return false
 	}
-	return fmt.Errorf("no migration path from %s to %s", fromVersion, toVersion)
+	for _, p := range cmd.Root().loadedPlugins {
+		for _, pluginCmd := range p.commands {
+			if pluginCmd == cmd {
+				return false
+			}
+		}
+	}
+	return true
 }
 
+// GetCompatibleVersions returns the versions registered for the command at cmdPath, in
+// ascending order.
 func (vm *VersionManager) GetCompatibleVersions(cmdPath string) []string {
 	versions := make([]string, 0)
-	for key, schema := range vm.schemas {
-		if strings.HasPrefix(key, cmdPath+":") {
-			parts := strings.Split(key, ":")
-			if len(parts) == 2 {
-				versions = append(versions, parts[1])
+	for _, v := range vm.Versions(cmdPath) {
+		versions = append(versions, v.String())
+	}
+	return versions
+}
+
+// Versions returns the versions registered for the command at cmdPath, in ascending order.
+func (vm *VersionManager) Versions(cmdPath string) []Version {
+	versions := make([]Version, 0, len(vm.schemas[cmdPath]))
+	for version := range vm.schemas[cmdPath] {
+		versions = append(versions, MustParseVersion(version))
+	}
+	SortVersions(versions)
+	return versions
+}
+
+// VersionsInRange returns the versions registered for the command at cmdPath that are in
+// the version range rng, in ascending order. See VersionRange for the syntax of ranges.
+func (vm *VersionManager) VersionsInRange(cmdPath, rng string) ([]Version, error) {
+	r, err := ParseVersionRange(rng)
+	if err != nil {
+		return nil, err
+	}
+	var versions []Version
+	for _, v := range vm.Versions(cmdPath) {
+		if r.Contains(v) {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+// TreeVersions returns the versions registered for root and its subcommands, in ascending
+// order. These are the versions of the program.
+func (vm *VersionManager) TreeVersions(root *Command) []Version {
+	seen := make(map[string]bool)
+	var versions []Version
+	prefix := root.CommandPath()
+	for path, schemas := range vm.schemas {
+		if path != prefix && !strings.HasPrefix(path, prefix+" ") {
+			continue
+		}
+		for version := range schemas {
+			if !seen[version] {
+				seen[version] = true
+				versions = append(versions, MustParseVersion(version))
 			}
 		}
 	}
+	SortVersions(versions)
 	return versions
 }
 
+// ResolveVersion returns the highest version of the program of root in the version range rng.
+func (vm *VersionManager) ResolveVersion(root *Command, rng string) (Version, error) {
+	r, err := ParseVersionRange(rng)
+	if err != nil {
+		return Version{}, err
+	}
+	v, ok := r.Max(vm.TreeVersions(root))
+	if !ok {
+		return Version{}, fmt.Errorf("no version of %s matches %s", root.Name(), rng)
+	}
+	return v, nil
+}
+
+// SchemaAt returns the schema of the command at cmdPath in effect at version, that is the
+// schema registered for the highest version not above version. It returns false if the
+// command has no such schema.
+func (vm *VersionManager) SchemaAt(cmdPath, version string) (CommandSchema, bool) {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return CommandSchema{}, false
+	}
+	return vm.schemaAt(cmdPath, v)
+}
+
+func (vm *VersionManager) schemaAt(cmdPath string, v Version) (CommandSchema, bool) {
+	var schema CommandSchema
+	var best Version
+	found := false
+	for version, s := range vm.schemas[cmdPath] {
+		sv := MustParseVersion(version)
+		if sv.Compare(v) <= 0 && (!found || best.Less(sv)) {
+			schema, best, found = s, sv, true
+		}
+	}
+	return schema, found
+}
+
+// versionedBuiltinFlags are accepted by every command regardless of the registered schemas.
+// The values tell whether the flags take a value.
+var versionedBuiltinFlags = map[string]bool{
+	"help":             false,
+	"version":          false,
+	pinVersionFlagName: true,
+	noPluginsFlagName:  false,
+}
+
+// ValidateInvocation reports whether args, the arguments of an invocation of the program of
+// root, are valid under version. The commands and flags of the invocation are checked
+// against the schemas in effect at version, see SchemaAt. Commands without any registered
+// schema are not checked, and neither are the flags of their invocations, but their
+// subcommands are, following the live tree of root.
+func (vm *VersionManager) ValidateInvocation(root *Command, args []string, version string) error {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+
+	// Find the invoked command, skipping flags and their values as Command.Find does. The
+	// commands without a schema are followed in the live tree of root, and their flags are
+	// the current ones.
+	path, live := root.CommandPath(), root
+	schema, known := vm.schemaAt(path, v)
+	if !known && len(vm.schemas[path]) > 0 {
+		return fmt.Errorf("%q is not available in version %s, it was added in %s", path, v, vm.Versions(path)[0])
+	}
+	schemas := []CommandSchema{vm.schemaOrCurrent(schema, known, live, v)}
+	var flagArgs []string
+	walking := true
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") && arg != "-" {
+			flagArgs = append(flagArgs, arg)
+			if !strings.Contains(arg, "=") && flagTakesValue(schemas, arg) {
+				i++
+			}
+			continue
+		}
+		if !walking {
+			continue
+		}
+		name, liveChild := arg, liveSubCommand(live, arg)
+		if liveChild != nil {
+			name = liveChild.Name()
+		}
+		child := path + " " + name
+		subCommand := len(vm.schemas[child]) > 0
+		if known {
+			subCommand = subCommand || stringInSlice(name, schema.SubCommands)
+		} else {
+			subCommand = subCommand || liveChild != nil
+		}
+		if !subCommand {
+			// A positional argument
+			walking = false
+			continue
+		}
+		childSchema, childKnown := vm.schemaAt(child, v)
+		if !childKnown && len(vm.schemas[child]) > 0 {
+			return fmt.Errorf("%q is not available in version %s, it was added in %s", child, v, vm.Versions(child)[0])
+		}
+		if known && !stringInSlice(name, schema.SubCommands) {
+			return fmt.Errorf("%q is not available in version %s", child, v)
+		}
+		path, schema, known, live = child, childSchema, childKnown, liveChild
+		schemas = append(schemas, vm.schemaOrCurrent(schema, known, live, v))
+	}
+	if !known {
+		return nil
+	}
+	return checkVersionedFlags(schemas, flagArgs, v)
+}
+
+// schemaOrCurrent returns schema if it is known, and otherwise the current schema of the
+// live command cmd, if any.
+func (vm *VersionManager) schemaOrCurrent(schema CommandSchema, known bool, cmd *Command, v Version) CommandSchema {
+	if known || cmd == nil {
+		return schema
+	}
+	return vm.computeSchema(cmd, v.String())
+}
+
+// liveSubCommand returns the subcommand of cmd named or aliased name, or nil.
+func liveSubCommand(cmd *Command, name string) *Command {
+	if cmd == nil {
+		return nil
+	}
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == name || sub.HasAlias(name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// flagTakesValue reports whether the flag arg, given without a value, consumes the next
+// argument. Like Command.Find, it assumes that unknown flags take a value, and that only
+// single shorthands such as -n, rather than groups such as -vn, take the next argument.
+func flagTakesValue(schemas []CommandSchema, arg string) bool {
+	name := strings.TrimLeft(arg, "-")
+	if !strings.HasPrefix(arg, "--") && len(name) != 1 {
+		return false
+	}
+	for i := len(schemas) - 1; i >= 0; i-- {
+		s := schemas[i]
+		for long, f := range s.Flags {
+			if f.Shorthand == name {
+				name = long
+			}
+		}
+		if f, ok := s.Flags[name]; ok {
+			return f.takesValue()
+		}
+	}
+	if takesValue, ok := versionedBuiltinFlags[name]; ok {
+		return takesValue
+	}
+	return name != "h"
+}
+
+// checkVersionedFlags checks the flags, without their values, of an invocation of the command
+// described by the last schema. The flags defined by that command and the persistent flags of
+// its parents are valid.
+func checkVersionedFlags(schemas []CommandSchema, flagArgs []string, v Version) error {
+	cmd := schemas[len(schemas)-1]
+	valid := make(map[string]FlagSchema)
+	shorthands := map[string]string{"h": "help"}
+	for name, takesValue := range versionedBuiltinFlags {
+		valid[name] = FlagSchema{Type: "bool"}
+		if takesValue {
+			valid[name] = FlagSchema{Type: "string"}
+		}
+	}
+	for i, s := range schemas {
+		for name, f := range s.Flags {
+			if i == len(schemas)-1 || f.Persistent {
+				valid[name] = f
+				if f.Shorthand != "" {
+					shorthands[f.Shorthand] = name
+				}
+			}
+		}
+	}
+
+	for _, arg := range flagArgs {
+		if long, ok := strings.CutPrefix(arg, "--"); ok {
+			name, _, _ := strings.Cut(long, "=")
+			if _, ok := valid[name]; !ok {
+				return fmt.Errorf("unknown flag --%s for %q in version %s", name, cmd.CommandPath, v)
+			}
+			continue
+		}
+		group, _, _ := strings.Cut(strings.TrimPrefix(arg, "-"), "=")
+		for j := 0; j < len(group); j++ {
+			name, ok := shorthands[group[j:j+1]]
+			if !ok {
+				return fmt.Errorf("unknown shorthand flag %q in %s for %q in version %s", group[j:j+1], arg, cmd.CommandPath, v)
+			}
+			if valid[name].takesValue() {
+				// The rest of the group is the value.
+				break
+			}
+		}
+	}
+	return nil
+}
+
 func (vm *VersionManager) DetectVersionChange(cmd *Command, currentVersion string) (string, error) {
-	key := cmd.CommandPath() + ":" + currentVersion
-	if stored, exists := vm.schemas[key]; exists {
-		computed := vm.computeSchema(cmd, currentVersion)
+	v, err := ParseVersion(currentVersion)
+	if err != nil {
+		return "", err
+	}
+	if stored, exists := vm.schemas[cmd.CommandPath()][v.String()]; exists {
+		computed := vm.computeSchema(cmd, v.String())
 		if stored.SchemaHash != computed.SchemaHash {
 			return "schema changed", nil
 		}
@@ -127,14 +467,31 @@ func (vm *VersionManager) DetectVersionChange(cmd *Command, currentVersion strin
 	return "", nil
 }
 
+// schemaChanges returns the changes to cmd since its schema was registered for version.
+func (vm *VersionManager) schemaChanges(cmd *Command, version string) []SchemaChange {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return nil
+	}
+	stored, exists := vm.schemas[cmd.CommandPath()][v.String()]
+	if !exists {
+		return nil
+	}
+	return DiffSchemas(stored, vm.computeSchema(cmd, v.String()))
+}
+
 // Database integration
 func (a *AnalyticsDB) StoreSchema(schema CommandSchema) error {
-	_, err := a.db.Exec(`
+	flags, err := json.Marshal(schema.Flags)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`
 		INSERT OR REPLACE INTO command_schemas
 		(command_path, version, use_desc, short_desc, long_desc, flags, args, subcommands, schema_hash)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, schema.CommandPath, schema.Version, schema.Use, schema.Short, schema.Long,
-		fmt.Sprintf("%v", schema.Flags), schema.Args, strings.Join(schema.SubCommands, ","), schema.SchemaHash)
+		string(flags), schema.Args, strings.Join(schema.SubCommands, ","), schema.SchemaHash)
 	return err
 }
 
@@ -153,8 +510,11 @@ func (a *AnalyticsDB) GetStoredSchemas() ([]CommandSchema, error) {
 		if err != nil {
 			return nil, err
 		}
-		// Parse flags and subcommands if needed
-		s.SubCommands = strings.Split(subsStr, ",")
+		// Schemas stored before the flags were encoded as JSON have none
+		_ = json.Unmarshal([]byte(flagsStr), &s.Flags)
+		if subsStr != "" {
+			s.SubCommands = strings.Split(subsStr, ",")
+		}
 		schemas = append(schemas, s)
 	}
 	return schemas, nil
@@ -174,23 +534,178 @@ func CreateVersioningCommand() *Command {
 			if GlobalVersionManager == nil {
 				return fmt.Errorf("versioning not initialized")
 			}
-			versions := GlobalVersionManager.GetCompatibleVersions(args[0])
-			fmt.Printf("Versions for %s:\n", args[0])
+			GlobalVersionManager.registerTree(cmd.Root())
+			versions := GlobalVersionManager.Versions(args[0])
+			if rng, _ := cmd.Flags().GetString("range"); rng != "" {
+				var err error
+				if versions, err = GlobalVersionManager.VersionsInRange(args[0], rng); err != nil {
+					return err
+				}
+			}
+			cmd.Printf("Versions for %s:\n", args[0])
 			for _, v := range versions {
-				fmt.Printf("  %s\n", v)
+				cmd.Printf("  %s\n", v)
+			}
+			return nil
+		},
+	}
+	listCmd.Flags().String("range", "", "only list the versions in a range, e.g. ^1.2 or \">=2.0 <3\"")
+
+	validateCmd := &Command{
+		Use:   "validate <version> -- <args>...",
+		Short: "Check whether an invocation is valid under a version",
+		Long: `Check whether an invocation of the program is valid under a version.
+
+The version can be a range, e.g. ^1.2, in which case the highest version in the range is used.`,
+		Example: "  version validate 1.4 -- deploy --force prod",
+		Args:    MinimumNArgs(1),
+		RunE: func(cmd *Command, args []string) error {
+			if GlobalVersionManager == nil {
+				return fmt.Errorf("versioning not initialized")
+			}
+			root := cmd.Root()
+			GlobalVersionManager.registerTree(root)
+			v, err := GlobalVersionManager.ResolveVersion(root, args[0])
+			if err != nil {
+				return err
+			}
+			if err := GlobalVersionManager.ValidateInvocation(root, args[1:], v.String()); err != nil {
+				return err
+			}
+			cmd.Printf("Valid under version %s\n", v)
+			return nil
+		},
+	}
+
+	diffCmd := &Command{
+		Use:   "diff <command> <from> <to>",
+		Short: "Show the changes to a command between two versions",
+		Long: `Show the changes to a command and its subcommands between two versions.
+
+The command fails if any of the changes is breaking, such as a removed flag, so that it can
+be used to gate releases.`,
+		Example: "  version diff \"app deploy\" 1.2 2.0",
+		Args:    ExactArgs(3),
+		RunE: func(cmd *Command, args []string) error {
+			if GlobalVersionManager == nil {
+				return fmt.Errorf("versioning not initialized")
+			}
+			GlobalVersionManager.registerTree(cmd.Root())
+			changes, err := GlobalVersionManager.DiffVersions(args[0], args[1], args[2])
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				cmd.Printf("No changes to %s between %s and %s\n", args[0], args[1], args[2])
+				return nil
+			}
+			cmd.Printf("Changes to %s between %s and %s:\n", args[0], args[1], args[2])
+			for _, c := range changes {
+				label := ""
+				if c.Breaking {
+					label = "breaking"
+				}
+				cmd.Printf("  %-9s %s\n", label, c)
+			}
+			if breaking := BreakingChanges(changes); len(breaking) > 0 {
+				cmd.SilenceUsage = true
+				return fmt.Errorf("%d breaking change(s) between %s and %s", len(breaking), args[1], args[2])
 			}
 			return nil
 		},
 	}
 
+	snapshotCmd := &Command{
+		Use:   "snapshot [file]",
+		Short: "Write a snapshot of the command schemas",
+		Long: `Write a snapshot of the schemas of the commands of the program, with their flags, arguments
+and subcommands, to a JSON or YAML file depending on its extension, or to the standard output.
+
+Snapshots are deterministic, so that they can be committed as the record of each release.
+With --check, the file is compared with the program instead, and the command fails if they differ.`,
+		Example: "  version snapshot schemas/1.4.0.yaml\n  version snapshot --check schemas/1.4.0.yaml",
+		Args:    MaximumNArgs(1),
+		RunE: func(cmd *Command, args []string) error {
+			root := cmd.Root()
+			version, _ := cmd.Flags().GetString("at")
+			if version == "" {
+				version = root.Version
+			}
+			file := ""
+			if len(args) > 0 {
+				file = args[0]
+			}
+
+			if check, _ := cmd.Flags().GetBool("check"); check {
+				if file == "" {
+					return fmt.Errorf("--check needs a snapshot file")
+				}
+				changes, err := checkSchemaSnapshot(root, version, file)
+				if err != nil {
+					return err
+				}
+				if len(changes) == 0 {
+					cmd.Printf("Snapshot %s is up to date\n", file)
+					return nil
+				}
+				cmd.Printf("Changes since snapshot %s:\n", file)
+				for _, c := range changes {
+					cmd.Printf("  %s\n", c)
+				}
+				cmd.SilenceUsage = true
+				return fmt.Errorf("schema snapshot %s is out of date", file)
+			}
+
+			s, err := SnapshotSchemas(root, version)
+			if err != nil {
+				return err
+			}
+			if file != "" {
+				return WriteSchemaSnapshot(file, s)
+			}
+			format, _ := cmd.Flags().GetString("format")
+			data, err := s.Encode(format)
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(data)
+			return err
+		},
+	}
+	snapshotCmd.Flags().String("format", "json", "encoding of the snapshot written to the standard output, json or yaml")
+	_ = snapshotCmd.RegisterFlagCompletionFunc("format", FixedCompletions([]string{"json", "yaml"}, ShellCompDirectiveNoFileComp))
+	snapshotCmd.Flags().String("at", "", "version recorded in the snapshot, the version of the program by default")
+	snapshotCmd.Flags().Bool("check", false, "fail if the snapshot file differs from the program")
+
 	migrateCmd := &Command{
 		Use:   "migrate <command> <from> <to>",
 		Short: "Migrate command from one version to another",
-		Args:  ExactArgs(3),
+		Long: `Migrate a command from one version to another, chaining the registered migrations
+through intermediate versions if needed. With --dry-run, the migrations are listed instead.`,
+		Args: ExactArgs(3),
 		RunE: func(cmd *Command, args []string) error {
 			if GlobalVersionManager == nil {
 				return fmt.Errorf("versioning not initialized")
 			}
+			if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+				plan, err := GlobalVersionManager.PlanMigration(args[1], args[2])
+				if err != nil {
+					return err
+				}
+				if len(plan.Steps) == 0 {
+					cmd.Printf("No migration needed for %s at %s\n", args[0], plan.To)
+					return nil
+				}
+				kind := "Upgrade"
+				if plan.Downgrade() {
+					kind = "Downgrade"
+				}
+				cmd.Printf("%s of %s from %s to %s, %d step(s):\n", kind, args[0], plan.From, plan.To, len(plan.Steps))
+				for i, step := range plan.Steps {
+					cmd.Printf("  %d. %s -> %s\n", i+1, step.From, step.To)
+				}
+				return nil
+			}
 			// Find the command
 			root := cmd.Root()
 			target, _, err := root.Find(strings.Fields(args[0]))
@@ -200,6 +715,7 @@ func CreateVersioningCommand() *Command {
 			return GlobalVersionManager.MigrateCommand(target, args[1], args[2])
 		},
 	}
+	migrateCmd.Flags().Bool("dry-run", false, "list the migrations that would run")
 
 	checkCmd := &Command{
 		Use:   "check",
@@ -213,16 +729,80 @@ func CreateVersioningCommand() *Command {
 		},
 	}
 
-	cmd.AddCommand(listCmd, migrateCmd, checkCmd)
+	cmd.AddCommand(listCmd, validateCmd, diffCmd, snapshotCmd, migrateCmd, checkCmd)
 	return cmd
 }
 
+const (
+	pinVersionFlagName = "pin-version"
+	pinVersionEnvVar   = "PIN_VERSION"
+)
+
+// initPinVersionFlag adds the --pin-version flag to c, so that it is accepted by every command.
+func (c *Command) initPinVersionFlag() {
+	if c.PersistentFlags().Lookup(pinVersionFlagName) != nil || c.Flags().Lookup(pinVersionFlagName) != nil {
+		return
+	}
+	c.PersistentFlags().String(pinVersionFlagName, "", "fail unless the invocation is valid under a version of the program, e.g. ^1.2")
+	_ = c.PersistentFlags().SetAnnotation(pinVersionFlagName, FlagSetByCobraAnnotation, []string{"true"})
+}
+
+// pinnedVersion returns the version range the invocation args of the root command c is pinned
+// to, from --pin-version or the <PROGRAM>_PIN_VERSION environment variable. The invocation is
+// checked before the flags are parsed, so args are scanned for the flag directly.
+func pinnedVersion(c *Command, args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+pinVersionFlagName && i+1 < len(args) {
+			return args[i+1]
+		}
+		if value, ok := strings.CutPrefix(arg, "--"+pinVersionFlagName+"="); ok {
+			return value
+		}
+	}
+	return getEnvConfig(c, pinVersionEnvVar)
+}
+
+// checkPinnedVersion checks args against the version the invocation is pinned to, if any, so
+// that scripts written for a version of the program fail early, rather than rely on commands
+// or flags that were added later. The --pin-version flag is only listed by programs that
+// registered versions of their commands with GlobalVersionManager, but is accepted by any
+// program whose commands have semantic versions.
+func (c *Command) checkPinnedVersion(args []string) error {
+	if GlobalVersionManager == nil {
+		return nil
+	}
+	pin := pinnedVersion(c, args)
+	if pin != "" {
+		// The tree is only registered for pinned invocations, so that setting Version does not
+		// add --pin-version to every program.
+		GlobalVersionManager.registerTree(c)
+	}
+	if len(GlobalVersionManager.TreeVersions(c)) == 0 {
+		return nil
+	}
+	c.initPinVersionFlag()
+	if pin == "" {
+		return nil
+	}
+	v, err := GlobalVersionManager.ResolveVersion(c, pin)
+	if err != nil {
+		return fmt.Errorf("invalid pinned version: %v", err)
+	}
+	return GlobalVersionManager.ValidateInvocation(c, args, v.String())
+}
+
 func checkAllSchemas(cmd *Command) error {
 	if cmd.Version != "" {
 		if change, err := GlobalVersionManager.DetectVersionChange(cmd, cmd.Version); err != nil {
 			return err
 		} else if change != "" {
 			fmt.Printf("Command %s: %s\n", cmd.CommandPath(), change)
+			for _, c := range GlobalVersionManager.schemaChanges(cmd, cmd.Version) {
+				fmt.Printf("  %s\n", c.Description)
+			}
 		}
 	}
 	for _, sub := range cmd.Commands() {
@@ -0,0 +1,373 @@
+// Structural diffs between command schemas
+package cobra
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SchemaChange is a difference between two schemas of a command.
+type SchemaChange struct {
+	// CommandPath is the path of the command that changed.
+	CommandPath string
+	// Breaking is set for the changes that can break existing invocations, such as a removed
+	// flag, as opposed to additions and description edits.
+	Breaking bool
+	// Description describes the change, e.g. "flag --force removed".
+	Description string
+}
+
+// String formats the change as "<command path>: <description>".
+func (c SchemaChange) String() string {
+	return c.CommandPath + ": " + c.Description
+}
+
+// BreakingChanges returns the breaking changes among changes.
+func BreakingChanges(changes []SchemaChange) []SchemaChange {
+	var breaking []SchemaChange
+	for _, c := range changes {
+		if c.Breaking {
+			breaking = append(breaking, c)
+		}
+	}
+	return breaking
+}
+
+// DiffSchemas returns the changes from the schema from to the schema to of a command.
+// Removing a flag, a subcommand or an alias, changing the type, shorthand or default of a flag,
+// making a flag required or no longer persistent, and accepting fewer positional arguments are
+// breaking changes. Additions of optional flags, subcommands and aliases, and description
+// edits are not.
+func DiffSchemas(from, to CommandSchema) []SchemaChange {
+	var changes []SchemaChange
+	change := func(breaking bool, format string, a ...interface{}) {
+		changes = append(changes, SchemaChange{
+			CommandPath: to.CommandPath,
+			Breaking:    breaking,
+			Description: fmt.Sprintf(format, a...),
+		})
+	}
+
+	removed, added := diffStrings(from.SubCommands, to.SubCommands)
+	for _, name := range removed {
+		change(true, "subcommand %s removed", name)
+	}
+	for _, name := range added {
+		change(false, "subcommand %s added", name)
+	}
+	removed, added = diffStrings(from.Aliases, to.Aliases)
+	for _, alias := range removed {
+		change(true, "alias %s removed", alias)
+	}
+	for _, alias := range added {
+		change(false, "alias %s added", alias)
+	}
+
+	// Changes from or to undescribed arguments cannot be told breaking or not
+	if from.Args != to.Args && from.Args != "" && to.Args != "" && from.Args != unknownArgs && to.Args != unknownArgs {
+		change(argsTightened(from.Args, to.Args), "positional arguments changed from %s to %s", from.Args, to.Args)
+	}
+
+	names := make([]string, 0, len(from.Flags)+len(to.Flags))
+	for name := range from.Flags {
+		names = append(names, name)
+	}
+	for name := range to.Flags {
+		if _, ok := from.Flags[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		old, inFrom := from.Flags[name]
+		cur, inTo := to.Flags[name]
+		switch {
+		case !inTo:
+			change(true, "flag --%s removed", name)
+		case !inFrom && cur.Required:
+			change(true, "required flag --%s added", name)
+		case !inFrom:
+			change(false, "flag --%s added", name)
+		default:
+			diffFlags(name, old, cur, change)
+		}
+	}
+
+	if !sameAnnotations(from.Annotations, to.Annotations) {
+		change(false, "annotations changed")
+	}
+	if from.Use != to.Use {
+		change(false, "usage changed from %q to %q", from.Use, to.Use)
+	}
+	if from.Short != to.Short {
+		change(false, "short description changed")
+	}
+	if from.Long != to.Long {
+		change(false, "long description changed")
+	}
+	return changes
+}
+
+func diffFlags(name string, old, cur FlagSchema, change func(bool, string, ...interface{})) {
+	if old.Type != cur.Type {
+		change(true, "type of flag --%s changed from %s to %s", name, old.Type, cur.Type)
+	}
+	switch {
+	case old.Shorthand == cur.Shorthand:
+	case old.Shorthand == "":
+		change(false, "shorthand -%s added to flag --%s", cur.Shorthand, name)
+	case cur.Shorthand == "":
+		change(true, "shorthand -%s of flag --%s removed", old.Shorthand, name)
+	default:
+		change(true, "shorthand of flag --%s changed from -%s to -%s", name, old.Shorthand, cur.Shorthand)
+	}
+	if old.Default != cur.Default && old.Type == cur.Type {
+		change(true, "default of flag --%s changed from %q to %q", name, old.Default, cur.Default)
+	}
+	if old.Required != cur.Required {
+		if cur.Required {
+			change(true, "flag --%s is now required", name)
+		} else {
+			change(false, "flag --%s is no longer required", name)
+		}
+	}
+	if old.Persistent != cur.Persistent {
+		if cur.Persistent {
+			change(false, "flag --%s is now inherited by subcommands", name)
+		} else {
+			change(true, "flag --%s is no longer inherited by subcommands", name)
+		}
+	}
+	if old.Deprecated != cur.Deprecated && cur.Deprecated != "" {
+		change(false, "flag --%s deprecated", name)
+	}
+	if old.Hidden != cur.Hidden {
+		if cur.Hidden {
+			change(false, "flag --%s hidden", name)
+		} else {
+			change(false, "flag --%s no longer hidden", name)
+		}
+	}
+	if old.Usage != cur.Usage {
+		change(false, "description of flag --%s changed", name)
+	}
+	// Required flags are annotated, which is reported above
+	oldAnnotations := make(map[string][]string)
+	curAnnotations := make(map[string][]string)
+	for key, values := range old.Annotations {
+		oldAnnotations[key] = values
+	}
+	for key, values := range cur.Annotations {
+		curAnnotations[key] = values
+	}
+	delete(oldAnnotations, BashCompOneRequiredFlag)
+	delete(curAnnotations, BashCompOneRequiredFlag)
+	if !sameAnnotations(oldAnnotations, curAnnotations) {
+		change(false, "annotations of flag --%s changed", name)
+	}
+}
+
+// sameAnnotations compares two annotation maps, considering nil and empty maps equal.
+func sameAnnotations(a, b interface{}) bool {
+	return reflect.ValueOf(a).Len() == 0 && reflect.ValueOf(b).Len() == 0 || reflect.DeepEqual(a, b)
+}
+
+// diffStrings returns the strings of from missing from to, and those of to missing from from.
+func diffStrings(from, to []string) (removed, added []string) {
+	for _, s := range from {
+		if !stringInSlice(s, to) {
+			removed = append(removed, s)
+		}
+	}
+	for _, s := range to {
+		if !stringInSlice(s, from) {
+			added = append(added, s)
+		}
+	}
+	return removed, added
+}
+
+// maxProbedArgs is the number of positional arguments up to which the validator of a command
+// is probed. Validators accepting that many arguments are assumed to accept any number.
+const maxProbedArgs = 8
+
+// unknownArgs describes the positional arguments of commands whose validator is not one of
+// the validators of this package, which cannot be described without running it.
+const unknownArgs = "unknown"
+
+// describeArgs describes the numbers of positional arguments accepted by cmd: "2" for exactly
+// two, "1-3", "1+" for one or more, "none" if the validator rejected every probe, or "unknown".
+// Only the validators of this package, such as ExactArgs or a MatchAll of them, are described:
+// they depend on the number of arguments only, so they are probed with placeholder arguments,
+// the first of ValidArgs if any. Other validators are never called.
+func describeArgs(cmd *Command) string {
+	if !isBuiltinArgs(cmd.Args) {
+		return unknownArgs
+	}
+	placeholder := "arg"
+	if len(cmd.ValidArgs) > 0 {
+		placeholder, _, _ = strings.Cut(cmd.ValidArgs[0], "\t")
+	}
+	min, max := -1, -1
+	for n := 0; n <= maxProbedArgs; n++ {
+		args := make([]string, n)
+		for i := range args {
+			args[i] = placeholder
+		}
+		if cmd.ValidateArgs(args) != nil {
+			continue
+		}
+		if min < 0 {
+			min = n
+		}
+		max = n
+	}
+	switch {
+	case min < 0:
+		return "none"
+	case max == maxProbedArgs:
+		return fmt.Sprintf("%d+", min)
+	case min == max:
+		return strconv.Itoa(min)
+	}
+	return fmt.Sprintf("%d-%d", min, max)
+}
+
+// argsProbe is passed to the validators returned by MatchAll to get the validators they combine.
+var argsProbe = &Command{}
+
+// matchedArgs is returned by the validators returned by MatchAll when called with argsProbe.
+type matchedArgs []PositionalArgs
+
+func (matchedArgs) Error() string { return "combined validators" }
+
+// builtinArgs are the names of the validators of this package, and builtinArgsPrefixes those of
+// the functions returning validators. The validators they return are named after them, with
+// a suffix that depends on where they were inlined.
+var (
+	builtinArgs         = funcNames(NoArgs, OnlyValidArgs, ArbitraryArgs, legacyArgs)
+	builtinArgsPrefixes = funcNames(MinimumNArgs, MaximumNArgs, ExactArgs, RangeArgs)
+	matchAllArgsPrefix  = funcName(MatchAll) + "."
+)
+
+// funcNames returns the set of the names of the functions fns.
+func funcNames(fns ...interface{}) map[string]bool {
+	names := make(map[string]bool, len(fns))
+	for _, fn := range fns {
+		names[funcName(fn)] = true
+	}
+	return names
+}
+
+// funcName returns the name of the function fn.
+func funcName(fn interface{}) string {
+	if f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// isBuiltinArgs reports whether fn is nil, a validator of this package or a MatchAll of them.
+func isBuiltinArgs(fn PositionalArgs) bool {
+	if fn == nil {
+		return true
+	}
+	name := funcName(fn)
+	if builtinArgs[name] {
+		return true
+	}
+	for prefix := range builtinArgsPrefixes {
+		if strings.HasPrefix(name, prefix+".") {
+			return true
+		}
+	}
+	if !strings.HasPrefix(name, matchAllArgsPrefix) {
+		return false
+	}
+	pargs, ok := fn(argsProbe, nil).(matchedArgs)
+	if !ok {
+		return false
+	}
+	for _, parg := range pargs {
+		if !isBuiltinArgs(parg) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseArgsDescription parses a description returned by describeArgs. The maximum is -1 when
+// there is none, and min is -1 when no number of arguments is accepted.
+func parseArgsDescription(s string) (min, max int, ok bool) {
+	if s == "none" {
+		return -1, -1, true
+	}
+	if lo, found := strings.CutSuffix(s, "+"); found {
+		n, err := strconv.Atoi(lo)
+		return n, -1, err == nil
+	}
+	lo, hi, found := strings.Cut(s, "-")
+	if !found {
+		hi = lo
+	}
+	min, errMin := strconv.Atoi(lo)
+	max, errMax := strconv.Atoi(hi)
+	return min, max, errMin == nil && errMax == nil
+}
+
+// argsTightened reports whether some number of arguments accepted as described by from is not
+// accepted as described by to.
+func argsTightened(from, to string) bool {
+	fromMin, fromMax, ok1 := parseArgsDescription(from)
+	toMin, toMax, ok2 := parseArgsDescription(to)
+	switch {
+	case !ok1 || !ok2:
+		return true
+	case fromMin < 0:
+		return false
+	case toMin < 0:
+		return true
+	}
+	return toMin > fromMin || (toMax >= 0 && (fromMax < 0 || toMax < fromMax))
+}
+
+// DiffVersions returns the changes to the command at cmdPath and its subcommands between the
+// versions from and to, comparing the schemas in effect at each version, see SchemaAt.
+func (vm *VersionManager) DiffVersions(cmdPath, from, to string) ([]SchemaChange, error) {
+	fromVersion, err := ParseVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := ParseVersion(to)
+	if err != nil {
+		return nil, err
+	}
+	if len(vm.schemas[cmdPath]) == 0 {
+		return nil, fmt.Errorf("no versions registered for %q", cmdPath)
+	}
+	return vm.diffTree(cmdPath, fromVersion, toVersion), nil
+}
+
+func (vm *VersionManager) diffTree(path string, from, to Version) []SchemaChange {
+	old, inFrom := vm.schemaAt(path, from)
+	cur, inTo := vm.schemaAt(path, to)
+	switch {
+	case !inFrom && !inTo:
+		return nil
+	case !inTo:
+		return []SchemaChange{{CommandPath: path, Breaking: true, Description: "command removed"}}
+	case !inFrom:
+		return []SchemaChange{{CommandPath: path, Description: "command added"}}
+	}
+	changes := DiffSchemas(old, cur)
+	for _, name := range cur.SubCommands {
+		if stringInSlice(name, old.SubCommands) {
+			changes = append(changes, vm.diffTree(path+" "+name, from, to)...)
+		}
+	}
+	return changes
+}
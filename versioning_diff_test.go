@@ -0,0 +1,236 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDescribeArgs(t *testing.T) {
+	tests := map[string]struct {
+		args      PositionalArgs
+		validArgs []string
+		expected  string
+	}{
+		"nil":       {expected: "0+"},
+		"none":      {args: NoArgs, expected: "0"},
+		"exact":     {args: ExactArgs(2), expected: "2"},
+		"minimum":   {args: MinimumNArgs(1), expected: "1+"},
+		"maximum":   {args: MaximumNArgs(3), expected: "0-3"},
+		"range":     {args: RangeArgs(1, 2), expected: "1-2"},
+		"valid":     {args: MatchAll(ExactArgs(1), OnlyValidArgs), validArgs: []string{"a\tthe a"}, expected: "1"},
+		"rejecting": {args: MatchAll(MinimumNArgs(2), MaximumNArgs(1)), expected: "none"},
+		"custom":    {args: func(cmd *Command, args []string) error { panic("called") }, expected: "unknown"},
+		"combined":  {args: MatchAll(ExactArgs(1), func(cmd *Command, args []string) error { panic("called") }), expected: "unknown"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd := &Command{Use: "c", Args: tc.args, ValidArgs: tc.validArgs}
+			if got := describeArgs(cmd); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDiffSchemas(t *testing.T) {
+	base := func() *Command {
+		cmd := &Command{Use: "deploy <env>", Short: "Deploy", Aliases: []string{"d"}, Args: ExactArgs(1), Run: emptyRun}
+		cmd.Flags().IntP("replicas", "n", 1, "number of replicas")
+		cmd.Flags().Bool("force", false, "force the deployment")
+		cmd.PersistentFlags().String("region", "eu", "region")
+		cmd.AddCommand(&Command{Use: "status", Run: emptyRun})
+		return cmd
+	}
+	vm := &VersionManager{}
+	from := vm.computeSchema(base(), "1.0.0")
+
+	tests := map[string]struct {
+		modify   func(cmd *Command)
+		expected []string
+		breaking bool
+	}{
+		"unchanged": {modify: func(cmd *Command) {}},
+		"flag removed": {
+			modify:   func(cmd *Command) { cmd.ResetFlags(); redefine(cmd, "force") },
+			expected: []string{"flag --force removed"},
+			breaking: true,
+		},
+		"type changed": {
+			modify: func(cmd *Command) {
+				cmd.ResetFlags()
+				redefine(cmd, "replicas")
+				cmd.Flags().StringP("replicas", "n", "1", "number of replicas")
+			},
+			expected: []string{"type of flag --replicas changed from int to string"},
+			breaking: true,
+		},
+		"shorthand changed": {
+			modify: func(cmd *Command) {
+				cmd.ResetFlags()
+				redefine(cmd, "replicas")
+				cmd.Flags().IntP("replicas", "r", 1, "number of replicas")
+			},
+			expected: []string{"shorthand of flag --replicas changed from -n to -r"},
+			breaking: true,
+		},
+		"no longer persistent": {
+			modify: func(cmd *Command) {
+				cmd.ResetFlags()
+				redefine(cmd, "region")
+				cmd.Flags().String("region", "eu", "region")
+			},
+			expected: []string{"flag --region is no longer inherited by subcommands"},
+			breaking: true,
+		},
+		"subcommand removed": {
+			modify:   func(cmd *Command) { cmd.RemoveCommand(cmd.Commands()...) },
+			expected: []string{"subcommand status removed"},
+			breaking: true,
+		},
+		"args tightened": {
+			modify:   func(cmd *Command) { cmd.Args = ExactArgs(2) },
+			expected: []string{"positional arguments changed from 1 to 2"},
+			breaking: true,
+		},
+		"args loosened": {
+			modify:   func(cmd *Command) { cmd.Args = RangeArgs(1, 2) },
+			expected: []string{"positional arguments changed from 1 to 1-2"},
+		},
+		"required flag": {
+			modify:   func(cmd *Command) { _ = cmd.MarkFlagRequired("force") },
+			expected: []string{"flag --force is now required"},
+			breaking: true,
+		},
+		"optional flag added": {
+			modify:   func(cmd *Command) { cmd.Flags().Bool("dry-run", false, "") },
+			expected: []string{"flag --dry-run added"},
+		},
+		"descriptions edited": {
+			modify: func(cmd *Command) {
+				cmd.Short = "Deploy the application"
+				cmd.Flags().Lookup("force").Usage = "skip the checks"
+			},
+			expected: []string{"description of flag --force changed", "short description changed"},
+		},
+		"alias removed": {
+			modify:   func(cmd *Command) { cmd.Aliases = []string{"dep"} },
+			expected: []string{"alias d removed", "alias dep added"},
+			breaking: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cmd := base()
+			tc.modify(cmd)
+			changes := DiffSchemas(from, vm.computeSchema(cmd, "2.0.0"))
+			var descriptions []string
+			for _, c := range changes {
+				descriptions = append(descriptions, c.Description)
+			}
+			if !reflect.DeepEqual(descriptions, tc.expected) {
+				t.Errorf("Expected changes %q, got %q", tc.expected, descriptions)
+			}
+			if breaking := len(BreakingChanges(changes)) > 0; breaking != tc.breaking {
+				t.Errorf("Expected breaking to be %v, got %v", tc.breaking, breaking)
+			}
+		})
+	}
+}
+
+// redefine defines the flags of the base command of TestDiffSchemas again, except skip.
+func redefine(cmd *Command, skip string) {
+	if skip != "replicas" {
+		cmd.Flags().IntP("replicas", "n", 1, "number of replicas")
+	}
+	if skip != "force" {
+		cmd.Flags().Bool("force", false, "force the deployment")
+	}
+	if skip != "region" {
+		cmd.PersistentFlags().String("region", "eu", "region")
+	}
+}
+
+func TestDiffVersions(t *testing.T) {
+	vm := useVersionManager(t)
+	versionedCLI(t, vm)
+
+	changes, err := vm.DiffVersions("app", "1.0", "2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	expected := []string{
+		"app: subcommand status added",
+		"app deploy: flag --dry-run added",
+		"app deploy: flag --force removed",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected changes %q, got %q", expected, got)
+	}
+
+	changes, err = vm.DiffVersions("app deploy", "1.0", "1.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Breaking || changes[0].Description != "flag --dry-run added" {
+		t.Errorf("Expected --dry-run to be added, got %v", changes)
+	}
+
+	changes, err = vm.DiffVersions("app status", "1.0", "2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Description != "command added" {
+		t.Errorf("Expected the command to be added, got %v", changes)
+	}
+	if changes, _ := vm.DiffVersions("app status", "2.0", "1.0"); len(BreakingChanges(changes)) != 1 {
+		t.Errorf("Expected the removal of the command to be breaking, got %v", changes)
+	}
+
+	if _, err := vm.DiffVersions("app missing", "1.0", "2.0"); err == nil {
+		t.Error("Expected an error for a command without versions")
+	}
+}
+
+func TestVersionDiffCommand(t *testing.T) {
+	vm := useVersionManager(t)
+	rootCmd := versionedCLI(t, vm)
+	rootCmd.AddCommand(CreateVersioningCommand())
+
+	output, err := executeCommand(rootCmd, "version", "diff", "app", "1.0", "1.2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, "app deploy: flag --dry-run added") {
+		t.Errorf("Expected the added flag to be listed, got %q", output)
+	}
+
+	output, err = executeCommand(rootCmd, "version", "diff", "app", "1.2", "2.0")
+	if err == nil || err.Error() != "1 breaking change(s) between 1.2 and 2.0" {
+		t.Errorf("Expected the breaking change to fail the command, got %v", err)
+	}
+	if !strings.Contains(output, "breaking  app deploy: flag --force removed") {
+		t.Errorf("Expected the breaking change to be listed, got %q", output)
+	}
+	if strings.Contains(output, "Usage:") {
+		t.Errorf("Expected no usage for breaking changes, got %q", output)
+	}
+}
@@ -18,13 +18,21 @@ func SetupVersioningExample(rootCmd *Command) {
 	cmd.Flags().String("old-flag", "", "An old flag")
 
 	// Register migration
-	GlobalVersionManager.AddMigration("1.0.0", "2.0.0", func(from, to string, cmd *Command) error {
+	err := GlobalVersionManager.AddMigrationE("1.0.0", "2.0.0", func(from, to string, cmd *Command) error {
 		fmt.Printf("Migrating %s from %s to %s\n", cmd.CommandPath(), from, to)
 		// Example: rename flag
 		cmd.Flags().String("new-flag", "", "A new flag")
 		cmd.Flags().MarkDeprecated("old-flag", "use --new-flag instead")
 		return nil
 	})
+	if err == nil {
+		// Once the command is migrated to 2.0.0, which defines --new-flag, invocations pinned
+		// to 1.0.0 keep working, rewritten with a warning
+		err = GlobalVersionManager.AddArgvRules(RenameFlagRule("2.0.0", "example", "old-flag", "new-flag"))
+	}
+	if err != nil {
+		rootCmd.PrintErrln("Failed to set up versioning:", err)
+	}
 
 	rootCmd.AddCommand(cmd)
 }
\ No newline at end of file
@@ -0,0 +1,171 @@
+// Chained migrations between command versions
+package cobra
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MigrationStep is a migration registered from a version to another, with AddMigrationE.
+type MigrationStep struct {
+	From, To string
+}
+
+// MigrationPlan is the sequence of migrations leading from a version to another.
+type MigrationPlan struct {
+	From, To string
+	Steps    []MigrationStep
+}
+
+// String formats the plan as the versions it goes through, e.g. "1.0.0 -> 2.0.0 -> 3.0.0".
+func (p MigrationPlan) String() string {
+	versions := []string{p.From}
+	for _, step := range p.Steps {
+		versions = append(versions, step.To)
+	}
+	return strings.Join(versions, " -> ")
+}
+
+// Downgrade reports whether the plan leads to an earlier version.
+func (p MigrationPlan) Downgrade() bool {
+	return MustParseVersion(p.To).Less(MustParseVersion(p.From))
+}
+
+// AddMigration is like AddMigrationE but panics if the migration is rejected.
+func (vm *VersionManager) AddMigration(fromVersion, toVersion string, migration MigrationFunc) {
+	if err := vm.AddMigrationE(fromVersion, toVersion, migration); err != nil {
+		panic(fmt.Sprintf("cobra: AddMigration: %v", err))
+	}
+}
+
+// AddMigrationE registers the migration of commands from fromVersion to toVersion, which
+// replaces any migration registered for the same versions. Migrations to an earlier version
+// are downgrades. Migrations between other versions are chained, see PlanMigration. A
+// migration from a version to itself, even spelled differently, is rejected as a cycle.
+func (vm *VersionManager) AddMigrationE(fromVersion, toVersion string, migration MigrationFunc) error {
+	from, err := ParseVersion(fromVersion)
+	if err != nil {
+		return err
+	}
+	to, err := ParseVersion(toVersion)
+	if err != nil {
+		return err
+	}
+	if from.Compare(to) == 0 {
+		return fmt.Errorf("migration from %s to itself would form a cycle", from)
+	}
+	if vm.migrations == nil {
+		vm.migrations = make(map[MigrationStep]MigrationFunc)
+	}
+	vm.migrations[MigrationStep{From: from.String(), To: to.String()}] = migration
+	return nil
+}
+
+// PlanMigration returns the shortest sequence of registered migrations from fromVersion to
+// toVersion. An upgrade only goes through upgrades, and a downgrade through downgrades,
+// without going past toVersion, so that a plan never goes back to a version it left. It is
+// an error if there is no such sequence, or more than one of the shortest length, in which
+// case a direct migration, or one shortening either sequence, decides between them.
+func (vm *VersionManager) PlanMigration(fromVersion, toVersion string) (MigrationPlan, error) {
+	from, err := ParseVersion(fromVersion)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	to, err := ParseVersion(toVersion)
+	if err != nil {
+		return MigrationPlan{}, err
+	}
+	plan := MigrationPlan{From: from.String(), To: to.String()}
+	if from.Compare(to) == 0 {
+		return plan, nil
+	}
+	downgrade := to.Less(from)
+	// towards reports whether step moves towards the target without going past it.
+	towards := func(step MigrationStep) bool {
+		stepFrom, stepTo := MustParseVersion(step.From), MustParseVersion(step.To)
+		if downgrade {
+			return stepTo.Less(stepFrom) && to.Compare(stepTo) <= 0
+		}
+		return stepFrom.Less(stepTo) && stepTo.Compare(to) <= 0
+	}
+	steps := make(map[string][]MigrationStep)
+	for step := range vm.migrations {
+		if towards(step) {
+			steps[step.From] = append(steps[step.From], step)
+		}
+	}
+
+	// Breadth first search, recording every shortest way to reach each version.
+	depth := map[string]int{plan.From: 0}
+	previous := make(map[string][]MigrationStep)
+	frontier := []string{plan.From}
+	for len(frontier) > 0 && depth[plan.To] == 0 {
+		var next []string
+		for _, v := range frontier {
+			for _, step := range steps[v] {
+				d, seen := depth[step.To]
+				switch {
+				case !seen:
+					depth[step.To] = depth[v] + 1
+					next = append(next, step.To)
+					fallthrough
+				case d == depth[v]+1:
+					previous[step.To] = append(previous[step.To], step)
+				}
+			}
+		}
+		frontier = next
+	}
+	if _, ok := depth[plan.To]; !ok {
+		return plan, fmt.Errorf("no migration path from %s to %s", plan.From, plan.To)
+	}
+
+	paths := shortestMigrationPaths(previous, plan.From, plan.To, 2)
+	if len(paths) > 1 {
+		var alternatives []string
+		for _, path := range paths {
+			alternatives = append(alternatives, MigrationPlan{From: plan.From, To: plan.To, Steps: path}.String())
+		}
+		return plan, fmt.Errorf("ambiguous migration from %s to %s: %s", plan.From, plan.To, strings.Join(alternatives, " or "))
+	}
+	plan.Steps = paths[0]
+	return plan, nil
+}
+
+// shortestMigrationPaths returns up to limit paths from from to to, following the steps
+// recorded in previous backwards, in a deterministic order.
+func shortestMigrationPaths(previous map[string][]MigrationStep, from, to string, limit int) [][]MigrationStep {
+	if to == from {
+		return [][]MigrationStep{nil}
+	}
+	steps := append([]MigrationStep(nil), previous[to]...)
+	sort.Slice(steps, func(i, j int) bool {
+		return MustParseVersion(steps[i].From).Less(MustParseVersion(steps[j].From))
+	})
+	var paths [][]MigrationStep
+	for _, step := range steps {
+		for _, path := range shortestMigrationPaths(previous, from, step.From, limit-len(paths)) {
+			paths = append(paths, append(append([]MigrationStep(nil), path...), step))
+			if len(paths) == limit {
+				return paths
+			}
+		}
+	}
+	return paths
+}
+
+// MigrateCommand migrates cmd from fromVersion to toVersion, running the migrations of the
+// plan returned by PlanMigration in order. It stops at the first migration that fails.
+func (vm *VersionManager) MigrateCommand(cmd *Command, fromVersion, toVersion string) error {
+	plan, err := vm.PlanMigration(fromVersion, toVersion)
+	if err != nil {
+		return err
+	}
+	for _, step := range plan.Steps {
+		if err := vm.migrations[step](step.From, step.To, cmd); err != nil {
+			return fmt.Errorf("migration from %s to %s failed: %v", step.From, step.To, err)
+		}
+	}
+	return nil
+}
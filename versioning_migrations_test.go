@@ -0,0 +1,149 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// addMigrations registers migrations between pairs of versions that log their steps.
+func addMigrations(t *testing.T, vm *VersionManager, log *[]string, pairs ...string) {
+	t.Helper()
+	for _, pair := range pairs {
+		from, to, _ := strings.Cut(pair, ">")
+		err := vm.AddMigrationE(from, to, func(from, to string, cmd *Command) error {
+			*log = append(*log, from+">"+to)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPlanMigration(t *testing.T) {
+	tests := map[string]struct {
+		migrations []string
+		from, to   string
+		expected   string
+		err        string
+	}{
+		"direct":     {migrations: []string{"1.0>2.0"}, from: "1.0", to: "2.0", expected: "1.0.0 -> 2.0.0"},
+		"chained":    {migrations: []string{"1.0>2.0", "2.0>3.0"}, from: "v1.0.0", to: "3", expected: "1.0.0 -> 2.0.0 -> 3.0.0"},
+		"shortest":   {migrations: []string{"1.0>2.0", "2.0>3.0", "3.0>4.0", "2.0>4.0"}, from: "1.0", to: "4.0", expected: "1.0.0 -> 2.0.0 -> 4.0.0"},
+		"downgrade":  {migrations: []string{"1.0>2.0", "2.0>3.0", "3.0>2.0", "2.0>1.0"}, from: "3.0", to: "1.0", expected: "3.0.0 -> 2.0.0 -> 1.0.0"},
+		"same":       {from: "1.0", to: "v1.0.0", expected: "1.0.0"},
+		"no path":    {migrations: []string{"1.0>2.0"}, from: "2.0", to: "3.0", err: "no migration path from 2.0.0 to 3.0.0"},
+		"no reverse": {migrations: []string{"1.0>2.0"}, from: "2.0", to: "1.0", err: "no migration path from 2.0.0 to 1.0.0"},
+		"overshoot":  {migrations: []string{"1.0>4.0", "4.0>3.0"}, from: "1.0", to: "3.0", err: "no migration path"},
+		"cycle": {
+			migrations: []string{"1.0>2.0", "2.0>1.0", "2.0>3.0", "3.0>2.0"},
+			from:       "1.0", to: "3.0", expected: "1.0.0 -> 2.0.0 -> 3.0.0",
+		},
+		"ambiguous": {
+			migrations: []string{"1.0>2.0", "2.0>4.0", "1.0>3.0", "3.0>4.0"},
+			from:       "1.0", to: "4.0",
+			err: "ambiguous migration from 1.0.0 to 4.0.0: 1.0.0 -> 2.0.0 -> 4.0.0 or 1.0.0 -> 3.0.0 -> 4.0.0",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vm := &VersionManager{}
+			var log []string
+			addMigrations(t, vm, &log, tc.migrations...)
+			plan, err := vm.PlanMigration(tc.from, tc.to)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected error %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.String() != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, plan)
+			}
+		})
+	}
+}
+
+func TestAddMigrationRejectsCycles(t *testing.T) {
+	vm := &VersionManager{}
+	noop := func(from, to string, cmd *Command) error { return nil }
+	if err := vm.AddMigrationE("1.0", "v1.0.0", noop); err == nil {
+		t.Error("Expected a migration to the same version to be rejected")
+	}
+	if err := vm.AddMigrationE("1.0", "next", noop); err == nil {
+		t.Error("Expected an invalid version to be rejected")
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected AddMigration to panic for a rejected migration")
+		}
+	}()
+	vm.AddMigration("1.0", "1.0.0", noop)
+}
+
+func TestMigrateCommandRunsSteps(t *testing.T) {
+	vm := &VersionManager{}
+	var log []string
+	addMigrations(t, vm, &log, "1.0>2.0", "2.0>3.0")
+	if err := vm.MigrateCommand(&Command{Use: "app"}, "1.0", "3.0"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(log, " "); got != "1.0.0>2.0.0 2.0.0>3.0.0" {
+		t.Errorf("Expected both steps in order, got %q", got)
+	}
+
+	log = nil
+	vm.AddMigration("2.0", "3.0", func(from, to string, cmd *Command) error { return errors.New("boom") })
+	vm.AddMigration("3.0", "4.0", func(from, to string, cmd *Command) error {
+		t.Error("Expected the migration to stop at the failed step")
+		return nil
+	})
+	err := vm.MigrateCommand(&Command{Use: "app"}, "1.0", "4.0")
+	if err == nil || err.Error() != "migration from 2.0.0 to 3.0.0 failed: boom" {
+		t.Errorf("Expected the failed step, got %v", err)
+	}
+}
+
+func TestVersionMigrateDryRun(t *testing.T) {
+	vm := useVersionManager(t)
+	var log []string
+	addMigrations(t, vm, &log, "1.0>2.0", "2.0>3.0", "3.0>2.0")
+	rootCmd := &Command{Use: "app", Run: emptyRun}
+	rootCmd.AddCommand(CreateVersioningCommand())
+
+	output, err := executeCommand(rootCmd, "version", "migrate", "app", "1.0", "3.0", "--dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Upgrade of app from 1.0.0 to 3.0.0, 2 step(s):\n  1. 1.0.0 -> 2.0.0\n  2. 2.0.0 -> 3.0.0\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+	if len(log) != 0 {
+		t.Errorf("Expected no migration to run, got %v", log)
+	}
+
+	output, err = executeCommand(rootCmd, "version", "migrate", "app", "3.0", "2.0", "--dry-run")
+	if err != nil || !strings.HasPrefix(output, "Downgrade of app from 3.0.0 to 2.0.0, 1 step(s)") {
+		t.Errorf("Expected a downgrade, got %v %q", err, output)
+	}
+}
@@ -0,0 +1,445 @@
+// Rewriting of invocations written for older versions
+package cobra
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// ArgvRuleKind is the kind of change an ArgvRule rewrites.
+type ArgvRuleKind string
+
+const (
+	// ArgvRenameFlag rewrites --Flag into --To.
+	ArgvRenameFlag ArgvRuleKind = "rename-flag"
+	// ArgvRenameCommand rewrites the command path From into Command, which renames or moves
+	// a command, e.g. "config get" into "get-config".
+	ArgvRenameCommand ArgvRuleKind = "rename-command"
+	// ArgvMapValues rewrites the values of --Flag found in Values.
+	ArgvMapValues ArgvRuleKind = "map-values"
+	// ArgvSplitFlag rewrites --Flag=a<Separator>b into --Into[0]=a --Into[1]=b.
+	ArgvSplitFlag ArgvRuleKind = "split-flag"
+)
+
+// ArgvRule rewrites the arguments of invocations written for the versions before Version,
+// which changed the interface of a command, into the equivalent arguments of later versions.
+// Rules are added with VersionManager.AddArgvRules and applied before the invoked command is
+// looked up, to the invocations pinned to a version before Version, see --pin-version. A
+// warning shows the rewritten invocation. A rule is skipped while the flags or the command it
+// rewrites into do not exist in the command tree, unless a later rule rewrites them in turn.
+//
+// Flags are matched by their long name only.
+type ArgvRule struct {
+	Kind    ArgvRuleKind `json:"kind" yaml:"kind"`
+	Version string       `json:"version" yaml:"version"`
+	// Command is the path of the command below the root as of Version, e.g. "deploy", or
+	// empty for the root. Flag rules apply to the command and its subcommands.
+	Command string `json:"command,omitempty" yaml:"command,omitempty"`
+	// From is the path of the renamed command before Version.
+	From string `json:"from,omitempty" yaml:"from,omitempty"`
+	// Flag is the name of the flag before Version.
+	Flag string `json:"flag,omitempty" yaml:"flag,omitempty"`
+	// To is the new name of a renamed flag.
+	To string `json:"to,omitempty" yaml:"to,omitempty"`
+	// Values maps the old values of a flag to the new ones.
+	Values map[string]string `json:"values,omitempty" yaml:"values,omitempty"`
+	// Into are the flags a flag was split into, which take the parts of its value separated
+	// by Separator, in order.
+	Into      []string `json:"into,omitempty" yaml:"into,omitempty"`
+	Separator string   `json:"separator,omitempty" yaml:"separator,omitempty"`
+}
+
+// RenameFlagRule returns the rule for the flag --from of command renamed to --to in version.
+func RenameFlagRule(version, command, from, to string) ArgvRule {
+	return ArgvRule{Kind: ArgvRenameFlag, Version: version, Command: command, Flag: from, To: to}
+}
+
+// RenameCommandRule returns the rule for the command at path from below the root, renamed or
+// moved to path to in version.
+func RenameCommandRule(version, from, to string) ArgvRule {
+	return ArgvRule{Kind: ArgvRenameCommand, Version: version, Command: to, From: from}
+}
+
+// MapFlagValuesRule returns the rule for the values of the flag --name of command that were
+// renamed in version, values maps the old values to the new ones.
+func MapFlagValuesRule(version, command, name string, values map[string]string) ArgvRule {
+	return ArgvRule{Kind: ArgvMapValues, Version: version, Command: command, Flag: name, Values: values}
+}
+
+// SplitFlagRule returns the rule for the flag --name of command that was split into the flags
+// into in version. The parts of the old value separated by separator go to the new flags.
+func SplitFlagRule(version, command, name, separator string, into ...string) ArgvRule {
+	return ArgvRule{Kind: ArgvSplitFlag, Version: version, Command: command, Flag: name, Into: into, Separator: separator}
+}
+
+func (r ArgvRule) validate() error {
+	if _, err := ParseVersion(r.Version); err != nil {
+		return err
+	}
+	var missing string
+	switch r.Kind {
+	case ArgvRenameFlag:
+		if r.Flag == "" || r.To == "" {
+			missing = "flag and to"
+		}
+	case ArgvRenameCommand:
+		if r.From == "" || r.Command == "" {
+			missing = "from and command"
+		}
+	case ArgvMapValues:
+		if r.Flag == "" || len(r.Values) == 0 {
+			missing = "flag and values"
+		}
+	case ArgvSplitFlag:
+		if r.Flag == "" || len(r.Into) == 0 || r.Separator == "" {
+			missing = "flag, into and separator"
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", r.Kind)
+	}
+	if missing != "" {
+		return fmt.Errorf("%s rule needs %s", r.Kind, missing)
+	}
+	return nil
+}
+
+// AddArgvRules adds rules rewriting the invocations written for older versions.
+func (vm *VersionManager) AddArgvRules(rules ...ArgvRule) error {
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("invalid argv rule for version %s: %v", r.Version, err)
+		}
+	}
+	vm.argvRules = append(vm.argvRules, rules...)
+	sort.SliceStable(vm.argvRules, func(i, j int) bool {
+		return MustParseVersion(vm.argvRules[i].Version).Less(MustParseVersion(vm.argvRules[j].Version))
+	})
+	return nil
+}
+
+// RewriteArgs rewrites args, the arguments of an invocation of the program of root written
+// for version, with the rules for the later versions, in the order of their versions. An
+// empty version applies every rule. It returns the rewritten arguments and the descriptions
+// of the changes that were applied.
+func (vm *VersionManager) RewriteArgs(root *Command, args []string, version string) ([]string, []string) {
+	var pinned Version
+	if version != "" {
+		v, err := ParseVersion(version)
+		if err != nil {
+			return args, nil
+		}
+		pinned = v
+	}
+	takesValue := argvFlagsTakingValues(root, vm.argvRules)
+	live := liveArgvRules(root, vm.argvRules)
+	var applied []string
+	for i, r := range vm.argvRules {
+		if !live[i] || version != "" && !pinned.Less(MustParseVersion(r.Version)) {
+			continue
+		}
+		var rewritten []string
+		if r.Kind == ArgvRenameCommand {
+			rewritten = renameCommandArgs(args, takesValue, r)
+		} else {
+			rewritten = rewriteFlagArgs(args, takesValue, r)
+		}
+		if rewritten != nil {
+			args = rewritten
+			applied = append(applied, r.describe(root))
+		}
+	}
+	return args, applied
+}
+
+// liveArgvRules tells which of rules, sorted by version, rewrite into flags and commands that
+// exist in the tree of root, or that later rules rewrite in turn.
+func liveArgvRules(root *Command, rules []ArgvRule) []bool {
+	live := make([]bool, len(rules))
+	// renamed maps the old paths of the commands live rules rename to their current paths.
+	renamed := make(map[string]string)
+	current := func(path string) string {
+		if to, ok := renamed[path]; ok {
+			return to
+		}
+		return path
+	}
+	// rewritten holds the flags of the commands, by current path, that live rules rewrite.
+	rewritten := make(map[[2]string]bool)
+	for i := len(rules) - 1; i >= 0; i-- {
+		r := rules[i]
+		path := current(r.Command)
+		exists := func(flagName string) bool {
+			return rewritten[[2]string{path, flagName}] || hasLiveFlag(findCommandPath(root, path), flagName)
+		}
+		switch r.Kind {
+		case ArgvRenameCommand:
+			if live[i] = findCommandPath(root, path) != nil; live[i] {
+				renamed[r.From] = path
+			}
+			continue
+		case ArgvRenameFlag:
+			live[i] = exists(r.To)
+		case ArgvMapValues:
+			live[i] = exists(r.Flag)
+		case ArgvSplitFlag:
+			live[i] = true
+			for _, into := range r.Into {
+				live[i] = live[i] && exists(into)
+			}
+		}
+		if live[i] {
+			rewritten[[2]string{path, r.Flag}] = true
+		}
+	}
+	return live
+}
+
+// findCommandPath returns the command at path below root, e.g. "config get", or nil.
+func findCommandPath(root *Command, path string) *Command {
+	cmd := root
+	for _, name := range strings.Fields(path) {
+		if cmd = liveSubCommand(cmd, name); cmd == nil {
+			return nil
+		}
+	}
+	return cmd
+}
+
+// hasLiveFlag reports whether the flag name can be given to cmd or one of its subcommands,
+// which flag rules apply to as well.
+func hasLiveFlag(cmd *Command, name string) bool {
+	if cmd == nil {
+		return false
+	}
+	for p := cmd; p != nil; p = p.Parent() {
+		if p.PersistentFlags().Lookup(name) != nil {
+			return true
+		}
+	}
+	if cmd.Flags().Lookup(name) != nil {
+		return true
+	}
+	for _, sub := range cmd.Commands() {
+		if hasLiveFlag(sub, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ArgvRule) describe(root *Command) string {
+	version := MustParseVersion(r.Version)
+	switch r.Kind {
+	case ArgvRenameFlag:
+		return fmt.Sprintf("--%s was renamed to --%s in %s", r.Flag, r.To, version)
+	case ArgvRenameCommand:
+		return fmt.Sprintf("%q was renamed to %q in %s", root.Name()+" "+r.From, root.Name()+" "+r.Command, version)
+	case ArgvMapValues:
+		return fmt.Sprintf("values of --%s were renamed in %s", r.Flag, version)
+	}
+	return fmt.Sprintf("--%s was split into --%s in %s", r.Flag, strings.Join(r.Into, " and --"), version)
+}
+
+// argvFlagsTakingValues tells whether the flags of the program of root, and the old flags of
+// rules, consume the next argument when given without "=", by long name and shorthand.
+func argvFlagsTakingValues(root *Command, rules []ArgvRule) map[string]bool {
+	takesValue := map[string]bool{"h": false}
+	for name, v := range versionedBuiltinFlags {
+		takesValue[name] = v
+	}
+	add := func(f *flag.Flag) {
+		takesValue[f.Name] = f.NoOptDefVal == ""
+		if f.Shorthand != "" {
+			takesValue[f.Shorthand] = f.NoOptDefVal == ""
+		}
+	}
+	var walk func(cmd *Command)
+	walk = func(cmd *Command) {
+		cmd.LocalFlags().VisitAll(add)
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+	for _, r := range rules {
+		switch r.Kind {
+		case ArgvMapValues, ArgvSplitFlag:
+			takesValue[r.Flag] = true
+		case ArgvRenameFlag:
+			if v, ok := takesValue[r.To]; ok {
+				takesValue[r.Flag] = v
+			}
+		}
+	}
+	return takesValue
+}
+
+// positionalArgs returns the indices of the arguments that are neither flags nor their values,
+// up to "--". As Command.Find does, unknown flags are assumed to take a value.
+func positionalArgs(args []string, takesValue map[string]bool) []int {
+	var positions []int
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positions = append(positions, i)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") || (!strings.HasPrefix(arg, "--") && len(name) != 1) {
+			continue
+		}
+		if v, ok := takesValue[name]; !ok || v {
+			i++
+		}
+	}
+	return positions
+}
+
+// invokes reports whether the positional arguments of args start with the command path words.
+func invokes(args []string, positions []int, words []string) bool {
+	if len(positions) < len(words) {
+		return false
+	}
+	for i, word := range words {
+		if args[positions[i]] != word {
+			return false
+		}
+	}
+	return true
+}
+
+// renameCommandArgs returns args invoking the renamed command of r, or nil if args do not
+// invoke its old path.
+func renameCommandArgs(args []string, takesValue map[string]bool, r ArgvRule) []string {
+	from := strings.Fields(r.From)
+	positions := positionalArgs(args, takesValue)
+	if !invokes(args, positions, from) {
+		return nil
+	}
+	oldWords := make(map[int]bool)
+	for _, i := range positions[:len(from)] {
+		oldWords[i] = true
+	}
+	var rewritten []string
+	for i, arg := range args {
+		switch {
+		case i == positions[0]:
+			rewritten = append(rewritten, strings.Fields(r.Command)...)
+		case !oldWords[i]:
+			rewritten = append(rewritten, arg)
+		}
+	}
+	return rewritten
+}
+
+// rewriteFlagArgs returns args with the flag of r rewritten, or nil if they do not use it.
+func rewriteFlagArgs(args []string, takesValue map[string]bool, r ArgvRule) []string {
+	if !invokes(args, positionalArgs(args, takesValue), strings.Fields(r.Command)) {
+		return nil
+	}
+	var rewritten []string
+	changed := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rewritten = append(rewritten, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if !strings.HasPrefix(arg, "--") || name != r.Flag {
+			rewritten = append(rewritten, arg)
+			continue
+		}
+		if !hasValue && r.Kind != ArgvRenameFlag && i+1 < len(args) {
+			i++
+			value, hasValue = args[i], true
+		}
+
+		switch r.Kind {
+		case ArgvRenameFlag:
+			arg = "--" + r.To
+			if hasValue {
+				arg += "=" + value
+			}
+			rewritten = append(rewritten, arg)
+			changed = true
+		case ArgvMapValues:
+			if mapped, ok := r.Values[value]; ok {
+				value = mapped
+				changed = true
+			}
+			rewritten = append(rewritten, "--"+name+"="+value)
+		case ArgvSplitFlag:
+			for j, part := range strings.SplitN(value, r.Separator, len(r.Into)) {
+				rewritten = append(rewritten, "--"+r.Into[j]+"="+part)
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return rewritten
+}
+
+// rewriteArgs rewrites args, the arguments of an invocation of the root command c pinned to a
+// version, with the argv rules of GlobalVersionManager for the later versions, warning about
+// each change. Completion requests are not rewritten.
+func (c *Command) rewriteArgs(args []string) []string {
+	if GlobalVersionManager == nil || len(GlobalVersionManager.argvRules) == 0 {
+		return args
+	}
+	if len(args) > 0 && (args[0] == ShellCompRequestCmd || args[0] == ShellCompNoDescRequestCmd) {
+		return args
+	}
+	// Only the invocations pinned to an older version are written for an older version
+	pin := pinnedVersion(c, args)
+	if pin == "" {
+		return args
+	}
+	v, err := GlobalVersionManager.ResolveVersion(c, pin)
+	if err != nil {
+		return args
+	}
+	rewritten, applied := GlobalVersionManager.RewriteArgs(c, args, v.String())
+	if len(applied) == 0 {
+		return args
+	}
+	for _, change := range applied {
+		c.PrintErrln("Warning:", change)
+	}
+	// The modern invocation is no longer valid under the pinned version.
+	var modern []string
+	for i := 0; i < len(rewritten); i++ {
+		switch {
+		case rewritten[i] == "--":
+			modern = append(modern, rewritten[i:]...)
+			i = len(rewritten)
+		case rewritten[i] == "--"+pinVersionFlagName:
+			i++
+		case !strings.HasPrefix(rewritten[i], "--"+pinVersionFlagName+"="):
+			modern = append(modern, rewritten[i])
+		}
+	}
+	c.PrintErrln("Warning: use instead:", c.Name(), quoteArgs(modern))
+	return rewritten
+}
+
+// quoteArgs joins args with spaces, quoting those that would not be read back as one word.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = arg
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$") {
+			quoted[i] = strconv.Quote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
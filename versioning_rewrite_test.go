@@ -0,0 +1,164 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func rewriteCLI() *Command {
+	rootCmd := &Command{Use: "app", Run: emptyRun}
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output")
+	deployCmd := &Command{Use: "deploy", Run: emptyRun}
+	deployCmd.Flags().Int("count", 1, "number of replicas")
+	deployCmd.Flags().String("strategy", "rolling", "rolling or recreate")
+	deployCmd.Flags().String("env", "", "environment")
+	deployCmd.Flags().String("region", "", "region")
+	rootCmd.AddCommand(deployCmd, &Command{Use: "get-config", Run: emptyRun})
+	return rootCmd
+}
+
+func TestRewriteArgs(t *testing.T) {
+	vm := &VersionManager{}
+	err := vm.AddArgvRules(
+		RenameFlagRule("3.0", "deploy", "instances", "count"),
+		RenameFlagRule("2.0", "deploy", "replicas", "instances"),
+		RenameCommandRule("2.0", "config get", "get-config"),
+		MapFlagValuesRule("2.0", "deploy", "strategy", map[string]string{"fast": "rolling", "slow": "recreate"}),
+		SplitFlagRule("2.0", "deploy", "target", "/", "env", "region"),
+		RenameFlagRule("2.0", "deploy", "zone", "availability-zone"),
+		SplitFlagRule("2.0", "deploy", "place", "/", "env", "rack"),
+		RenameCommandRule("1.5", "cfg", "config get"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCmd := rewriteCLI()
+
+	tests := map[string]struct {
+		args     []string
+		version  string
+		expected string
+		changes  int
+	}{
+		"modern":           {args: []string{"deploy", "--count", "2"}, expected: "deploy --count 2"},
+		"rename flag":      {args: []string{"deploy", "--instances=2"}, version: "2.1", expected: "deploy --count=2", changes: 1},
+		"chained renames":  {args: []string{"deploy", "--replicas", "2"}, expected: "deploy --count 2", changes: 2},
+		"pinned renames":   {args: []string{"deploy", "--replicas", "2"}, version: "1.0", expected: "deploy --count 2", changes: 2},
+		"later pin":        {args: []string{"deploy", "--instances", "2"}, version: "3.0", expected: "deploy --instances 2"},
+		"move command":     {args: []string{"-v", "config", "--verbose", "get", "name"}, expected: "-v get-config --verbose name", changes: 1},
+		"map values":       {args: []string{"deploy", "--strategy", "slow"}, expected: "deploy --strategy=recreate", changes: 1},
+		"unmapped value":   {args: []string{"deploy", "--strategy=recreate"}, expected: "deploy --strategy=recreate"},
+		"split flag":       {args: []string{"deploy", "--target", "prod/eu", "x"}, expected: "deploy --env=prod --region=eu x", changes: 1},
+		"other command":    {args: []string{"get-config", "--replicas", "2"}, expected: "get-config --replicas 2"},
+		"flag before path": {args: []string{"--verbose", "deploy", "--replicas=2"}, expected: "--verbose deploy --count=2", changes: 2},
+		"after dashes":     {args: []string{"deploy", "--", "--replicas", "2"}, expected: "deploy -- --replicas 2"},
+		"missing flag":     {args: []string{"deploy", "--zone", "a"}, expected: "deploy --zone a"},
+		"missing split":    {args: []string{"deploy", "--place", "eu/1"}, expected: "deploy --place eu/1"},
+		"chained commands": {args: []string{"cfg", "name"}, expected: "get-config name", changes: 2},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			rewritten, changes := vm.RewriteArgs(rootCmd, tc.args, tc.version)
+			if got := strings.Join(rewritten, " "); got != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, got)
+			}
+			if len(changes) != tc.changes {
+				t.Errorf("Expected %d changes, got %q", tc.changes, changes)
+			}
+		})
+	}
+}
+
+func TestAddArgvRulesValidates(t *testing.T) {
+	vm := &VersionManager{}
+	for _, r := range []ArgvRule{
+		RenameFlagRule("latest", "deploy", "a", "b"),
+		RenameFlagRule("2.0", "deploy", "a", ""),
+		SplitFlagRule("2.0", "deploy", "target", ""),
+		{Kind: "drop-flag", Version: "2.0"},
+	} {
+		if err := vm.AddArgvRules(r); err == nil {
+			t.Errorf("Expected %+v to be rejected", r)
+		}
+	}
+}
+
+func TestExecuteRewritesArgs(t *testing.T) {
+	vm := useVersionManager(t)
+	oldCmd := &Command{Use: "app"}
+	oldDeploy := &Command{Use: "deploy"}
+	oldDeploy.Flags().Int("replicas", 1, "number of replicas")
+	oldCmd.AddCommand(oldDeploy)
+	for _, cmd := range []*Command{oldCmd, oldDeploy} {
+		if err := vm.RegisterCommand(cmd, "1.0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vm.AddArgvRules(RenameFlagRule("2.0", "deploy", "replicas", "count")); err != nil {
+		t.Fatal(err)
+	}
+
+	rootCmd := rewriteCLI()
+	deployCmd, _, _ := rootCmd.Find([]string{"deploy"})
+	var count int
+	deployCmd.Run = func(cmd *Command, args []string) {
+		count, _ = cmd.Flags().GetInt("count")
+	}
+	stderr := new(bytes.Buffer)
+	rootCmd.SetErr(stderr)
+	rootCmd.SetArgs([]string{"deploy", "--replicas", "3", "--pin-version", "1.0"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, stderr)
+	}
+	if count != 3 {
+		t.Errorf("Expected the old flag to be rewritten, got --count=%d", count)
+	}
+	for _, expected := range []string{
+		"Warning: --replicas was renamed to --count in 2.0.0",
+		"Warning: use instead: app deploy --count 3\n",
+	} {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("Expected %q in the warnings, got %q", expected, stderr.String())
+		}
+	}
+
+	rootCmd.SetArgs([]string{"deploy", "--count", "4"})
+	stderr.Reset()
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 || stderr.Len() != 0 {
+		t.Errorf("Expected the modern invocation to run unchanged, got --count=%d and %q", count, stderr.String())
+	}
+
+	// Invocations that are not pinned are written for the current version
+	rootCmd.SetArgs([]string{"deploy", "--replicas", "5"})
+	stderr.Reset()
+	if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown flag: --replicas") {
+		t.Errorf("Expected the old flag to be rejected without a pinned version, got %v", err)
+	}
+	if strings.Contains(stderr.String(), "Warning") {
+		t.Errorf("Expected no rewriting without a pinned version, got %q", stderr.String())
+	}
+}
+
+func TestQuoteArgs(t *testing.T) {
+	if got := quoteArgs([]string{"deploy", "--message=a b", ""}); got != `deploy "--message=a b" ""` {
+		t.Errorf("Unexpected quoting %s", got)
+	}
+}
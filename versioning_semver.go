@@ -0,0 +1,350 @@
+// Semantic versions and version ranges
+package cobra
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, see https://semver.org. The "v" prefix is accepted, and
+// missing minor and patch numbers are zero.
+type Version struct {
+	Major, Minor, Patch int
+	// Prerelease holds the dot separated identifiers following "-", e.g. ["rc", "1"].
+	Prerelease []string
+	// Build holds the build metadata following "+", which does not affect precedence.
+	Build string
+}
+
+// ParseVersion parses a semantic version such as "1.2.3", "v2.0.0-rc.1" or "1.4".
+func ParseVersion(s string) (Version, error) {
+	v, parts, err := parseVersionParts(s)
+	if err != nil {
+		return Version{}, err
+	}
+	for _, part := range parts {
+		if isVersionWildcard(part) {
+			return Version{}, fmt.Errorf("invalid version %q: wildcards are only allowed in ranges", s)
+		}
+	}
+	return v, nil
+}
+
+// MustParseVersion is like ParseVersion but panics if s cannot be parsed.
+func MustParseVersion(s string) Version {
+	v, err := ParseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// parseVersionParts parses a version whose numbers may be missing or wildcards, returning the
+// numbers as written. Wildcards are zero in the returned version.
+func parseVersionParts(s string) (Version, []string, error) {
+	var v Version
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if v.Build == "" {
+			return Version{}, nil, fmt.Errorf("invalid version %q: empty build metadata", s)
+		}
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = strings.Split(rest[i+1:], ".")
+		rest = rest[:i]
+		for _, id := range v.Prerelease {
+			if id == "" {
+				return Version{}, nil, fmt.Errorf("invalid version %q: empty prerelease identifier", s)
+			}
+		}
+	}
+
+	parts := strings.Split(rest, ".")
+	if rest == "" || len(parts) > 3 {
+		return Version{}, nil, fmt.Errorf("invalid version %q", s)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		if isVersionWildcard(part) {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (len(part) > 1 && part[0] == '0') {
+			return Version{}, nil, fmt.Errorf("invalid version %q", s)
+		}
+		*numbers[i] = n
+	}
+	return v, parts, nil
+}
+
+func isVersionWildcard(part string) bool {
+	return part == "x" || part == "X" || part == "*"
+}
+
+// String formats v as "MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]".
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 depending on whether v precedes, equals or follows o.
+// Build metadata is ignored.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+	// A version without prerelease has a higher precedence than its prereleases.
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrereleaseIdentifiers(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(v.Prerelease) - len(o.Prerelease))
+}
+
+// comparePrereleaseIdentifiers compares numeric identifiers numerically, and before
+// alphanumeric identifiers, which are compared lexically.
+func comparePrereleaseIdentifiers(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return sign(na - nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// Less reports whether v precedes o.
+func (v Version) Less(o Version) bool {
+	return v.Compare(o) < 0
+}
+
+// SortVersions sorts versions in ascending order of precedence.
+func SortVersions(versions []Version) {
+	sort.SliceStable(versions, func(i, j int) bool { return versions[i].Less(versions[j]) })
+}
+
+// VersionRange is a set of versions, such as "^1.2", "~1.4.0", ">=2.0 <3" or "1.x || >=3".
+//
+// A range is a list of comparator sets separated by "||", and matches the versions matched
+// by any of them. A comparator set is a list of space separated comparators, and matches the
+// versions matched by all of them. A comparator is one of:
+//
+//	1.2.3, =1.2.3   exactly 1.2.3
+//	1.2, 1.2.x      >=1.2.0 <1.3.0, and likewise 1 and 1.x mean >=1.0.0 <2.0.0
+//	>1.2, >=1.2     greater than, or equal to, 1.2.0; and <, <= likewise
+//	^1.2.3          >=1.2.3 <2.0.0, changes that do not modify the left-most non-zero number
+//	~1.2.3          >=1.2.3 <1.3.0, patch changes; ~1 means >=1.0.0 <2.0.0
+//	*, x            any version
+//
+// As is customary, prerelease versions are only matched by a comparator set in which one of
+// the comparators has a prerelease of the same major, minor and patch numbers.
+type VersionRange struct {
+	source string
+	sets   [][]versionComparator
+}
+
+type versionComparator struct {
+	op      string
+	version Version
+	// derived is set for the bounds derived from partial versions, e.g. <2.0.0-0 for ^1.2,
+	// whose "-0" prerelease only makes them exclude the prereleases of the bound.
+	derived bool
+}
+
+// ParseVersionRange parses a version range, see VersionRange.
+func ParseVersionRange(s string) (VersionRange, error) {
+	r := VersionRange{source: strings.TrimSpace(s)}
+	for _, set := range strings.Split(s, "||") {
+		var comparators []versionComparator
+		fields := strings.Fields(set)
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if strings.Trim(field, "<>=^~") == "" && i+1 < len(fields) {
+				// An operator separated from its version, as in ">= 2.0".
+				i++
+				field += fields[i]
+			}
+			cs, err := parseVersionComparator(field)
+			if err != nil {
+				return VersionRange{}, fmt.Errorf("invalid version range %q: %v", s, err)
+			}
+			comparators = append(comparators, cs...)
+		}
+		if comparators == nil {
+			// An empty set, e.g. "", matches any version.
+			comparators = []versionComparator{}
+		}
+		r.sets = append(r.sets, comparators)
+	}
+	return r, nil
+}
+
+// parseVersionComparator expands a comparator into primitive comparators.
+func parseVersionComparator(s string) ([]versionComparator, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op, s = prefix, s[len(prefix):]
+			break
+		}
+	}
+	if isVersionWildcard(s) {
+		return nil, nil
+	}
+	v, parts, err := parseVersionParts(s)
+	if err != nil {
+		return nil, err
+	}
+	// Number of leading numbers that were written, "1.x" and "1" both have one.
+	given := 0
+	for _, part := range parts {
+		if isVersionWildcard(part) {
+			break
+		}
+		given++
+	}
+	if given == 0 {
+		return nil, nil
+	}
+
+	// next returns the first version after the ones matching the n given numbers of v.
+	next := func(n int) Version {
+		switch n {
+		case 1:
+			return Version{Major: v.Major + 1, Prerelease: []string{"0"}}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1, Prerelease: []string{"0"}}
+		}
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, Prerelease: []string{"0"}}
+	}
+	lower := versionComparator{op: ">=", version: v}
+	upper := func(n int) versionComparator {
+		return versionComparator{op: "<", version: next(n), derived: true}
+	}
+	switch op {
+	case "", "=":
+		if given == 3 {
+			return []versionComparator{{op: "=", version: v}}, nil
+		}
+		return []versionComparator{lower, upper(given)}, nil
+	case "^":
+		n := 1
+		switch {
+		case v.Major == 0 && v.Minor == 0 && given == 3:
+			n = 3
+		case v.Major == 0 && given >= 2:
+			n = 2
+		}
+		return []versionComparator{lower, upper(n)}, nil
+	case "~":
+		if given == 1 {
+			return []versionComparator{lower, upper(1)}, nil
+		}
+		return []versionComparator{lower, upper(2)}, nil
+	case ">":
+		if given < 3 {
+			// >1.2 means greater than any 1.2.x version.
+			return []versionComparator{{op: ">=", version: next(given), derived: true}}, nil
+		}
+	case "<=":
+		if given < 3 {
+			return []versionComparator{upper(given)}, nil
+		}
+	}
+	return []versionComparator{{op: op, version: v}}, nil
+}
+
+// String returns the range as it was written.
+func (r VersionRange) String() string {
+	return r.source
+}
+
+// Contains reports whether v is in the range.
+func (r VersionRange) Contains(v Version) bool {
+	for _, set := range r.sets {
+		if setContains(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func setContains(set []versionComparator, v Version) bool {
+	for _, c := range set {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	if len(v.Prerelease) == 0 {
+		return true
+	}
+	for _, c := range set {
+		if !c.derived && len(c.version.Prerelease) > 0 &&
+			c.version.Major == v.Major && c.version.Minor == v.Minor && c.version.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionComparator) matches(v Version) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// Max returns the highest of versions in the range, and false if there is none.
+func (r VersionRange) Max(versions []Version) (Version, bool) {
+	var best Version
+	found := false
+	for _, v := range versions {
+		if r.Contains(v) && (!found || best.Less(v)) {
+			best, found = v, true
+		}
+	}
+	return best, found
+}
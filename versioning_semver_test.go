@@ -0,0 +1,108 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := map[string]string{
+		"1.2.3":               "1.2.3",
+		"v2.0.0-rc.1":         "2.0.0-rc.1",
+		"1.4":                 "1.4.0",
+		"3":                   "3.0.0",
+		"1.0.0-alpha+exp.sha": "1.0.0-alpha+exp.sha",
+	}
+	for input, want := range tests {
+		v, err := ParseVersion(input)
+		if err != nil {
+			t.Errorf("ParseVersion(%q): %v", input, err)
+			continue
+		}
+		if v.String() != want {
+			t.Errorf("ParseVersion(%q) = %s, want %s", input, v, want)
+		}
+	}
+
+	for _, input := range []string{"", "1.2.3.4", "01.2", "1.x", "a.b", "1.2.3-", "1.2.3-a..b", "1.2+"} {
+		if _, err := ParseVersion(input); err == nil {
+			t.Errorf("Expected ParseVersion(%q) to fail", input)
+		}
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	var versions []Version
+	for _, s := range []string{"1.10.0", "1.0.0", "1.0.0-rc.1", "1.0.0-alpha", "1.2.0", "1.0.0-alpha.1", "1.0.0-beta.11", "1.0.0-beta.2", "0.9.0"} {
+		versions = append(versions, MustParseVersion(s))
+	}
+	SortVersions(versions)
+
+	var got []string
+	for _, v := range versions {
+		got = append(got, v.String())
+	}
+	want := []string{"0.9.0", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.2.0", "1.10.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestVersionRange(t *testing.T) {
+	tests := []struct {
+		rng string
+		in  []string
+		out []string
+	}{
+		{"^1.2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0", "2.0.0-rc.1"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0", "0.2.2"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.10"}, []string{"1.3.0", "1.2.2"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{">=2.0 <3", []string{"2.0.0", "2.99.0"}, []string{"1.9.0", "3.0.0", "3.0.0-alpha"}},
+		{">= 2.0", []string{"2.0.0", "10.0.0"}, []string{"1.0.0"}},
+		{">1.2", []string{"1.3.0"}, []string{"1.2.9", "1.3.0-alpha"}},
+		{"<=1.2", []string{"1.2.9"}, []string{"1.3.0"}},
+		{"1.x || >=3", []string{"1.0.0", "1.5.0", "3.1.0"}, []string{"2.0.0"}},
+		{"1.2.3", []string{"1.2.3", "1.2.3+build"}, []string{"1.2.4"}},
+		{"*", []string{"0.0.1", "5.0.0"}, []string{"5.0.0-rc.1"}},
+		{">=1.0.0-rc.1 <2", []string{"1.0.0-rc.2", "1.5.0"}, []string{"1.1.0-rc.1", "1.0.0-beta"}},
+	}
+	for _, tc := range tests {
+		r, err := ParseVersionRange(tc.rng)
+		if err != nil {
+			t.Errorf("ParseVersionRange(%q): %v", tc.rng, err)
+			continue
+		}
+		for _, s := range tc.in {
+			if !r.Contains(MustParseVersion(s)) {
+				t.Errorf("Expected %q to contain %s", tc.rng, s)
+			}
+		}
+		for _, s := range tc.out {
+			if r.Contains(MustParseVersion(s)) {
+				t.Errorf("Expected %q not to contain %s", tc.rng, s)
+			}
+		}
+	}
+
+	for _, rng := range []string{">=a", "^1.2.3.4", "~x.1.y+"} {
+		if _, err := ParseVersionRange(rng); err == nil {
+			t.Errorf("Expected ParseVersionRange(%q) to fail", rng)
+		}
+	}
+}
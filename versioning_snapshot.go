@@ -0,0 +1,293 @@
+// Schema snapshots of command trees
+package cobra
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaSnapshot holds the schemas of a command and its subcommands at a version of the
+// program. Snapshots are meant to be committed with the program, in JSON or YAML files, as
+// the record of the interface of each release: see the "version snapshot" command,
+// AssertSchemaSnapshot and VersionManager.LoadSnapshots.
+type SchemaSnapshot struct {
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// Commands are sorted by path, the first one is the command the snapshot was taken of.
+	// Their version and hash are left out.
+	Commands []CommandSchema `json:"commands" yaml:"commands"`
+}
+
+// SnapshotSchemas returns the snapshot of the schemas of root and its subcommands at
+// version, which may be empty. The commands added by Cobra, such as the help command, and
+// by plugins are left out, so that the snapshot only depends on the program.
+func SnapshotSchemas(root *Command, version string) (*SchemaSnapshot, error) {
+	if version != "" {
+		v, err := ParseVersion(version)
+		if err != nil {
+			return nil, err
+		}
+		version = v.String()
+	}
+	s := &SchemaSnapshot{Version: version}
+	vm := &VersionManager{}
+	var walk func(cmd *Command)
+	walk = func(cmd *Command) {
+		schema := vm.computeSchema(cmd, "")
+		schema.SchemaHash = ""
+		s.Commands = append(s.Commands, schema)
+		for _, sub := range cmd.Commands() {
+			if isProgramCommand(sub) {
+				walk(sub)
+			}
+		}
+	}
+	walk(root)
+	sort.SliceStable(s.Commands, func(i, j int) bool { return s.Commands[i].CommandPath < s.Commands[j].CommandPath })
+	return s, nil
+}
+
+// Encode encodes s as YAML if format is "yaml" or "yml", and as indented JSON otherwise.
+// The encoding is deterministic, so that snapshot files only change with the program.
+func (s *SchemaSnapshot) Encode(format string) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		return yaml.Marshal(s)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// snapshotFormat returns the encoding of the snapshot file, from its extension.
+func snapshotFormat(file string) string {
+	if isYAMLFile(file) {
+		return "yaml"
+	}
+	return "json"
+}
+
+// ReadSchemaSnapshot reads a snapshot from a JSON or YAML file, depending on its extension.
+func ReadSchemaSnapshot(file string) (*SchemaSnapshot, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSchemaSnapshot(file, data)
+}
+
+func decodeSchemaSnapshot(file string, data []byte) (*SchemaSnapshot, error) {
+	var s SchemaSnapshot
+	var err error
+	if isYAMLFile(file) {
+		err = yaml.Unmarshal(data, &s)
+	} else {
+		err = json.Unmarshal(data, &s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema snapshot %s: %v", file, err)
+	}
+	return &s, nil
+}
+
+// WriteSchemaSnapshot writes s to a JSON or YAML file, depending on its extension.
+func WriteSchemaSnapshot(file string, s *SchemaSnapshot) error {
+	data, err := s.Encode(snapshotFormat(file))
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(file, data, 0644)
+}
+
+// DiffSnapshots returns the changes from the snapshot from to the snapshot to, see
+// DiffSchemas. The commands that were added or removed along with their parent are not
+// reported, the change to the parent is.
+func DiffSnapshots(from, to *SchemaSnapshot) []SchemaChange {
+	old := schemasByPath(from)
+	cur := schemasByPath(to)
+	var changes []SchemaChange
+	if from.Version != to.Version {
+		root := ""
+		if len(to.Commands) > 0 {
+			root = to.Commands[0].CommandPath
+		}
+		changes = append(changes, SchemaChange{
+			CommandPath: root,
+			Description: fmt.Sprintf("version changed from %q to %q", from.Version, to.Version),
+		})
+	}
+
+	paths := make([]string, 0, len(old)+len(cur))
+	for path := range old {
+		paths = append(paths, path)
+	}
+	for path := range cur {
+		if _, ok := old[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		o, inFrom := old[path]
+		c, inTo := cur[path]
+		switch {
+		case inFrom && inTo:
+			changes = append(changes, DiffSchemas(o, c)...)
+		case inFrom && !hasSnapshotParent(old, path):
+			changes = append(changes, SchemaChange{CommandPath: path, Breaking: true, Description: "command removed"})
+		case inTo && !hasSnapshotParent(cur, path):
+			changes = append(changes, SchemaChange{CommandPath: path, Description: "command added"})
+		}
+	}
+	return changes
+}
+
+func schemasByPath(s *SchemaSnapshot) map[string]CommandSchema {
+	commands := make(map[string]CommandSchema, len(s.Commands))
+	for _, schema := range s.Commands {
+		commands[schema.CommandPath] = schema
+	}
+	return commands
+}
+
+func hasSnapshotParent(commands map[string]CommandSchema, path string) bool {
+	i := strings.LastIndexByte(path, ' ')
+	if i < 0 {
+		return false
+	}
+	_, ok := commands[path[:i]]
+	return ok
+}
+
+// LoadSnapshot registers the schemas of the snapshot s at its version, as RegisterCommand
+// does for live commands.
+func (vm *VersionManager) LoadSnapshot(s *SchemaSnapshot) error {
+	v, err := ParseVersion(s.Version)
+	if err != nil {
+		return fmt.Errorf("cannot load schema snapshot: %v", err)
+	}
+	for _, schema := range s.Commands {
+		schema.Version = v.String()
+		if schema.Flags == nil {
+			schema.Flags = make(map[string]FlagSchema)
+		}
+		schema.SchemaHash = schemaHash(schema)
+		if err := vm.addSchema(schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSnapshots loads the snapshot files of fsys matching pattern, see fs.Glob, e.g. the
+// snapshots of each release embedded in the program:
+//
+//	//go:embed schemas/*.yaml
+//	var schemas embed.FS
+//
+//	err := cobra.GlobalVersionManager.LoadSnapshots(schemas, "schemas/*.yaml")
+func (vm *VersionManager) LoadSnapshots(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		s, err := decodeSchemaSnapshot(file, data)
+		if err != nil {
+			return err
+		}
+		if err := vm.LoadSnapshot(s); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+const updateSchemaSnapshotEnvVar = "UPDATE_SCHEMA_SNAPSHOT"
+
+// CheckSchemaSnapshot compares root and its subcommands, at the version of root, with the
+// snapshot in file and returns the changes, see DiffSnapshots.
+func CheckSchemaSnapshot(root *Command, file string) ([]SchemaChange, error) {
+	return checkSchemaSnapshot(root, root.Version, file)
+}
+
+func checkSchemaSnapshot(root *Command, version, file string) ([]SchemaChange, error) {
+	committed, err := ReadSchemaSnapshot(file)
+	if err != nil {
+		return nil, err
+	}
+	live, err := SnapshotSchemas(root, version)
+	if err != nil {
+		return nil, err
+	}
+	changes := DiffSnapshots(committed, live)
+	if len(changes) == 0 {
+		// Catch the differences DiffSchemas does not describe, such as reordered aliases.
+		format := snapshotFormat(file)
+		committedData, _ := committed.Encode(format)
+		liveData, _ := live.Encode(format)
+		if !bytes.Equal(committedData, liveData) {
+			changes = append(changes, SchemaChange{CommandPath: root.CommandPath(), Description: "snapshot differs"})
+		}
+	}
+	return changes, nil
+}
+
+// SchemaSnapshotT is the part of testing.TB used by AssertSchemaSnapshot.
+type SchemaSnapshotT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertSchemaSnapshot fails the test t if root and its subcommands differ from the snapshot
+// in file, listing the changes. Setting the <PROGRAM>_UPDATE_SCHEMA_SNAPSHOT or
+// COBRA_UPDATE_SCHEMA_SNAPSHOT environment variable to true writes the snapshot instead:
+//
+//	func TestSchema(t *testing.T) {
+//		cobra.AssertSchemaSnapshot(t, rootCmd, "testdata/schema.yaml")
+//	}
+func AssertSchemaSnapshot(t SchemaSnapshotT, root *Command, file string) {
+	t.Helper()
+	if update, _ := strconv.ParseBool(getEnvConfig(root, updateSchemaSnapshotEnvVar)); update {
+		s, err := SnapshotSchemas(root, root.Version)
+		if err == nil {
+			err = WriteSchemaSnapshot(file, s)
+		}
+		if err != nil {
+			t.Errorf("cannot update the schema snapshot: %v", err)
+		}
+		return
+	}
+
+	changes, err := CheckSchemaSnapshot(root, file)
+	if err != nil {
+		t.Errorf("cannot check the schema snapshot: %v", err)
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	var b strings.Builder
+	for _, c := range changes {
+		if c.Breaking {
+			fmt.Fprintf(&b, "\n  %s (breaking)", c)
+		} else {
+			fmt.Fprintf(&b, "\n  %s", c)
+		}
+	}
+	t.Errorf("%s differs from the schema snapshot %s:%s\nset %s=true to update the snapshot",
+		root.Name(), file, b.String(), configEnvVar(root.Root().Name(), updateSchemaSnapshotEnvVar))
+}
@@ -0,0 +1,286 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func snapshotCLI() *Command {
+	rootCmd := &Command{Use: "app", Version: "1.4.0", Run: emptyRun}
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output")
+	deployCmd := &Command{
+		Use:         "deploy <env>",
+		Short:       "Deploy the application",
+		Aliases:     []string{"d"},
+		Annotations: map[string]string{"group": "ops"},
+		ValidArgs:   []string{"prod", "staging"},
+		Args:        MatchAll(ExactArgs(1), OnlyValidArgs),
+		Run:         emptyRun,
+	}
+	deployCmd.Flags().IntP("replicas", "n", 3, "number of replicas")
+	deployCmd.Flags().String("region", "", "region to deploy to")
+	_ = deployCmd.MarkFlagRequired("region")
+	_ = deployCmd.Flags().SetAnnotation("replicas", "unit", []string{"pods"})
+	rootCmd.AddCommand(deployCmd, &Command{Use: "status", Args: NoArgs, Run: emptyRun})
+	return rootCmd
+}
+
+func encodeSnapshot(t *testing.T, root *Command, format string) string {
+	t.Helper()
+	s, err := SnapshotSchemas(root, root.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Encode(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSnapshotSchemas(t *testing.T) {
+	rootCmd := snapshotCLI()
+	s, err := SnapshotSchemas(rootCmd, "v1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Version != "1.4.0" {
+		t.Errorf("Expected the version to be normalized, got %q", s.Version)
+	}
+	var paths []string
+	for _, c := range s.Commands {
+		paths = append(paths, c.CommandPath)
+	}
+	if strings.Join(paths, ",") != "app,app deploy,app status" {
+		t.Fatalf("Unexpected commands %v", paths)
+	}
+
+	deploy := s.Commands[1]
+	replicas := deploy.Flags["replicas"]
+	if replicas.Type != "int" || replicas.Shorthand != "n" || replicas.Default != "3" || replicas.Annotations["unit"][0] != "pods" {
+		t.Errorf("Unexpected replicas flag %+v", replicas)
+	}
+	if !deploy.Flags["region"].Required {
+		t.Error("Expected the region flag to be required")
+	}
+	if deploy.Args != "1" || deploy.Annotations["group"] != "ops" || deploy.Aliases[0] != "d" {
+		t.Errorf("Unexpected deploy schema %+v", deploy)
+	}
+	if !s.Commands[0].Flags["verbose"].Persistent {
+		t.Error("Expected the verbose flag to be persistent")
+	}
+}
+
+func TestSnapshotSchemasIsDeterministic(t *testing.T) {
+	useVersionManager(t)
+	rootCmd := snapshotCLI()
+	for _, format := range []string{"json", "yaml"} {
+		before := encodeSnapshot(t, rootCmd, format)
+		if again := encodeSnapshot(t, rootCmd, format); again != before {
+			t.Errorf("Expected identical %s snapshots, got\n%s\nand\n%s", format, before, again)
+		}
+	}
+
+	// Executing adds the help, completion and other commands and flags of Cobra.
+	before := encodeSnapshot(t, rootCmd, "yaml")
+	if _, err := executeCommand(rootCmd, "status"); err != nil {
+		t.Fatal(err)
+	}
+	if after := encodeSnapshot(t, rootCmd, "yaml"); after != before {
+		t.Errorf("Expected the commands of Cobra to be left out, got\n%s\ninstead of\n%s", after, before)
+	}
+}
+
+func TestSchemaSnapshotFiles(t *testing.T) {
+	for _, name := range []string{"schema.json", "schema.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			rootCmd := snapshotCLI()
+			file := filepath.Join(t.TempDir(), name)
+			s, err := SnapshotSchemas(rootCmd, rootCmd.Version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteSchemaSnapshot(file, s); err != nil {
+				t.Fatal(err)
+			}
+			changes, err := CheckSchemaSnapshot(rootCmd, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) != 0 {
+				t.Errorf("Expected the snapshot to match, got %v", changes)
+			}
+
+			deployCmd, _, _ := rootCmd.Find([]string{"deploy"})
+			deployCmd.Aliases = nil
+			if changes, _ = CheckSchemaSnapshot(rootCmd, file); len(changes) != 1 || changes[0].String() != "app deploy: alias d removed" {
+				t.Errorf("Expected the removed alias, got %v", changes)
+			}
+		})
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	from, _ := SnapshotSchemas(snapshotCLI(), "1.4.0")
+	rootCmd := snapshotCLI()
+	deployCmd, _, _ := rootCmd.Find([]string{"deploy"})
+	deployCmd.AddCommand(&Command{Use: "rollback", Run: emptyRun})
+	rootCmd.RemoveCommand(rootCmd.Commands()[1])
+	to, _ := SnapshotSchemas(rootCmd, "2.0.0")
+
+	var got []string
+	for _, c := range DiffSnapshots(from, to) {
+		got = append(got, fmt.Sprintf("%s %v", c, c.Breaking))
+	}
+	expected := []string{
+		`app: version changed from "1.4.0" to "2.0.0" false`,
+		"app: subcommand status removed true",
+		"app deploy: subcommand rollback added false",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected changes\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+// recordingT records the errors of AssertSchemaSnapshot.
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertSchemaSnapshot(t *testing.T) {
+	t.Setenv("APP_UPDATE_SCHEMA_SNAPSHOT", "")
+	t.Setenv("COBRA_UPDATE_SCHEMA_SNAPSHOT", "")
+	file := filepath.Join(t.TempDir(), "testdata", "schema.yaml")
+	rootCmd := snapshotCLI()
+
+	rt := &recordingT{}
+	AssertSchemaSnapshot(rt, rootCmd, file)
+	if len(rt.errors) != 1 || !strings.Contains(rt.errors[0], "cannot check the schema snapshot") {
+		t.Fatalf("Expected a missing snapshot to fail, got %q", rt.errors)
+	}
+
+	t.Setenv("APP_UPDATE_SCHEMA_SNAPSHOT", "true")
+	rt = &recordingT{}
+	AssertSchemaSnapshot(rt, rootCmd, file)
+	if len(rt.errors) != 0 {
+		t.Fatalf("Expected the snapshot to be written, got %q", rt.errors)
+	}
+
+	t.Setenv("APP_UPDATE_SCHEMA_SNAPSHOT", "")
+	AssertSchemaSnapshot(rt, rootCmd, file)
+	if len(rt.errors) != 0 {
+		t.Fatalf("Expected the tree to match the snapshot, got %q", rt.errors)
+	}
+
+	deployCmd, _, _ := rootCmd.Find([]string{"deploy"})
+	deployCmd.ResetFlags()
+	deployCmd.Flags().IntP("replicas", "n", 3, "number of replicas")
+	AssertSchemaSnapshot(rt, rootCmd, file)
+	if len(rt.errors) != 1 {
+		t.Fatalf("Expected the tree to diverge, got %q", rt.errors)
+	}
+	for _, expected := range []string{"app deploy: flag --region removed (breaking)", "annotations of flag --replicas changed", "APP_UPDATE_SCHEMA_SNAPSHOT=true"} {
+		if !strings.Contains(rt.errors[0], expected) {
+			t.Errorf("Expected %q in the failure, got %q", expected, rt.errors[0])
+		}
+	}
+}
+
+func TestLoadSnapshots(t *testing.T) {
+	vm := useVersionManager(t)
+	old, _ := SnapshotSchemas(snapshotCLI(), "1.4.0")
+	rootCmd := snapshotCLI()
+	rootCmd.Version = "2.0.0"
+	rootCmd.RemoveCommand(rootCmd.Commands()[1])
+	cur, _ := SnapshotSchemas(rootCmd, rootCmd.Version)
+
+	fsys := fstest.MapFS{}
+	for _, s := range []*SchemaSnapshot{old, cur} {
+		data, err := s.Encode("yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsys["schemas/"+s.Version+".yaml"] = &fstest.MapFile{Data: data}
+	}
+	if err := vm.LoadSnapshots(fsys, "schemas/*.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if versions := vm.GetCompatibleVersions("app"); strings.Join(versions, ",") != "1.4.0,2.0.0" {
+		t.Errorf("Expected both versions to be loaded, got %v", versions)
+	}
+	changes, err := vm.DiffVersions("app", "1.4", "2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].String() != "app: subcommand status removed" {
+		t.Errorf("Expected the removed command, got %v", changes)
+	}
+	if err := vm.ValidateInvocation(rootCmd, []string{"deploy", "-n", "2", "prod"}, "1.4.0"); err != nil {
+		t.Errorf("Expected the invocation to be valid, got %v", err)
+	}
+
+	// The live tree registers the same schema as its snapshot.
+	if err := vm.RegisterCommand(rootCmd, rootCmd.Version); err != nil {
+		t.Errorf("Expected the live schema to match the snapshot, got %v", err)
+	}
+}
+
+func TestVersionSnapshotCommand(t *testing.T) {
+	useVersionManager(t)
+	rootCmd := snapshotCLI()
+	rootCmd.AddCommand(CreateVersioningCommand())
+	file := filepath.Join(t.TempDir(), "schema.json")
+
+	if output, err := executeCommand(rootCmd, "version", "snapshot", file); err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"path": "app deploy"`) || !strings.Contains(string(data), `"version": "1.4.0"`) {
+		t.Errorf("Unexpected snapshot %s", data)
+	}
+
+	output, err := executeCommand(rootCmd, "version", "snapshot", "--format", "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "path: app deploy") {
+		t.Errorf("Expected a YAML snapshot on the output, got %s", output)
+	}
+
+	if output, err = executeCommand(rootCmd, "version", "snapshot", "--check", file); err != nil {
+		t.Errorf("Expected the snapshot to be up to date, got %v\n%s", err, output)
+	}
+	rootCmd.AddCommand(&Command{Use: "logs", Run: emptyRun})
+	output, err = executeCommand(rootCmd, "version", "snapshot", "--check", file)
+	if err == nil || !strings.Contains(output, "app: subcommand logs added") {
+		t.Errorf("Expected the check to fail, got %v\n%s", err, output)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2013-2023 The Cobra Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobra
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// useVersionManager replaces GlobalVersionManager with an empty one for the duration of a test.
+func useVersionManager(t *testing.T) *VersionManager {
+	t.Helper()
+	previous := GlobalVersionManager
+	InitVersioning()
+	t.Cleanup(func() { GlobalVersionManager = previous })
+	return GlobalVersionManager
+}
+
+// versionedCLI registers three versions of a program: 1.0.0, 1.2.0 which adds deploy --dry-run,
+// and 2.0.0 which adds the status command and removes deploy --force.
+func versionedCLI(t *testing.T, vm *VersionManager) *Command {
+	t.Helper()
+	rootCmd := &Command{Use: "app", Run: emptyRun}
+	rootCmd.PersistentFlags().Bool("verbose", false, "verbose output")
+	deployCmd := &Command{Use: "deploy <env>", Run: emptyRun}
+	deployCmd.Flags().Bool("force", false, "force the deployment")
+	deployCmd.Flags().IntP("replicas", "n", 1, "number of replicas")
+	rootCmd.AddCommand(deployCmd)
+
+	register := func(version string, cmds ...*Command) {
+		for _, cmd := range cmds {
+			if err := vm.RegisterCommand(cmd, version); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	register("1.0", rootCmd, deployCmd)
+
+	deployCmd.Flags().Bool("dry-run", false, "only show what would be done")
+	register("1.2", deployCmd)
+
+	statusCmd := &Command{Use: "status", Run: emptyRun}
+	rootCmd.AddCommand(statusCmd)
+	deployCmd.ResetFlags()
+	deployCmd.Flags().IntP("replicas", "n", 1, "number of replicas")
+	deployCmd.Flags().Bool("dry-run", false, "only show what would be done")
+	register("2.0.0", rootCmd, deployCmd, statusCmd)
+	return rootCmd
+}
+
+func TestVersionsAreSorted(t *testing.T) {
+	vm := useVersionManager(t)
+	cmd := &Command{Use: "app"}
+	for _, v := range []string{"1.10.0", "v1.9", "1.2.0", "1.10.0-rc.1"} {
+		if err := vm.RegisterCommand(cmd, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vm.RegisterCommand(cmd, "latest"); err == nil {
+		t.Error("Expected an invalid version to be rejected")
+	}
+
+	want := []string{"1.2.0", "1.9.0", "1.10.0-rc.1", "1.10.0"}
+	if got := vm.GetCompatibleVersions("app"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	inRange, err := vm.VersionsInRange("app", ">=1.5 <2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inRange) != 2 || inRange[0].String() != "1.9.0" || inRange[1].String() != "1.10.0" {
+		t.Errorf("Unexpected versions in range: %v", inRange)
+	}
+}
+
+func TestExecuteWithFreeFormVersion(t *testing.T) {
+	vm := useVersionManager(t)
+	rootCmd := &Command{Use: "app", Version: "dev", Run: emptyRun}
+	rootCmd.SetArgs([]string{})
+	if err := rootCmd.Execute(); err != nil {
+		t.Errorf("Expected a program with a free-form version to run, got %v", err)
+	}
+	if versions := vm.TreeVersions(rootCmd); len(versions) != 0 {
+		t.Errorf("Expected no version to be registered, got %v", versions)
+	}
+}
+
+func TestExecuteWithCustomArgsValidator(t *testing.T) {
+	vm := useVersionManager(t)
+	calls := 0
+	rootCmd := &Command{
+		Use:     "app",
+		Version: "1.0.0",
+		Args: func(cmd *Command, args []string) error {
+			calls++
+			if _, err := strconv.Atoi(args[0]); err != nil {
+				return err
+			}
+			return nil
+		},
+		Run: emptyRun,
+	}
+
+	if _, err := executeCommand(rootCmd, "5"); err != nil {
+		t.Fatal(err)
+	}
+	if versions := vm.TreeVersions(rootCmd); len(versions) != 0 {
+		t.Errorf("Expected the execution not to register the command, got %v", versions)
+	}
+	if _, err := executeCommand(rootCmd, "--pin-version", "1.0", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the validator to be called once per execution, got %d calls", calls)
+	}
+	if args := vm.schemas["app"]["1.0.0"].Args; args != "unknown" {
+		t.Errorf("Expected the arguments of the custom validator to be unknown, got %q", args)
+	}
+}
+
+func TestValidateInvocation(t *testing.T) {
+	vm := useVersionManager(t)
+	rootCmd := versionedCLI(t, vm)
+
+	tests := []struct {
+		version string
+		args    string
+		err     string
+	}{
+		{"1.0.0", "deploy --force prod", ""},
+		{"1.0.0", "deploy -n 3 prod", ""},
+		{"1.0.0", "--verbose deploy -n3 --force=true prod", ""},
+		{"1.0.0", "deploy --dry-run prod", "unknown flag --dry-run"},
+		{"1.1.0", "status", `"app status" is not available in version 1.1.0, it was added in 2.0.0`},
+		{"1.5.0", "deploy --dry-run prod", ""},
+		{"2.0.0", "deploy --force prod", "unknown flag --force"},
+		{"2.0.0", "deploy -x prod", `unknown shorthand flag "x"`},
+		{"2.0.0", "status --verbose", ""},
+		{"2.0.0", "status --replicas 2", "unknown flag --replicas"},
+		{"2.0.0", "deploy prod -- --force", ""},
+		{"2.0.0", "deploy --help", ""},
+	}
+	for _, tc := range tests {
+		err := vm.ValidateInvocation(rootCmd, strings.Fields(tc.args), tc.version)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%q under %s: unexpected error %v", tc.args, tc.version, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q under %s: expected error %q, got %v", tc.args, tc.version, tc.err, err)
+		}
+	}
+
+	if schema, ok := vm.SchemaAt("app deploy", "1.9.9"); !ok || schema.Version != "1.2.0" {
+		t.Errorf("Expected the 1.2.0 schema to be in effect at 1.9.9, got %+v", schema)
+	}
+	if _, ok := vm.SchemaAt("app status", "1.9.9"); ok {
+		t.Error("Expected status to have no schema before 2.0.0")
+	}
+}
+
+func TestPinnedVersion(t *testing.T) {
+	vm := useVersionManager(t)
+	rootCmd := versionedCLI(t, vm)
+	stderr := new(bytes.Buffer)
+	rootCmd.SetErr(stderr)
+
+	rootCmd.SetArgs([]string{"--pin-version", "^1.0", "status"})
+	if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "not available in version 1.2.0") {
+		t.Errorf("Expected the invocation to be rejected under the pinned version, got %v", err)
+	}
+
+	rootCmd.SetArgs([]string{"deploy", "--pin-version=1.x", "--force", "prod"})
+	if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "unknown flag") {
+		// The pinned version accepts --force, but the current command does not.
+		t.Errorf("Expected the current command to reject the removed flag, got %v", err)
+	}
+
+	t.Setenv("APP_PIN_VERSION", "^2")
+	rootCmd.SetArgs([]string{"status"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Errorf("Expected the invocation to be valid under the pinned version, got %v", err)
+	}
+
+	t.Setenv("APP_PIN_VERSION", "^3")
+	rootCmd.SetArgs([]string{"status"})
+	if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "no version of app matches ^3") {
+		t.Errorf("Expected an unknown pinned version to be rejected, got %v", err)
+	}
+}
+
+func TestPinnedVersionWithoutSnapshots(t *testing.T) {
+	vm := useVersionManager(t)
+	oldDeploy := &Command{Use: "deploy"}
+	oldDeploy.Flags().Int("replicas", 1, "number of replicas")
+	(&Command{Use: "app"}).AddCommand(oldDeploy)
+	if err := vm.RegisterCommand(oldDeploy, "1.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The root has no version, and the current version of deploy is only known from the tree.
+	rootCmd := &Command{Use: "app", Run: emptyRun}
+	deployCmd := &Command{Use: "deploy", Version: "2.0", Run: emptyRun}
+	deployCmd.Flags().Int("count", 1, "number of replicas")
+	rootCmd.AddCommand(deployCmd)
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"deploy", "--replicas", "3", "--pin-version", "2.0"}, "unknown flag --replicas"},
+		{[]string{"deploy", "--count", "3", "--pin-version", "1.0"}, "unknown flag --count"},
+		{[]string{"deploy", "--replicas", "3", "--pin-version", "1.0"}, "unknown flag: --replicas"},
+		{[]string{"deploy", "--count", "3", "--pin-version", "2.0"}, ""},
+	}
+	for _, tc := range tests {
+		_, err := executeCommand(rootCmd, tc.args...)
+		switch {
+		case tc.err == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tc.args, err)
+		case tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)):
+			t.Errorf("%q: expected error %q, got %v", tc.args, tc.err, err)
+		}
+	}
+}